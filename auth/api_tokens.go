@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// API_TOKEN_PREFIX marks personal access tokens so GetUserInfo can tell them apart from JWTs.
+const API_TOKEN_PREFIX = "ssw_pat_"
+
+// API_TOKEN_TOUCH_INTERVAL limits how often a token's last used time is written.
+const API_TOKEN_TOUCH_INTERVAL = time.Minute
+
+// NewApiToken creates a personal access token for the caller in claims, granting the given
+// scopes until expiresAt. The token is returned once, only its hash is stored.
+func NewApiToken(ctx context.Context, claims types.Claims, name string, scopes []string, expiresAt time.Time) (string, types.ApiToken, types.Error) {
+	userId, err := strconv.Atoi(claims.Id)
+	if err != nil {
+		return "", types.ApiToken{}, customerrors.New(http.StatusInternalServerError, "could not convert user id to an int")
+	}
+
+	secret := randomToken(32)
+	if secret == "" {
+		return "", types.ApiToken{}, customerrors.New(http.StatusInternalServerError, "could not generate token")
+	}
+
+	tokenString := API_TOKEN_PREFIX + secret
+	token := types.ApiToken{
+		UserId:    userId,
+		Name:      name,
+		TokenHash: HashToken(tokenString),
+		Scopes:    scopes,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	}
+
+	var customErr types.Error
+	token.Id, customErr = apiTokens.CreateApiToken(ctx, token)
+	if customErr != nil {
+		return "", types.ApiToken{}, customErr
+	}
+
+	return tokenString, token, nil
+}
+
+// getApiTokenClaims checks a personal access token and returns claims for its owner. The
+// token's scopes are narrowed to what the owner would be issued now, so taking permissions
+// away from their role, or requiring two-factor authentication for it, also limits their tokens.
+func getApiTokenClaims(ctx context.Context, tokenString string) (types.Claims, types.Error) {
+	token, customErr := apiTokens.GetApiTokenByHash(ctx, HashToken(tokenString))
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusNotFound {
+			return types.Claims{}, customerrors.New(http.StatusUnauthorized, "invalid token")
+		}
+		return types.Claims{}, customErr
+	}
+
+	if token.RevokedAt != 0 {
+		return types.Claims{}, customerrors.New(http.StatusUnauthorized, "token has been revoked")
+	}
+
+	now := time.Now()
+	if now.Unix() > token.ExpiresAt {
+		return types.Claims{}, customerrors.New(http.StatusUnauthorized, "token has expired")
+	}
+
+	user, customErr := users.GetUser(ctx, strconv.Itoa(token.UserId))
+	if customErr != nil {
+		return types.Claims{}, customErr
+	}
+
+	claims, customErr := userClaims(ctx, user)
+	if customErr != nil {
+		return types.Claims{}, customErr
+	}
+
+	granted := map[string]bool{}
+	for _, permission := range claims.Scopes {
+		granted[permission] = true
+	}
+
+	scopes := make([]string, 0, len(token.Scopes))
+	for _, scope := range token.Scopes {
+		if granted[scope] {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if now.Sub(time.Unix(token.LastUsedAt, 0)) >= API_TOKEN_TOUCH_INTERVAL {
+		customErr = apiTokens.TouchApiToken(ctx, token.Id, now.Unix())
+		if customErr != nil {
+			log.Printf("could not update last used time of token %d: %s", token.Id, customErr.Description())
+		}
+	}
+
+	claims.Scopes = scopes
+	claims.ApiTokenId = token.Id
+	claims.ExpiresAt = token.ExpiresAt
+
+	return claims, nil
+}
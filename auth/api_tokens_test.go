@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestApiTokenScopesFollowRole(t *testing.T) {
+	ctx := context.Background()
+	store := useMemoryStore(t)
+	user := testUser(t, store, "ida", ROLE_EDITOR)
+	claims, customErr := userClaims(ctx, user)
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+
+	token, _, customErr := NewApiToken(ctx, claims, "script", []string{PERMISSION_ARTISTS_READ, PERMISSION_ARTISTS_UPDATE}, time.Now().Add(time.Hour))
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+
+	claims, customErr = getApiTokenClaims(ctx, token)
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+	if !HasPermission(claims, PERMISSION_ARTISTS_UPDATE) || claims.ApiTokenId == 0 {
+		t.Fatalf("token has scopes %v", claims.Scopes)
+	}
+
+	// Taking a permission away from the role takes it away from tokens already issued.
+	rolePermissions = map[string][]string{ROLE_EDITOR: {PERMISSION_ARTISTS_READ}}
+	t.Cleanup(loadRolePermissions)
+
+	claims, customErr = getApiTokenClaims(ctx, token)
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+	if len(claims.Scopes) != 1 || claims.Scopes[0] != PERMISSION_ARTISTS_READ {
+		t.Fatalf("token has scopes %v after the role lost %s", claims.Scopes, PERMISSION_ARTISTS_UPDATE)
+	}
+}
@@ -1,134 +1,121 @@
 package auth
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"github.com/AnthonyNixon/setsisaw/customerrors"
 	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/mail"
 	"github.com/AnthonyNixon/setsisaw/types"
 	"github.com/AnthonyNixon/setsisaw/utils"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-var JWT_SIGNING_KEY []byte
+var users database.UserStore
+var sessions database.SessionStore
+var apiTokens database.ApiTokenStore
+var resets database.PasswordResetStore
+var verifications database.EmailVerificationStore
+var identities database.IdentityStore
+var twoFactors database.TwoFactorStore
+var settings database.SettingsStore
+var mailer mail.Mailer
 
 const TOKEN_VALID_TIME = 1 * time.Hour // TODO: Change to 5 minutes for prod
 
-func Initialize() {
+func Initialize(store database.AuthStore, authMailer mail.Mailer) {
 	log.Print("Initializing Authentication")
-	signingKey := os.Getenv("JWT_SIGNING_KEY")
-	if signingKey == "" {
-		log.Fatal("No Signing Key Present.")
-	}
-
-	JWT_SIGNING_KEY = []byte(signingKey)
+	users = store
+	sessions = store
+	apiTokens = store
+	resets = store
+	verifications = store
+	identities = store
+	twoFactors = store
+	settings = store
+	mailer = authMailer
+	loadKeyring()
+	loadRolePermissions()
+	loadPasswordPolicy()
+	loadEmailVerificationPolicy()
+	loadLoginThrottlePolicy(store)
+	loadOidcProvider()
 	log.Print("done")
 }
 
-func IsAuthed(username string, password string) (bool, error) {
-	db, err := database.GetConnection()
-	if err != nil {
-		return false, err
-	}
-	defer db.Close()
-
-	var storedPassword string
-	result := db.QueryRow("select password FROM users where username = ?", username)
-	if err != nil {
-		return false, err
-	}
-
-	err = result.Scan(&storedPassword)
-	if err != nil {
+func IsAuthed(ctx context.Context, username string, password string) (bool, error) {
+	user, customErr := users.GetUserByUsername(ctx, username)
+	if customErr != nil {
 		// If an entry with the username does not exist, send an "Unauthorized"(401) status
-		if err == sql.ErrNoRows {
+		if customErr.StatusCode() == http.StatusNotFound {
 			return false, nil
 		}
 
-		return false, nil
+		return false, errors.New(customErr.Description())
 	}
 
 	// Compare the stored hashed password, with the hashed version of the password that was received
-	if err = bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password)); err != nil {
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
 		return false, nil
 	}
 
+	rehashPassword(ctx, user, password)
 	return true, nil
 }
 
+// NewToken issues an access token that is not tied to a session.
 func NewToken(username string, role string, id string) (string, types.Error) {
-	var jwtKey = JWT_SIGNING_KEY
-
-	expirationTime := time.Now().Add(TOKEN_VALID_TIME)
-	claims := &types.Claims{
-		Username: username,
-		Role:     role,
-		Id:       id,
-		StandardClaims: jwt.StandardClaims{
-			// In JWT, the expiry time is expressed as unix milliseconds
-			ExpiresAt: expirationTime.Unix(),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
-	if err != nil {
-		return "", customerrors.New(http.StatusInternalServerError, err.Error())
-	}
-
-	return tokenString, nil
+	tokenString, _, customErr := newToken(types.Claims{Username: username, Role: role, Id: id, Scopes: PermissionsForRole(role)}, TOKEN_VALID_TIME)
+	return tokenString, customErr
 }
 
-func RefreshToken(c *gin.Context) (string, types.Error) {
-	authHeader := c.GetHeader("Authorization")
-	tokenString, err := utils.GetTokenFromHeader(authHeader)
-	if err != nil {
-		return "", customerrors.New(http.StatusInternalServerError, err.Error())
-	}
-
-	claims := &types.Claims{}
+// newToken signs claims with a new jti, valid for validFor from now.
+func newToken(claims types.Claims, validFor time.Duration) (string, types.Claims, types.Error) {
+	// The jti, checked against the revocation list on every request.
+	claims.StandardClaims.Id = randomToken(16)
+	// In JWT, the expiry time is expressed as unix seconds
+	claims.ExpiresAt = time.Now().Add(validFor).Unix()
 
-	// Parse the JWT string and store the result in `claims`.
-	// Note that we are passing the key in this method as well. This method will return an error
-	// if the token is invalid (if it has expired according to the expiry time we set on sign in),
-	// or if the signature does not match
-	tkn, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return JWT_SIGNING_KEY, nil
-	})
+	if claims.StandardClaims.Id == "" {
+		return "", claims, customerrors.New(http.StatusInternalServerError, "could not generate token id")
+	}
 
+	tokenString, err := signToken(claims)
 	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			return "", customerrors.New(http.StatusUnauthorized, "signature invalid, "+err.Error())
-		}
-
-		if !tkn.Valid {
-			return "", customerrors.New(http.StatusUnauthorized, err.Error())
-		}
+		return "", claims, customerrors.New(http.StatusInternalServerError, err.Error())
+	}
 
-		return "", customerrors.New(http.StatusBadRequest, "Invalid JWT token, "+err.Error())
+	return tokenString, claims, nil
+}
 
+// userClaims returns the claims to issue to user, with the scopes their role grants. Users
+// whose role requires two-factor authentication only get a USER's scopes until they enable it.
+func userClaims(ctx context.Context, user types.User) (types.Claims, types.Error) {
+	claims := types.Claims{
+		Username: user.Username,
+		Role:     user.Role,
+		Id:       user.Id,
+		Scopes:   PermissionsForRole(user.Role),
 	}
 
-	if time.Unix(claims.ExpiresAt, 0).Sub(time.Now()) > time.Minute {
-		return "", customerrors.New(http.StatusBadRequest, "too early to refresh token, token is valid for more than 1 minute.")
+	pending, customErr := twoFactorPending(ctx, user)
+	if customErr != nil {
+		return claims, customErr
 	}
 
-	expirationTime := time.Now().Add(TOKEN_VALID_TIME)
-	claims.ExpiresAt = expirationTime.Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err = token.SignedString(JWT_SIGNING_KEY)
-	if err != nil {
-		return "", customerrors.New(http.StatusInternalServerError, err.Error())
+	if pending {
+		claims.Scopes = PermissionsForRole(ROLE_USER)
+		claims.EnrollTwoFactor = true
 	}
 
-	return tokenString, nil
+	return claims, nil
 }
 
 func GetUserInfo(c *gin.Context) (types.Claims, types.Error) {
@@ -137,23 +124,25 @@ func GetUserInfo(c *gin.Context) (types.Claims, types.Error) {
 
 	tokenString, err := utils.GetTokenFromHeader(authHeader)
 	if err != nil {
-		return *claims, customerrors.New(http.StatusInternalServerError, err.Error())
+		return *claims, customerrors.New(http.StatusUnauthorized, err.Error())
+	}
+
+	if strings.HasPrefix(tokenString, API_TOKEN_PREFIX) {
+		return getApiTokenClaims(c.Request.Context(), tokenString)
 	}
 
 	// Parse the JWT string and store the result in `claims`.
-	// Note that we are passing the key in this method as well. This method will return an error
+	// The key is picked from the keyring by the token's kid. This method will return an error
 	// if the token is invalid (if it has expired according to the expiry time we set on sign in),
 	// or if the signature does not match
-	tkn, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return JWT_SIGNING_KEY, nil
-	})
+	tkn, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 
 	if err != nil {
 		if err == jwt.ErrSignatureInvalid {
 			return *claims, customerrors.New(http.StatusUnauthorized, "signature invalid, "+err.Error())
 		}
 
-		if !tkn.Valid {
+		if tkn != nil && !tkn.Valid {
 			return *claims, customerrors.New(http.StatusUnauthorized, err.Error())
 		}
 
@@ -161,28 +150,23 @@ func GetUserInfo(c *gin.Context) (types.Claims, types.Error) {
 
 	}
 
-	return *claims, nil
-}
+	if claims.StandardClaims.Id == "" {
+		return *claims, customerrors.New(http.StatusUnauthorized, "token has no id, sign in again")
+	}
 
-func IsEntitled(claims types.Claims, requirement string) bool {
-	switch strings.ToUpper(claims.Role) {
-	case "USER":
-		switch requirement {
-		case "USER":
-			return true
-		default:
-			return false
-		}
-	case "EDITOR":
-		switch requirement {
-		case "USER", "EDITOR":
-			return true
-		default:
-			return false
-		}
-	case "ADMIN":
-		return true
-	default:
-		return false
+	// Tokens with an audience, like two-factor challenges, are only good for their one purpose.
+	if claims.Audience != "" {
+		return *claims, customerrors.New(http.StatusUnauthorized, "token can not be used to call the API")
 	}
+
+	revoked, customErr := sessions.IsTokenRevoked(c.Request.Context(), claims.StandardClaims.Id)
+	if customErr != nil {
+		return *claims, customErr
+	}
+
+	if revoked {
+		return *claims, customerrors.New(http.StatusUnauthorized, "token has been revoked")
+	}
+
+	return *claims, nil
 }
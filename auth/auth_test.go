@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/mail"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("JWT_SIGNING_KEY", "test-signing-key")
+	os.Setenv("SETSISAW_BCRYPT_COST", "4")
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// useMemoryStore initializes the package with a fresh memory store and returns it.
+func useMemoryStore(t *testing.T) *database.MemoryStore {
+	store := database.NewMemoryStore()
+	Initialize(store, mail.LogMailer{})
+	return store
+}
+
+// testUser creates a user with role in store and returns them as read back from it.
+func testUser(t *testing.T, store *database.MemoryStore, username string, role string) types.User {
+	ctx := context.Background()
+	id, customErr := store.CreateUser(ctx, types.User{Username: username, Email: username + "@example.com"})
+	if customErr != nil {
+		t.Fatalf("could not create user %s: %s", username, customErr.Description())
+	}
+
+	customErr = store.UpdateUser(ctx, types.User{Id: strconv.Itoa(id), Username: username, Email: username + "@example.com", EmailVerified: true})
+	if customErr != nil {
+		t.Fatalf("could not verify the email of %s: %s", username, customErr.Description())
+	}
+
+	if role != ROLE_USER {
+		customErr = store.ChangeUserRole(ctx, types.RoleChange{UserId: id, ChangedBy: id, OldRole: ROLE_USER, NewRole: role})
+		if customErr != nil {
+			t.Fatalf("could not make %s an %s: %s", username, role, customErr.Description())
+		}
+	}
+
+	user, customErr := store.GetUser(ctx, strconv.Itoa(id))
+	if customErr != nil {
+		t.Fatalf("could not read user %s: %s", username, customErr.Description())
+	}
+
+	return user
+}
+
+func userId(t *testing.T, user types.User) int {
+	id, err := strconv.Atoi(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+// tokenClaims returns the claims of a token issued by this package, failing unless it was.
+func tokenClaims(t *testing.T, token string) types.Claims {
+	claims := types.Claims{}
+	_, err := jwt.ParseWithClaims(token, &claims, verificationKey)
+	if err != nil {
+		t.Fatalf("could not parse token: %s", err.Error())
+	}
+
+	return claims
+}
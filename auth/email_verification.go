@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/mail"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const EMAIL_VERIFICATION_VALID_TIME = 24 * time.Hour
+
+// RequireVerifiedEmailToSignIn stops SignIn issuing tokens to users who have not verified their
+// email, set with SETSISAW_REQUIRE_VERIFIED_EMAIL_TO_SIGN_IN=true.
+var RequireVerifiedEmailToSignIn = false
+
+// RequireVerifiedEmailToCreate makes RequireVerifiedEmail reject users who have not verified
+// their email, set with SETSISAW_REQUIRE_VERIFIED_EMAIL_TO_CREATE=true.
+var RequireVerifiedEmailToCreate = false
+
+func loadEmailVerificationPolicy() {
+	RequireVerifiedEmailToSignIn = os.Getenv("SETSISAW_REQUIRE_VERIFIED_EMAIL_TO_SIGN_IN") == "true"
+	RequireVerifiedEmailToCreate = os.Getenv("SETSISAW_REQUIRE_VERIFIED_EMAIL_TO_CREATE") == "true"
+}
+
+// SendEmailVerification mails user a one time token that verifies their current email.
+func SendEmailVerification(ctx context.Context, user types.User) types.Error {
+	userId, err := strconv.Atoi(user.Id)
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, "could not convert user id to an int")
+	}
+
+	token := randomToken(32)
+	if token == "" {
+		return customerrors.New(http.StatusInternalServerError, "could not generate verification token")
+	}
+
+	now := time.Now()
+	_, customErr := verifications.CreateEmailVerification(ctx, types.EmailVerification{
+		UserId:    userId,
+		Email:     user.Email,
+		TokenHash: HashToken(token),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(EMAIL_VERIFICATION_VALID_TIME).Unix(),
+	})
+	if customErr != nil {
+		return customErr
+	}
+
+	sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your SetsISaw email",
+		Body: fmt.Sprintf("Hi %s,\n\nTo confirm this is your email, %s\n\nIt expires in %d hours. If you did not sign up for SetsISaw you can ignore this email.",
+			user.Username, tokenInstructions("SETSISAW_VERIFY_EMAIL_URL", token, "follow this link", "send this token to GET /verify-email?token="), int(EMAIL_VERIFICATION_VALID_TIME.Hours())),
+	}, userId)
+
+	return nil
+}
+
+// ResendEmailVerification sends a new verification token to the user with the given email.
+// Nothing is sent, and no error returned, when no user has that email or it is already verified.
+func ResendEmailVerification(ctx context.Context, email string) types.Error {
+	user, customErr := users.GetUserByEmail(ctx, email)
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusNotFound {
+			return nil
+		}
+		return customErr
+	}
+
+	if user.EmailVerified {
+		return nil
+	}
+
+	return SendEmailVerification(ctx, user)
+}
+
+// VerifyEmail marks the email a token from SendEmailVerification was sent to as verified.
+func VerifyEmail(ctx context.Context, token string) types.Error {
+	invalid := customerrors.New(http.StatusBadRequest, "invalid or expired verification token")
+
+	verification, customErr := verifications.GetEmailVerificationByHash(ctx, HashToken(token))
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusNotFound {
+			return invalid
+		}
+		return customErr
+	}
+
+	if verification.UsedAt != 0 || time.Now().Unix() > verification.ExpiresAt {
+		return invalid
+	}
+
+	user, customErr := users.GetUser(ctx, strconv.Itoa(verification.UserId))
+	if customErr != nil {
+		return customErr
+	}
+
+	if !strings.EqualFold(user.Email, verification.Email) {
+		return customerrors.New(http.StatusBadRequest, "your email has changed since this token was sent, verify the new one")
+	}
+
+	// A second token for an already verified email has nothing left to do.
+	if user.EmailVerified {
+		return nil
+	}
+
+	customErr = verifications.VerifyEmail(ctx, verification)
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusConflict {
+			return invalid
+		}
+		return customErr
+	}
+
+	return nil
+}
+
+// CheckCanSignIn returns a 403 when user may not sign in yet because their email is unverified.
+func CheckCanSignIn(user types.User) types.Error {
+	if RequireVerifiedEmailToSignIn && !user.EmailVerified {
+		return customerrors.New(http.StatusForbidden, "verify your email before signing in, POST /verify-email/resend to get a new link")
+	}
+
+	return nil
+}
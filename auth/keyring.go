@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LEGACY_KEY_ID identifies the HS256 secret from JWT_SIGNING_KEY. Tokens issued before key ids
+// were added have no kid and are checked against it.
+const LEGACY_KEY_ID = "default"
+
+// signingKey is one key in the keyring. Keys that only have a verifyKey can check tokens they
+// signed in the past but can not sign new ones.
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// keyringFile is the shape of the file SETSISAW_KEYRING_FILE points at. Key files are PEM and
+// relative paths are resolved from the keyring file's directory.
+type keyringFile struct {
+	SigningKid string `json:"signing_kid"`
+	Keys       []struct {
+		Kid            string `json:"kid"`
+		Alg            string `json:"alg"`
+		PrivateKeyFile string `json:"private_key_file"`
+		PublicKeyFile  string `json:"public_key_file"`
+	} `json:"keys"`
+}
+
+// JWK is a public key as served from /.well-known/jwks.json.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+var keys map[string]*signingKey
+var currentKey *signingKey
+
+// loadKeyring reads the keys tokens are signed and verified with. Without SETSISAW_KEYRING_FILE
+// the HS256 secret in JWT_SIGNING_KEY is the only key. With it, JWT_SIGNING_KEY is optional and
+// only verifies tokens that were signed before moving to the keyring.
+func loadKeyring() {
+	keys = map[string]*signingKey{}
+
+	secret := os.Getenv("JWT_SIGNING_KEY")
+	if secret != "" {
+		keys[LEGACY_KEY_ID] = &signingKey{kid: LEGACY_KEY_ID, method: jwt.SigningMethodHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+	}
+
+	path := os.Getenv("SETSISAW_KEYRING_FILE")
+	if path == "" {
+		if secret == "" {
+			log.Fatal("No Signing Key Present.")
+		}
+		currentKey = keys[LEGACY_KEY_ID]
+		return
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("could not read keyring file %s: %s", path, err.Error())
+	}
+
+	var file keyringFile
+	err = json.Unmarshal(raw, &file)
+	if err != nil {
+		log.Fatalf("could not parse keyring file %s: %s", path, err.Error())
+	}
+
+	for _, entry := range file.Keys {
+		if entry.Kid == "" || keys[entry.Kid] != nil {
+			log.Fatalf("keyring file %s has a missing or duplicate kid %q", path, entry.Kid)
+		}
+
+		key := &signingKey{kid: entry.Kid}
+		switch entry.Alg {
+		case jwt.SigningMethodRS256.Alg():
+			key.method = jwt.SigningMethodRS256
+		case SigningMethodEdDSA.Alg():
+			key.method = SigningMethodEdDSA
+		default:
+			log.Fatalf("key %s in keyring file %s has unsupported alg %q, use RS256 or EdDSA", entry.Kid, path, entry.Alg)
+		}
+
+		if entry.PrivateKeyFile != "" {
+			key.signKey, key.verifyKey, err = readPrivateKey(resolvePath(path, entry.PrivateKeyFile))
+		} else if entry.PublicKeyFile != "" {
+			key.verifyKey, err = readPublicKey(resolvePath(path, entry.PublicKeyFile))
+		} else {
+			err = errors.New("private_key_file or public_key_file is required")
+		}
+
+		if err == nil {
+			err = checkKeyType(key)
+		}
+
+		if err != nil {
+			log.Fatalf("could not load key %s from keyring file %s: %s", entry.Kid, path, err.Error())
+		}
+
+		keys[key.kid] = key
+	}
+
+	currentKey = keys[file.SigningKid]
+	if currentKey == nil || currentKey.signKey == nil {
+		log.Fatalf("signing_kid %q in keyring file %s must name a key with a private_key_file", file.SigningKid, path)
+	}
+
+	log.Printf("Signing tokens with key %s (%s), %d keys can verify", currentKey.kid, currentKey.method.Alg(), len(keys))
+}
+
+// signToken signs claims with the current key and sets its kid in the token header.
+func signToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(currentKey.method, claims)
+	token.Header["kid"] = currentKey.kid
+	return token.SignedString(currentKey.signKey)
+}
+
+// verificationKey is the jwt.Keyfunc for tokens we issued. The token's alg has to match the
+// key's, otherwise a public key could be passed off as an HMAC secret.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = LEGACY_KEY_ID
+	}
+
+	key := keys[kid]
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("key %s does not sign with %s", kid, token.Method.Alg())
+	}
+
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public half of every asymmetric key in the keyring, so other services can
+// verify our tokens. The HS256 secret is never published.
+func JWKS() []JWK {
+	jwks := make([]JWK, 0, len(keys))
+	for _, key := range keys {
+		switch publicKey := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "RSA",
+				Kid: key.kid,
+				Alg: key.method.Alg(),
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				Kty: "OKP",
+				Kid: key.kid,
+				Alg: key.method.Alg(),
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].Kid < jwks[j].Kid })
+	return jwks
+}
+
+func resolvePath(keyringPath string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(keyringPath), path)
+}
+
+func readPrivateKey(path string) (crypto.Signer, crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("unsupported private key type")
+	}
+
+	return signer, signer.Public(), nil
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+
+	return block, nil
+}
+
+// checkKeyType makes sure a key file holds the kind of key its alg says.
+func checkKeyType(key *signingKey) error {
+	switch key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		if key.method == jwt.SigningMethodRS256 {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if key.method == SigningMethodEdDSA {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("key file does not hold a %s key", key.method.Alg())
+}
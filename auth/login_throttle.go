@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/env"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const LOCKOUT_KIND_USERNAME = "username"
+const LOCKOUT_KIND_EMAIL = "email"
+const LOCKOUT_KIND_IP = "ip"
+
+// Failures beyond the free attempts wait 1s, 2s, 4s... up to LOGIN_MAX_BACKOFF before the next
+// attempt. An IP address is shared by everyone behind it, so it gets more room than a username.
+const LOGIN_FREE_ATTEMPTS = 3
+const LOGIN_FREE_IP_ATTEMPTS = 20
+const LOGIN_MAX_BACKOFF = 5 * time.Minute
+
+// LoginThrottlePolicy decides when failed sign ins lock a username or IP address out. Failures
+// older than LockoutDuration are forgotten.
+type LoginThrottlePolicy struct {
+	MaxFailures     int
+	MaxIpFailures   int
+	LockoutDuration time.Duration
+}
+
+var loginThrottlePolicy LoginThrottlePolicy
+var throttles database.LoginThrottleStore
+
+// Lockout is a username or IP address with recent failed sign ins, or an email with recent
+// password reset requests, as shown to admins.
+type Lockout struct {
+	Kind          string `json:"kind"`
+	Value         string `json:"value"`
+	Failures      int    `json:"failures"`
+	LastFailureAt int64  `json:"last_failure_at"`
+	LockedUntil   int64  `json:"locked_until,omitempty"`
+	RetryAfter    int64  `json:"retry_after"`
+}
+
+// throttledError is returned for sign ins refused because of earlier failures.
+type throttledError struct {
+	types.Error
+	retryAfter time.Duration
+}
+
+// loadLoginThrottlePolicy reads the policy, and where failures are tracked from
+// SETSISAW_LOGIN_THROTTLE_STORE: "database" (the default) shares them between every server
+// using store, "memory" keeps them in this process only.
+func loadLoginThrottlePolicy(store database.LoginThrottleStore) {
+	loginThrottlePolicy = LoginThrottlePolicy{
+		MaxFailures:     env.Int("SETSISAW_LOGIN_MAX_FAILURES", 10, LOGIN_FREE_ATTEMPTS+1, 1000),
+		MaxIpFailures:   env.Int("SETSISAW_LOGIN_MAX_IP_FAILURES", 100, LOGIN_FREE_IP_ATTEMPTS+1, 100000),
+		LockoutDuration: env.Duration("SETSISAW_LOGIN_LOCKOUT_DURATION", 15*time.Minute, time.Second),
+	}
+
+	switch os.Getenv("SETSISAW_LOGIN_THROTTLE_STORE") {
+	case "", "database":
+		throttles = store
+	case "memory":
+		throttles = database.NewMemoryStore()
+	default:
+		log.Fatalf("SETSISAW_LOGIN_THROTTLE_STORE must be database or memory")
+	}
+}
+
+// LOGIN_ATTEMPT_RETRIES bounds how often an attempt reads the throttles again after a
+// concurrent attempt changed them.
+const LOGIN_ATTEMPT_RETRIES = 5
+
+// BeginLoginAttempt refuses a sign in for username from ip while either is locked out or
+// backing off. Otherwise it counts the attempt as a failure before the password or code is
+// checked, so concurrent attempts can not all slip through the same check. Finish it with
+// RecordLoginFailure, RecordLoginSuccess or CancelLoginAttempt. Use RetryAfter on the error for
+// how long the caller has to wait.
+func BeginLoginAttempt(ctx context.Context, username string, ip string) types.Error {
+	return beginAttempt(ctx, throttleKeys(LOCKOUT_KIND_USERNAME, username, ip), "too many failed sign in attempts")
+}
+
+// BeginPasswordResetRequest refuses a password reset for email from ip while either has asked
+// too often, and counts the request otherwise. Requests are never taken back, so they back off
+// like failed sign ins whether or not an account uses email.
+func BeginPasswordResetRequest(ctx context.Context, email string, ip string) types.Error {
+	return beginAttempt(ctx, throttleKeys(LOCKOUT_KIND_EMAIL, email, ip), "too many password reset requests")
+}
+
+// beginAttempt refuses an attempt while any of keys is locked out or backing off, and counts
+// it against all of them otherwise.
+func beginAttempt(ctx context.Context, keys []string, refused string) types.Error {
+	for i := 0; i < LOGIN_ATTEMPT_RETRIES; i++ {
+		now := time.Now()
+
+		var wait time.Duration
+		current := make([]types.LoginThrottle, 0, len(keys))
+		for _, key := range keys {
+			throttle, customErr := throttles.GetLoginThrottle(ctx, key)
+			if customErr != nil {
+				return customErr
+			}
+
+			if until := allowedAt(throttle, now); until.Sub(now) > wait {
+				wait = until.Sub(now)
+			}
+			current = append(current, throttle)
+		}
+
+		if wait > 0 {
+			// Round up, so clients honouring Retry-After are not refused again.
+			wait = wait.Truncate(time.Second) + time.Second
+			return &throttledError{
+				Error:      customerrors.New(http.StatusTooManyRequests, fmt.Sprintf("%s, try again in %d seconds", refused, int64(wait.Seconds()))),
+				retryAfter: wait,
+			}
+		}
+
+		customErr := countAttempt(ctx, current, now)
+		if customErr == nil || customErr.StatusCode() != http.StatusConflict {
+			return customErr
+		}
+	}
+
+	return customerrors.New(http.StatusTooManyRequests, "too many concurrent attempts, try again")
+}
+
+// RecordLoginFailure finishes an attempt whose password or code was wrong, locking username or
+// ip out once either reaches its limit.
+func RecordLoginFailure(ctx context.Context, username string, ip string) {
+	now := time.Now()
+	for _, key := range throttleKeys(LOCKOUT_KIND_USERNAME, username, ip) {
+		throttle, customErr := throttles.GetLoginThrottle(ctx, key)
+		if customErr != nil {
+			log.Printf("could not read failed sign ins for %s: %s", key, customErr.Description())
+			continue
+		}
+
+		if throttle.Failures < maxFailures(key) || throttle.LockedUntil > now.Unix() {
+			continue
+		}
+
+		log.Printf("Locking out %s after %d failed sign ins", key, throttle.Failures)
+		customErr = throttles.LockLogin(ctx, key, now.Add(loginThrottlePolicy.LockoutDuration).Unix())
+		if customErr != nil {
+			log.Printf("could not lock out %s: %s", key, customErr.Description())
+		}
+	}
+}
+
+// RecordLoginSuccess finishes an attempt that signed in, forgetting the failures for username.
+// The IP address only gets this attempt back, so signing in to one account does not reset
+// guessing at others.
+func RecordLoginSuccess(ctx context.Context, username string, ip string) {
+	key := throttleKey(LOCKOUT_KIND_USERNAME, username)
+	customErr := throttles.ClearLoginThrottle(ctx, key)
+	if customErr != nil {
+		log.Printf("could not clear failed sign ins for %s: %s", key, customErr.Description())
+	}
+
+	if ip != "" {
+		uncountAttempt(ctx, throttleKey(LOCKOUT_KIND_IP, ip))
+	}
+}
+
+// CancelLoginAttempt finishes an attempt that neither failed nor signed in, such as a correct
+// password that still needs a second factor, taking it back from username and ip.
+func CancelLoginAttempt(ctx context.Context, username string, ip string) {
+	for _, key := range throttleKeys(LOCKOUT_KIND_USERNAME, username, ip) {
+		uncountAttempt(ctx, key)
+	}
+}
+
+// RetryAfter returns how long to wait before signing in again if customErr refused a sign in
+// because of earlier failures, 0 otherwise.
+func RetryAfter(customErr types.Error) time.Duration {
+	if throttled, ok := customErr.(*throttledError); ok {
+		return throttled.retryAfter
+	}
+
+	return 0
+}
+
+// GetLockouts returns the usernames and IP addresses that are locked out or have failed to sign
+// in recently, most recent first.
+func GetLockouts(ctx context.Context) ([]Lockout, types.Error) {
+	now := time.Now()
+	active, customErr := throttles.GetActiveLoginThrottles(ctx, now.Unix(), now.Add(-loginThrottlePolicy.LockoutDuration).Unix())
+	if customErr != nil {
+		return nil, customErr
+	}
+
+	lockouts := make([]Lockout, 0, len(active))
+	for _, throttle := range active {
+		kind := strings.SplitN(throttle.Key, ":", 2)
+		if len(kind) != 2 {
+			continue
+		}
+
+		lockout := Lockout{
+			Kind:          kind[0],
+			Value:         kind[1],
+			Failures:      throttle.Failures,
+			LastFailureAt: throttle.LastFailureAt,
+		}
+		if throttle.LockedUntil > now.Unix() {
+			lockout.LockedUntil = throttle.LockedUntil
+		}
+		if wait := allowedAt(throttle, now).Sub(now); wait > 0 {
+			lockout.RetryAfter = int64(wait.Truncate(time.Second).Seconds()) + 1
+		}
+
+		lockouts = append(lockouts, lockout)
+	}
+
+	return lockouts, nil
+}
+
+// ClearLockout forgets the failed sign ins for a username or IP address, or the password reset
+// requests for an email, ending any lockout.
+func ClearLockout(ctx context.Context, kind string, value string) types.Error {
+	if kind != LOCKOUT_KIND_USERNAME && kind != LOCKOUT_KIND_EMAIL && kind != LOCKOUT_KIND_IP {
+		return customerrors.New(http.StatusBadRequest, fmt.Sprintf("kind must be %s, %s or %s", LOCKOUT_KIND_USERNAME, LOCKOUT_KIND_EMAIL, LOCKOUT_KIND_IP))
+	}
+
+	return throttles.ClearLoginThrottle(ctx, throttleKey(kind, value))
+}
+
+// allowedAt returns when throttle next allows a sign in, which may be in the past.
+func allowedAt(throttle types.LoginThrottle, now time.Time) time.Time {
+	if throttle.LockedUntil > now.Unix() {
+		return time.Unix(throttle.LockedUntil, 0)
+	}
+
+	free := LOGIN_FREE_ATTEMPTS
+	if strings.HasPrefix(throttle.Key, LOCKOUT_KIND_IP+":") {
+		free = LOGIN_FREE_IP_ATTEMPTS
+	}
+
+	lastFailure := time.Unix(throttle.LastFailureAt, 0)
+	if throttle.Failures <= free || now.Sub(lastFailure) > loginThrottlePolicy.LockoutDuration {
+		return time.Time{}
+	}
+
+	backoff := LOGIN_MAX_BACKOFF
+	if shift := throttle.Failures - free - 1; shift < 16 {
+		backoff = time.Duration(1<<uint(shift)) * time.Second
+		if backoff > LOGIN_MAX_BACKOFF {
+			backoff = LOGIN_MAX_BACKOFF
+		}
+	}
+
+	return lastFailure.Add(backoff)
+}
+
+// countAttempt counts a failure against each throttle as it was read, taking back the ones
+// already counted if another attempt changed a later one first.
+func countAttempt(ctx context.Context, current []types.LoginThrottle, now time.Time) types.Error {
+	forgetBefore := now.Add(-loginThrottlePolicy.LockoutDuration).Unix()
+	for i, throttle := range current {
+		_, customErr := throttles.AddLoginFailure(ctx, throttle, now.Unix(), forgetBefore)
+		if customErr != nil {
+			for _, counted := range current[:i] {
+				uncountAttempt(ctx, counted.Key)
+			}
+			return customErr
+		}
+	}
+
+	return nil
+}
+
+func uncountAttempt(ctx context.Context, key string) {
+	customErr := throttles.RemoveLoginFailure(ctx, key)
+	if customErr != nil {
+		log.Printf("could not take back sign in attempt for %s: %s", key, customErr.Description())
+	}
+}
+
+func maxFailures(key string) int {
+	if strings.HasPrefix(key, LOCKOUT_KIND_IP+":") {
+		return loginThrottlePolicy.MaxIpFailures
+	}
+
+	return loginThrottlePolicy.MaxFailures
+}
+
+func throttleKeys(kind string, value string, ip string) []string {
+	keys := []string{throttleKey(kind, value)}
+	if ip != "" {
+		keys = append(keys, throttleKey(LOCKOUT_KIND_IP, ip))
+	}
+
+	return keys
+}
+
+// throttleKey identifies what failures are counted against. Usernames and emails are compared
+// without case, so changing it does not get around a lockout.
+func throttleKey(kind string, value string) string {
+	if kind == LOCKOUT_KIND_USERNAME || kind == LOCKOUT_KIND_EMAIL {
+		value = strings.ToLower(value)
+	}
+
+	return truncate(kind+":"+value, 255)
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failLogin makes one wrong guess for username from ip, failing the test if it was refused.
+func failLogin(t *testing.T, username string, ip string) {
+	ctx := context.Background()
+	customErr := BeginLoginAttempt(ctx, username, ip)
+	if customErr != nil {
+		t.Fatalf("attempt for %s from %s was refused: %s", username, ip, customErr.Description())
+	}
+	RecordLoginFailure(ctx, username, ip)
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	ctx := context.Background()
+	useMemoryStore(t)
+
+	for i := 0; i <= LOGIN_FREE_ATTEMPTS; i++ {
+		failLogin(t, "ida", "192.0.2.1")
+	}
+
+	customErr := BeginLoginAttempt(ctx, "ida", "192.0.2.1")
+	if customErr == nil || customErr.StatusCode() != http.StatusTooManyRequests {
+		t.Fatalf("an attempt after %d failures was not refused", LOGIN_FREE_ATTEMPTS+1)
+	}
+	if wait := RetryAfter(customErr); wait < time.Second || wait > 2*time.Second {
+		t.Fatalf("retry after %s for the first backoff", wait)
+	}
+
+	// Failures for a username follow it to other addresses, but do not hold up other users.
+	customErr = BeginLoginAttempt(ctx, "IDA", "198.51.100.1")
+	if RetryAfter(customErr) == 0 {
+		t.Fatal("the username was not throttled from another address")
+	}
+	failLogin(t, "eve", "192.0.2.1")
+
+	// Once the backoff is over a success forgets the username's failures, not the address's.
+	time.Sleep(RetryAfter(customErr))
+	customErr = BeginLoginAttempt(ctx, "ida", "192.0.2.1")
+	if customErr != nil {
+		t.Fatalf("attempt after the backoff was refused: %s", customErr.Description())
+	}
+	RecordLoginSuccess(ctx, "ida", "192.0.2.1")
+
+	username, _ := throttles.GetLoginThrottle(ctx, throttleKey(LOCKOUT_KIND_USERNAME, "ida"))
+	ip, _ := throttles.GetLoginThrottle(ctx, throttleKey(LOCKOUT_KIND_IP, "192.0.2.1"))
+	if username.Failures != 0 || ip.Failures != LOGIN_FREE_ATTEMPTS+2 {
+		t.Fatalf("%d failures left for the username and %d for the address", username.Failures, ip.Failures)
+	}
+}
+
+func TestLoginThrottleIp(t *testing.T) {
+	ctx := context.Background()
+	useMemoryStore(t)
+
+	// Guessing one password for many users is throttled by address.
+	usernames := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p", "q", "r", "s", "t", "u"}
+	for _, username := range usernames[:LOGIN_FREE_IP_ATTEMPTS+1] {
+		failLogin(t, username, "192.0.2.1")
+	}
+
+	customErr := BeginLoginAttempt(ctx, "ida", "192.0.2.1")
+	if RetryAfter(customErr) == 0 {
+		t.Fatalf("address was not throttled after %d failures", LOGIN_FREE_IP_ATTEMPTS+1)
+	}
+
+	customErr = BeginLoginAttempt(ctx, "ida", "198.51.100.1")
+	if customErr != nil {
+		t.Fatalf("another address was refused: %s", customErr.Description())
+	}
+	CancelLoginAttempt(ctx, "ida", "198.51.100.1")
+
+	ip, _ := throttles.GetLoginThrottle(ctx, throttleKey(LOCKOUT_KIND_IP, "198.51.100.1"))
+	if ip.Failures != 0 {
+		t.Fatalf("a cancelled attempt left %d failures", ip.Failures)
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	ctx := context.Background()
+	useMemoryStore(t)
+
+	// Failures from long enough ago that their backoff is over, but not forgotten.
+	key := throttleKey(LOCKOUT_KIND_USERNAME, "ida")
+	earlier := time.Now().Add(-LOGIN_MAX_BACKOFF - time.Minute).Unix()
+	for i := 1; i < loginThrottlePolicy.MaxFailures; i++ {
+		throttle, _ := throttles.GetLoginThrottle(ctx, key)
+		_, customErr := throttles.AddLoginFailure(ctx, throttle, earlier, 0)
+		if customErr != nil {
+			t.Fatal(customErr.Description())
+		}
+	}
+
+	failLogin(t, "ida", "")
+
+	customErr := BeginLoginAttempt(ctx, "ida", "")
+	if wait := RetryAfter(customErr); wait < loginThrottlePolicy.LockoutDuration-time.Minute {
+		t.Fatalf("retry after %s once locked out", wait)
+	}
+
+	customErr = ClearLockout(ctx, LOCKOUT_KIND_USERNAME, "ida")
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+	failLogin(t, "ida", "")
+}
+
+func TestLoginThrottleConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	useMemoryStore(t)
+
+	// Attempts are counted before their password is checked, so guesses sent together can not
+	// all pass the same check.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if BeginLoginAttempt(ctx, "ida", "") == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != LOGIN_FREE_ATTEMPTS+1 {
+		t.Fatalf("%d concurrent attempts were allowed, expected %d", allowed, LOGIN_FREE_ATTEMPTS+1)
+	}
+}
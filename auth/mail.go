@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"github.com/AnthonyNixon/setsisaw/mail"
+	"log"
+	"net/url"
+	"os"
+	"time"
+)
+
+// tokenInstructions links to the URL in the urlVariable environment variable with token added
+// as a query parameter, or gives the bare token when it is not set.
+func tokenInstructions(urlVariable string, token string, withLink string, withoutLink string) string {
+	tokenUrl := os.Getenv(urlVariable)
+	if tokenUrl == "" {
+		return withoutLink + ":\n\n" + token
+	}
+
+	parsed, err := url.Parse(tokenUrl)
+	if err != nil {
+		log.Printf("%s is not a valid URL: %s", urlVariable, err.Error())
+		return withoutLink + ":\n\n" + token
+	}
+
+	query := parsed.Query()
+	query.Set("token", token)
+	parsed.RawQuery = query.Encode()
+	return withLink + ":\n\n" + parsed.String()
+}
+
+// sendMail sends message in the background, logging failures.
+func sendMail(message mail.Message, userId int) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		err := mailer.Send(ctx, message)
+		if err != nil {
+			log.Printf("could not send %q email to user %d: %s", message.Subject, userId, err.Error())
+		}
+	}()
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// CLAIMS_KEY is where RequireAuth stores the caller's claims in the gin context.
+const CLAIMS_KEY = "claims"
+
+// RequireAuth returns a middleware that parses the bearer token once, rejects callers without
+// a valid one and stores their claims for the handlers behind it.
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, customErr := GetUserInfo(c)
+		if customErr != nil {
+			c.AbortWithStatusJSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+			return
+		}
+
+		c.Set(CLAIMS_KEY, claims)
+		c.Next()
+	}
+}
+
+// RequirePermission returns a middleware that rejects callers whose token was not granted
+// permission. It must run after RequireAuth.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetClaims(c)
+		if !HasPermission(claims, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("User %s does not have the %s permission.", claims.Username, permission)})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// GetClaims returns the claims stored by RequireAuth. Only call it from handlers registered
+// behind RequireAuth.
+func GetClaims(c *gin.Context) types.Claims {
+	return c.MustGet(CLAIMS_KEY).(types.Claims)
+}
+
+// RequireVerifiedEmail returns a middleware that rejects callers who have not verified their
+// email, when RequireVerifiedEmailToCreate is set. It must run after RequireAuth.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !RequireVerifiedEmailToCreate {
+			c.Next()
+			return
+		}
+
+		claims := GetClaims(c)
+		user, customErr := users.GetUser(c.Request.Context(), claims.Id)
+		if customErr != nil {
+			c.AbortWithStatusJSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+			return
+		}
+
+		if !user.EmailVerified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("User %s must verify their email first.", claims.Username)})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireInteractiveSession returns a middleware that rejects callers using a personal access
+// token, for routes that manage the account itself: a leaked token must not be able to mint
+// more tokens, change credentials or take over sessions. It must run after RequireAuth.
+func RequireInteractiveSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := GetClaims(c)
+		if claims.ApiTokenId != 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("User %s must sign in to do this, personal access tokens are not accepted.", claims.Username)})
+			return
+		}
+
+		c.Next()
+	}
+}
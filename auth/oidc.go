@@ -0,0 +1,641 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC_LOGIN_COOKIE holds the state, nonce and PKCE verifier of a login in progress, signed
+// like our other tokens so the callback can trust them without storing anything.
+const OIDC_LOGIN_COOKIE = "setsisaw_oidc_login"
+const OIDC_LOGIN_AUDIENCE = "setsisaw:oidc-login"
+const OIDC_LOGIN_VALID_TIME = 10 * time.Minute
+
+// OIDC_JWKS_REFRESH_INTERVAL limits how often the provider's keys are fetched again when an ID
+// token names a key we have not seen.
+const OIDC_JWKS_REFRESH_INTERVAL = 10 * time.Second
+const OIDC_CLOCK_SKEW = 1 * time.Minute
+
+// OIDC_PROVIDER_TIMEOUT bounds every call to the provider, apart from the database timeout.
+const OIDC_PROVIDER_TIMEOUT = 10 * time.Second
+
+var oidcUsernameInvalid = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// oidcProvider is the OpenID Connect provider users can log in with. Its endpoints and keys are
+// discovered from the issuer the first time they are needed.
+type oidcProvider struct {
+	issuer         string
+	clientId       string
+	clientSecret   string
+	redirectUrl    string
+	scopes         string
+	provisionUsers bool
+	client         *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// oidcDiscovery is the part of the provider's /.well-known/openid-configuration we use.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type oidcLoginClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+// oidcIdTokenClaims are the ID token claims we check or use. jwt.StandardClaims can not be used
+// because aud may be a list.
+type oidcIdTokenClaims struct {
+	Issuer            string       `json:"iss"`
+	Subject           string       `json:"sub"`
+	Audience          oidcAudience `json:"aud"`
+	AuthorizedParty   string       `json:"azp"`
+	ExpiresAt         int64        `json:"exp"`
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	EmailVerified     interface{}  `json:"email_verified"`
+	PreferredUsername string       `json:"preferred_username"`
+	GivenName         string       `json:"given_name"`
+	FamilyName        string       `json:"family_name"`
+}
+
+type oidcAudience []string
+
+var oidc *oidcProvider
+
+// loadOidcProvider enables OpenID Connect login when SETSISAW_OIDC_ISSUER is set.
+func loadOidcProvider() {
+	issuer := strings.TrimSuffix(os.Getenv("SETSISAW_OIDC_ISSUER"), "/")
+	if issuer == "" {
+		oidc = nil
+		return
+	}
+
+	oidc = &oidcProvider{
+		issuer:         issuer,
+		clientId:       os.Getenv("SETSISAW_OIDC_CLIENT_ID"),
+		clientSecret:   os.Getenv("SETSISAW_OIDC_CLIENT_SECRET"),
+		redirectUrl:    os.Getenv("SETSISAW_OIDC_REDIRECT_URL"),
+		scopes:         os.Getenv("SETSISAW_OIDC_SCOPES"),
+		provisionUsers: os.Getenv("SETSISAW_OIDC_PROVISION_USERS") != "false",
+		client:         &http.Client{Timeout: OIDC_PROVIDER_TIMEOUT},
+	}
+
+	if oidc.clientId == "" || oidc.redirectUrl == "" {
+		log.Fatal("SETSISAW_OIDC_CLIENT_ID and SETSISAW_OIDC_REDIRECT_URL are required with SETSISAW_OIDC_ISSUER")
+	}
+
+	if oidc.scopes == "" {
+		oidc.scopes = "openid email profile"
+	}
+
+	log.Printf("OpenID Connect login with %s enabled", issuer)
+}
+
+// StartOidcLogin returns the provider URL to send the user to and the cookie that has to come
+// back with them to the callback.
+func StartOidcLogin(ctx context.Context) (string, *http.Cookie, types.Error) {
+	if oidc == nil {
+		return "", nil, customerrors.New(http.StatusNotFound, "OpenID Connect login is not configured")
+	}
+
+	providerCtx, cancel := context.WithTimeout(ctx, OIDC_PROVIDER_TIMEOUT)
+	defer cancel()
+
+	discovery, customErr := oidc.discover(providerCtx)
+	if customErr != nil {
+		return "", nil, customErr
+	}
+
+	claims := oidcLoginClaims{State: randomToken(16), Nonce: randomToken(16), Verifier: randomToken(32)}
+	claims.Audience = OIDC_LOGIN_AUDIENCE
+	claims.ExpiresAt = time.Now().Add(OIDC_LOGIN_VALID_TIME).Unix()
+	if claims.State == "" || claims.Nonce == "" || claims.Verifier == "" {
+		return "", nil, customerrors.New(http.StatusInternalServerError, "could not generate login state")
+	}
+
+	value, err := signToken(claims)
+	if err != nil {
+		return "", nil, customerrors.New(http.StatusInternalServerError, err.Error())
+	}
+
+	challenge := sha256.Sum256([]byte(claims.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidc.clientId},
+		"redirect_uri":          {oidc.redirectUrl},
+		"scope":                 {oidc.scopes},
+		"state":                 {claims.State},
+		"nonce":                 {claims.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), oidcLoginCookie(value, int(OIDC_LOGIN_VALID_TIME.Seconds())), nil
+}
+
+// ClearOidcLoginCookie returns a cookie that removes the one from StartOidcLogin.
+func ClearOidcLoginCookie() *http.Cookie {
+	return oidcLoginCookie("", -1)
+}
+
+// CompleteOidcLogin exchanges the code the provider sent back for an ID token and returns the
+// user it belongs to. Users are found by the identity linked to them, then by verified email,
+// and are created if neither matches and SETSISAW_OIDC_PROVISION_USERS is not false. ctx should
+// be the request's, calls to the provider and the database are given their own timeouts.
+func CompleteOidcLogin(ctx context.Context, loginCookie string, state string, code string) (types.User, types.Error) {
+	if oidc == nil {
+		return types.User{}, customerrors.New(http.StatusNotFound, "OpenID Connect login is not configured")
+	}
+
+	login := &oidcLoginClaims{}
+	tkn, err := jwt.ParseWithClaims(loginCookie, login, verificationKey)
+	if err != nil || !tkn.Valid || login.Audience != OIDC_LOGIN_AUDIENCE || login.State == "" || login.State != state {
+		return types.User{}, customerrors.New(http.StatusUnauthorized, "login state is missing, invalid or expired, start again")
+	}
+
+	claims, customErr := oidc.verifyCode(ctx, code, login)
+	if customErr != nil {
+		return types.User{}, customErr
+	}
+
+	dbCtx, cancel := database.NewContext(ctx)
+	defer cancel()
+
+	return oidcUser(dbCtx, claims)
+}
+
+// verifyCode redeems code and verifies the ID token it is exchanged for.
+func (p *oidcProvider) verifyCode(ctx context.Context, code string, login *oidcLoginClaims) (oidcIdTokenClaims, types.Error) {
+	ctx, cancel := context.WithTimeout(ctx, OIDC_PROVIDER_TIMEOUT)
+	defer cancel()
+
+	idToken, customErr := p.exchangeCode(ctx, code, login.Verifier)
+	if customErr != nil {
+		return oidcIdTokenClaims{}, customErr
+	}
+
+	return p.verifyIdToken(ctx, idToken, login.Nonce)
+}
+
+// oidcUser finds or creates the user for a verified ID token.
+func oidcUser(ctx context.Context, claims oidcIdTokenClaims) (types.User, types.Error) {
+	now := time.Now().Unix()
+
+	identity, customErr := identities.GetUserIdentity(ctx, claims.Issuer, claims.Subject)
+	if customErr == nil {
+		customErr = identities.TouchUserIdentity(ctx, identity.Id, claims.Email, now)
+		if customErr != nil {
+			log.Printf("could not record login for identity %d: %s", identity.Id, customErr.Description())
+		}
+		return users.GetUser(ctx, strconv.Itoa(identity.UserId))
+	}
+	if customErr.StatusCode() != http.StatusNotFound {
+		return types.User{}, customErr
+	}
+
+	if claims.Email == "" || !claims.emailVerified() {
+		return types.User{}, customerrors.New(http.StatusForbidden, "the identity provider did not return a verified email")
+	}
+
+	identity = types.UserIdentity{Issuer: claims.Issuer, Subject: claims.Subject, Email: claims.Email, CreatedAt: now, LastLoginAt: now}
+
+	user, customErr := users.GetUserByEmail(ctx, claims.Email)
+	if customErr == nil {
+		// Otherwise whoever signed up with someone else's address would get their login.
+		if !user.EmailVerified {
+			return types.User{}, customerrors.New(http.StatusConflict, "an account with this email exists but has not verified it, verify it before logging in with your identity provider")
+		}
+
+		identity.UserId, _ = strconv.Atoi(user.Id)
+		_, customErr = identities.LinkUserIdentity(ctx, identity)
+		if customErr != nil {
+			return types.User{}, customErr
+		}
+
+		log.Printf("Linked %s identity %s to user %s", claims.Issuer, claims.Subject, user.Id)
+		return user, nil
+	}
+	if customErr.StatusCode() != http.StatusNotFound {
+		return types.User{}, customErr
+	}
+
+	if !oidc.provisionUsers {
+		return types.User{}, customerrors.New(http.StatusForbidden, "no account has this email, ask an admin to create one")
+	}
+
+	return provisionOidcUser(ctx, claims, identity)
+}
+
+// provisionOidcUser creates a USER for claims. Their password is random, they can set one with
+// the password reset flow if they ever need to sign in without the provider.
+func provisionOidcUser(ctx context.Context, claims oidcIdTokenClaims, identity types.UserIdentity) (types.User, types.Error) {
+	username, customErr := oidcUsername(ctx, claims)
+	if customErr != nil {
+		return types.User{}, customErr
+	}
+
+	password := randomToken(32)
+	if password == "" {
+		return types.User{}, customerrors.New(http.StatusInternalServerError, "could not generate password")
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return types.User{}, customerrors.New(http.StatusInternalServerError, err.Error())
+	}
+
+	user := types.User{Username: username, Email: claims.Email, Password: hash, FirstName: claims.GivenName, LastName: claims.FamilyName}
+	id, customErr := identities.CreateUserWithIdentity(ctx, user, identity)
+	if customErr != nil {
+		return types.User{}, customErr
+	}
+
+	log.Printf("Created user %d for %s identity %s", id, claims.Issuer, claims.Subject)
+	return users.GetUser(ctx, strconv.Itoa(id))
+}
+
+// oidcUsername picks an unused username from the preferred username or the email's local part.
+func oidcUsername(ctx context.Context, claims oidcIdTokenClaims) (string, types.Error) {
+	base := claims.PreferredUsername
+	if base == "" || strings.Contains(base, "@") {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	base = truncate(strings.Trim(oidcUsernameInvalid.ReplaceAllString(base, "-"), "-"), 40)
+	if base == "" {
+		base = "user"
+	}
+
+	for i := 1; i <= 100; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%s-%d", base, i)
+		}
+
+		_, customErr := users.GetUserByUsername(ctx, username)
+		if customErr != nil && customErr.StatusCode() == http.StatusNotFound {
+			return username, nil
+		}
+		if customErr != nil {
+			return "", customErr
+		}
+	}
+
+	return "", customerrors.New(http.StatusConflict, "could not find an unused username for "+base)
+}
+
+func oidcLoginCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     OIDC_LOGIN_COOKIE,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   oidc != nil && strings.HasPrefix(oidc.redirectUrl, "https://"),
+		// Lax, so the cookie is sent when the provider redirects back to the callback.
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// discover fetches the provider's discovery document once. It is fetched without holding p.mu,
+// so a slow provider only holds up the logins waiting on it.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, types.Error) {
+	p.mu.Lock()
+	discovery := p.discovery
+	p.mu.Unlock()
+
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	discovery = &oidcDiscovery{}
+	err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", discovery)
+	if err != nil {
+		return nil, customerrors.New(http.StatusBadGateway, "could not discover the identity provider, "+err.Error())
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer || discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksUri == "" {
+		return nil, customerrors.New(http.StatusBadGateway, "the identity provider's discovery document is incomplete or for another issuer")
+	}
+
+	p.mu.Lock()
+	p.discovery = discovery
+	p.mu.Unlock()
+
+	return discovery, nil
+}
+
+// exchangeCode redeems an authorization code at the token endpoint and returns the ID token.
+func (p *oidcProvider) exchangeCode(ctx context.Context, code string, verifier string) (string, types.Error) {
+	discovery, customErr := p.discover(ctx)
+	if customErr != nil {
+		return "", customErr
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectUrl},
+		"client_id":     {p.clientId},
+		"code_verifier": {verifier},
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", customerrors.New(http.StatusInternalServerError, err.Error())
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.clientId), url.QueryEscape(p.clientSecret))
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return "", customerrors.New(http.StatusBadGateway, "could not reach the identity provider, "+err.Error())
+	}
+	defer response.Body.Close()
+
+	var tokens struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&tokens)
+	if err != nil {
+		return "", customerrors.New(http.StatusBadGateway, "could not read the identity provider's token response, "+err.Error())
+	}
+
+	if response.StatusCode != http.StatusOK || tokens.Error != "" {
+		return "", customerrors.New(http.StatusUnauthorized, strings.TrimSuffix("the identity provider refused the code: "+tokens.Error+" "+tokens.ErrorDescription, " "))
+	}
+
+	if tokens.IdToken == "" {
+		return "", customerrors.New(http.StatusBadGateway, "the identity provider did not return an ID token")
+	}
+
+	return tokens.IdToken, nil
+}
+
+// verifyIdToken checks the ID token's signature against the provider's keys, and that it was
+// issued by the provider, for us, for this login.
+func (p *oidcProvider) verifyIdToken(ctx context.Context, idToken string, nonce string) (oidcIdTokenClaims, types.Error) {
+	claims := oidcIdTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		return p.idTokenKey(ctx, token)
+	})
+	if err != nil {
+		return claims, customerrors.New(http.StatusUnauthorized, "invalid ID token, "+err.Error())
+	}
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.issuer:
+		err = errors.New("issued by " + claims.Issuer)
+	case !claims.Audience.contains(p.clientId):
+		err = errors.New("not issued for this client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.clientId:
+		err = errors.New("authorized party is not this client")
+	case claims.Nonce != nonce:
+		err = errors.New("nonce does not match")
+	case claims.Subject == "":
+		err = errors.New("no subject")
+	}
+
+	if err != nil {
+		return claims, customerrors.New(http.StatusUnauthorized, "invalid ID token, "+err.Error())
+	}
+
+	return claims, nil
+}
+
+// idTokenKey finds the key for an ID token, it backs the jwt.Keyfunc in verifyIdToken. Only
+// asymmetric algorithms are accepted, the client secret is never used as a key.
+func (p *oidcProvider) idTokenKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg(), SigningMethodEdDSA.Alg():
+	default:
+		return nil, fmt.Errorf("unsupported signing method %s", token.Method.Alg())
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	key := p.findKey(kid)
+	refresh := key == nil && time.Since(p.keysFetchedAt) > OIDC_JWKS_REFRESH_INTERVAL
+	if refresh {
+		p.keysFetchedAt = time.Now()
+	}
+	jwksUri := p.discovery.JwksUri
+	p.mu.Unlock()
+
+	if refresh {
+		keys, err := p.fetchKeys(ctx, jwksUri)
+		if err != nil {
+			return nil, err
+		}
+
+		p.mu.Lock()
+		p.keys = keys
+		key = p.findKey(kid)
+		p.mu.Unlock()
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// findKey requires the caller to hold p.mu. A token without a kid can only use a lone key.
+func (p *oidcProvider) findKey(kid string) interface{} {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+
+	return p.keys[kid]
+}
+
+// fetchKeys returns the signing keys published at jwksUri by kid.
+func (p *oidcProvider) fetchKeys(ctx context.Context, jwksUri string) (map[string]interface{}, error) {
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+	err := p.getJSON(ctx, jwksUri, &jwks)
+	if err != nil {
+		return nil, errors.New("could not fetch the identity provider's keys, " + err.Error())
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("skipping key %q from %s: %s", jwk.Kid, jwksUri, err.Error())
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, address string, into interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", address, response.Status)
+	}
+
+	raw, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, into)
+}
+
+// publicKey decodes an RSA, P-256 or Ed25519 public key.
+func (jwk JWK) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+// Valid is called by jwt-go after the signature checks out.
+func (c oidcIdTokenClaims) Valid() error {
+	if c.ExpiresAt == 0 || time.Now().Add(-OIDC_CLOCK_SKEW).Unix() > c.ExpiresAt {
+		return errors.New("token is expired")
+	}
+
+	return nil
+}
+
+// emailVerified accepts true, or "true" as some providers send it.
+func (c oidcIdTokenClaims) emailVerified() bool {
+	switch verified := c.EmailVerified.(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	}
+
+	return false
+}
+
+// UnmarshalJSON accepts a single audience or a list of them.
+func (a *oidcAudience) UnmarshalJSON(raw []byte) error {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		*a = oidcAudience{single}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(raw, &list)
+	*a = list
+	return err
+}
+
+func (a oidcAudience) contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testOidcClientId = "setsisaw"
+const testOidcClientSecret = "client-secret"
+
+// mockOidcProvider serves discovery, JWKS and a token endpoint that checks PKCE and issues ID
+// tokens for the codes handed out by authorize.
+type mockOidcProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockOidcCode
+
+	// claims changes an ID token's claims before it is signed.
+	claims func(claims jwt.MapClaims)
+	// hs256 signs ID tokens with the client secret instead of the published key.
+	hs256 bool
+}
+
+type mockOidcCode struct {
+	nonce     string
+	challenge string
+}
+
+func newMockOidcProvider(t *testing.T) *mockOidcProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &mockOidcProvider{key: key, codes: map[string]mockOidcCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := provider.server.URL
+		json.NewEncoder(w).Encode(oidcDiscovery{Issuer: issuer, AuthorizationEndpoint: issuer + "/authorize", TokenEndpoint: issuer + "/token", JwksUri: issuer + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := base64.RawURLEncoding.EncodeToString
+		jwk := JWK{Kty: "RSA", Kid: "test", Use: "sig", Alg: "RS256", N: encode(key.N.Bytes()), E: encode(big.NewInt(int64(key.E)).Bytes())}
+		json.NewEncoder(w).Encode(map[string][]JWK{"keys": {jwk}})
+	})
+	mux.HandleFunc("/token", provider.token)
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+
+	return provider
+}
+
+// authorize stands in for the user logging in at the provider and returns the code it would
+// redirect back with.
+func (p *mockOidcProvider) authorize(t *testing.T, location string) string {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := parsed.Query()
+	if query.Get("client_id") != testOidcClientId || query.Get("code_challenge_method") != "S256" || query.Get("state") == "" {
+		t.Fatalf("unexpected authorization request %s", location)
+	}
+
+	code := randomToken(16)
+	p.mu.Lock()
+	p.codes[code] = mockOidcCode{nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+	p.mu.Unlock()
+
+	return code
+}
+
+func (p *mockOidcProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	clientId, secret, _ := r.BasicAuth()
+
+	p.mu.Lock()
+	code, ok := p.codes[r.Form.Get("code")]
+	delete(p.codes, r.Form.Get("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || clientId != testOidcClientId || secret != testOidcClientSecret || base64.RawURLEncoding.EncodeToString(verifier[:]) != code.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":                p.server.URL,
+		"sub":                "subject-1",
+		"aud":                testOidcClientId,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              code.nonce,
+		"email":              "ida@example.com",
+		"email_verified":     true,
+		"preferred_username": "ida",
+	}
+	if p.claims != nil {
+		p.claims(claims)
+	}
+
+	var idToken string
+	var err error
+	if p.hs256 {
+		idToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testOidcClientSecret))
+	} else {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err = token.SignedString(p.key)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+}
+
+// useMockOidcProvider points OpenID Connect login at provider, with a fresh memory store.
+func useMockOidcProvider(t *testing.T, provider *mockOidcProvider, provisionUsers bool) *database.MemoryStore {
+	store := useMemoryStore(t)
+
+	oidc = &oidcProvider{
+		issuer:         provider.server.URL,
+		clientId:       testOidcClientId,
+		clientSecret:   testOidcClientSecret,
+		redirectUrl:    "http://localhost/auth/oidc/callback",
+		scopes:         "openid email profile",
+		provisionUsers: provisionUsers,
+		client:         provider.server.Client(),
+	}
+	t.Cleanup(func() { oidc = nil })
+
+	return store
+}
+
+// oidcLogin runs a login through provider, with state as the callback's state parameter when
+// it is not empty.
+func oidcLogin(t *testing.T, provider *mockOidcProvider, state string) (types.User, types.Error) {
+	location, cookie, customErr := StartOidcLogin(context.Background())
+	if customErr != nil {
+		t.Fatalf("could not start login: %s", customErr.Description())
+	}
+
+	code := provider.authorize(t, location)
+	if state == "" {
+		parsed, _ := url.Parse(location)
+		state = parsed.Query().Get("state")
+	}
+
+	return CompleteOidcLogin(context.Background(), cookie.Value, state, code)
+}
+
+func TestOidcLogin(t *testing.T) {
+	tests := []struct {
+		name           string
+		existing       *types.User
+		claims         func(claims jwt.MapClaims)
+		hs256          bool
+		state          string
+		provisionUsers bool
+		status         int
+		message        string
+		username       string
+	}{
+		{name: "provisions a new user", provisionUsers: true, username: "ida"},
+		{name: "provisions with a free username", existing: &types.User{Username: "ida", Email: "other@example.com", EmailVerified: true}, provisionUsers: true, username: "ida-2"},
+		{name: "links a user by verified email", existing: &types.User{Username: "ida-h", Email: "ida@example.com", EmailVerified: true}, username: "ida-h"},
+		{name: "accepts email_verified as a string", claims: func(c jwt.MapClaims) { c["email_verified"] = "true" }, provisionUsers: true, username: "ida"},
+		{name: "accepts several audiences with azp", claims: func(c jwt.MapClaims) { c["aud"] = []string{testOidcClientId, "api"}; c["azp"] = testOidcClientId }, provisionUsers: true, username: "ida"},
+		{name: "refuses to link an unverified account", existing: &types.User{Username: "ida-h", Email: "ida@example.com"}, provisionUsers: true, status: http.StatusConflict},
+		{name: "refuses an unverified email", claims: func(c jwt.MapClaims) { c["email_verified"] = false }, provisionUsers: true, status: http.StatusForbidden},
+		{name: "refuses unknown users without provisioning", status: http.StatusForbidden},
+		{name: "refuses a state mismatch", state: "someone-elses-state", provisionUsers: true, status: http.StatusUnauthorized},
+		{name: "refuses a nonce mismatch", claims: func(c jwt.MapClaims) { c["nonce"] = "replayed" }, provisionUsers: true, status: http.StatusUnauthorized},
+		{name: "refuses another audience", claims: func(c jwt.MapClaims) { c["aud"] = "another-client" }, provisionUsers: true, status: http.StatusUnauthorized},
+		{name: "refuses another authorized party", claims: func(c jwt.MapClaims) { c["aud"] = []string{testOidcClientId, "api"}; c["azp"] = "api" }, provisionUsers: true, status: http.StatusUnauthorized},
+		{name: "refuses several audiences without azp", claims: func(c jwt.MapClaims) { c["aud"] = []string{testOidcClientId, "api"} }, provisionUsers: true, status: http.StatusUnauthorized},
+		{name: "refuses another issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, provisionUsers: true, status: http.StatusUnauthorized},
+		{name: "refuses an expired token", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, provisionUsers: true, status: http.StatusUnauthorized},
+		{name: "refuses HS256 signed with the client secret", hs256: true, provisionUsers: true, status: http.StatusUnauthorized, message: "unsupported signing method HS256"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := newMockOidcProvider(t)
+			provider.claims = test.claims
+			provider.hs256 = test.hs256
+			store := useMockOidcProvider(t, provider, test.provisionUsers)
+
+			if test.existing != nil {
+				existing := *test.existing
+				id, customErr := store.CreateUser(context.Background(), existing)
+				if customErr != nil {
+					t.Fatal(customErr.Description())
+				}
+
+				// New users start unverified, whether their email is verified is stored separately.
+				existing.Id = strconv.Itoa(id)
+				customErr = store.UpdateUser(context.Background(), existing)
+				if customErr != nil {
+					t.Fatal(customErr.Description())
+				}
+			}
+
+			user, customErr := oidcLogin(t, provider, test.state)
+			if test.status != 0 {
+				if customErr == nil {
+					t.Fatalf("logged in as %s, expected a %d", user.Username, test.status)
+				}
+				if customErr.StatusCode() != test.status {
+					t.Fatalf("got %d %q, expected a %d", customErr.StatusCode(), customErr.Description(), test.status)
+				}
+				if !strings.Contains(customErr.Description(), test.message) {
+					t.Fatalf("got %q, expected it to mention %q", customErr.Description(), test.message)
+				}
+				return
+			}
+
+			if customErr != nil {
+				t.Fatalf("got %d %q", customErr.StatusCode(), customErr.Description())
+			}
+			if user.Username != test.username {
+				t.Fatalf("logged in as %q, expected %q", user.Username, test.username)
+			}
+		})
+	}
+}
+
+func TestOidcLoginUsesLinkedIdentity(t *testing.T) {
+	provider := newMockOidcProvider(t)
+	useMockOidcProvider(t, provider, true)
+
+	first, customErr := oidcLogin(t, provider, "")
+	if customErr != nil {
+		t.Fatalf("got %d %q", customErr.StatusCode(), customErr.Description())
+	}
+
+	// The identity is linked now, so a changed or unverified email no longer matters.
+	provider.claims = func(c jwt.MapClaims) {
+		c["email"] = "ida@new.example.com"
+		c["email_verified"] = false
+	}
+	second, customErr := oidcLogin(t, provider, "")
+	if customErr != nil {
+		t.Fatalf("got %d %q", customErr.StatusCode(), customErr.Description())
+	}
+
+	if second.Id != first.Id {
+		t.Fatalf("second login was user %s, expected %s", second.Id, first.Id)
+	}
+}
+
+func TestOidcLoginRefusesReusedCode(t *testing.T) {
+	provider := newMockOidcProvider(t)
+	useMockOidcProvider(t, provider, true)
+
+	location, cookie, customErr := StartOidcLogin(context.Background())
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+	code := provider.authorize(t, location)
+	parsed, _ := url.Parse(location)
+	state := parsed.Query().Get("state")
+
+	_, customErr = CompleteOidcLogin(context.Background(), cookie.Value, state, code)
+	if customErr != nil {
+		t.Fatalf("got %d %q", customErr.StatusCode(), customErr.Description())
+	}
+
+	_, customErr = CompleteOidcLogin(context.Background(), cookie.Value, state, code)
+	if customErr == nil || customErr.StatusCode() != http.StatusUnauthorized || !strings.Contains(customErr.Description(), "invalid_grant") {
+		t.Fatalf("reused code was not refused, got %v", customErr)
+	}
+}
+
+func TestOidcLoginTimesOutSlowProvider(t *testing.T) {
+	provider := newMockOidcProvider(t)
+	useMockOidcProvider(t, provider, true)
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	oidc.issuer = slow.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, _, customErr := StartOidcLogin(ctx)
+	if customErr == nil || customErr.StatusCode() != http.StatusBadGateway {
+		t.Fatalf("expected a 502 once the request was done, got %v", customErr)
+	}
+}
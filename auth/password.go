@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/env"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const DEFAULT_PASSWORD_MIN_LENGTH = 8
+
+// MAX_PASSWORD_LENGTH is in bytes, bcrypt ignores anything past it.
+const MAX_PASSWORD_LENGTH = 72
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PasswordPolicy is what new passwords are checked against. It is read from the environment
+// once, by Initialize.
+type PasswordPolicy struct {
+	MinLength  int
+	BcryptCost int
+	denyList   map[string]bool
+}
+
+var passwordPolicy = PasswordPolicy{MinLength: DEFAULT_PASSWORD_MIN_LENGTH, BcryptCost: bcrypt.DefaultCost}
+
+// loadPasswordPolicy reads SETSISAW_PASSWORD_MIN_LENGTH and SETSISAW_BCRYPT_COST, and builds the
+// deny-list from the bundled common passwords unless SETSISAW_PASSWORD_DENY_COMMON is "false",
+// plus any listed in SETSISAW_PASSWORD_DENY_LIST_FILE.
+func loadPasswordPolicy() {
+	passwordPolicy.MinLength = env.Int("SETSISAW_PASSWORD_MIN_LENGTH", DEFAULT_PASSWORD_MIN_LENGTH, 1, MAX_PASSWORD_LENGTH)
+	passwordPolicy.BcryptCost = env.Int("SETSISAW_BCRYPT_COST", bcrypt.DefaultCost, bcrypt.MinCost, bcrypt.MaxCost)
+	passwordPolicy.denyList = map[string]bool{}
+
+	if os.Getenv("SETSISAW_PASSWORD_DENY_COMMON") != "false" {
+		addToDenyList(strings.NewReader(commonPasswords))
+	}
+
+	path := os.Getenv("SETSISAW_PASSWORD_DENY_LIST_FILE")
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("could not read password deny-list %s: %s", path, err.Error())
+		}
+		defer file.Close()
+
+		addToDenyList(file)
+	}
+
+	log.Printf("Passwords need at least %d characters, %d are denied, bcrypt cost %d", passwordPolicy.MinLength, len(passwordPolicy.denyList), passwordPolicy.BcryptCost)
+}
+
+func addToDenyList(list io.Reader) {
+	scanner := bufio.NewScanner(list)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			passwordPolicy.denyList[strings.ToLower(line)] = true
+		}
+	}
+}
+
+// ValidatePassword checks a new password for user against the password policy.
+func ValidatePassword(password string, user types.User) types.Error {
+	if len([]rune(password)) < passwordPolicy.MinLength {
+		return customerrors.New(http.StatusBadRequest, fmt.Sprintf("password must be at least %d characters", passwordPolicy.MinLength))
+	}
+
+	if len(password) > MAX_PASSWORD_LENGTH {
+		return customerrors.New(http.StatusBadRequest, fmt.Sprintf("password must be at most %d bytes", MAX_PASSWORD_LENGTH))
+	}
+
+	lower := strings.ToLower(password)
+	if passwordPolicy.denyList[lower] {
+		return customerrors.New(http.StatusBadRequest, "password is too common, choose another")
+	}
+
+	if lower == strings.ToLower(user.Username) || lower == strings.ToLower(user.Email) {
+		return customerrors.New(http.StatusBadRequest, "password must not be your username or email")
+	}
+
+	return nil
+}
+
+// HashPassword salts and hashes a password with bcrypt for storage.
+func HashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), passwordPolicy.BcryptCost)
+	return string(hashedPassword), err
+}
+
+// rehashPassword stores a new hash of password when user's was made with a lower cost than is
+// configured now. It is only called with a password that was just checked, failures are
+// logged rather than failing the sign in.
+func rehashPassword(ctx context.Context, user types.User, password string) {
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	if err != nil || cost >= passwordPolicy.BcryptCost {
+		return
+	}
+
+	userId, err := strconv.Atoi(user.Id)
+	if err != nil {
+		return
+	}
+
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		log.Printf("could not rehash password of user %d: %s", userId, err.Error())
+		return
+	}
+
+	customErr := users.UpdatePassword(ctx, userId, passwordHash)
+	if customErr != nil {
+		log.Printf("could not store rehashed password of user %d: %s", userId, customErr.Description())
+		return
+	}
+
+	log.Printf("Rehashed password of user %d from bcrypt cost %d to %d", userId, cost, passwordPolicy.BcryptCost)
+}
+
+// CheckCurrentPassword returns the caller in claims, including their password hash, or a 403
+// unless currentPassword is their password.
+func CheckCurrentPassword(ctx context.Context, claims types.Claims, currentPassword string) (types.User, types.Error) {
+	// Look the user up by id first, their username may have changed since the token was issued.
+	user, customErr := users.GetUser(ctx, claims.Id)
+	if customErr != nil {
+		return user, customErr
+	}
+
+	user, customErr = users.GetUserByUsername(ctx, user.Username)
+	if customErr != nil {
+		return user, customErr
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)) != nil {
+		return user, customerrors.New(http.StatusForbidden, "current password is incorrect")
+	}
+
+	return user, nil
+}
+
+// ChangePassword sets a new password for the caller in claims after checking their current
+// one, and signs out every other session.
+func ChangePassword(ctx context.Context, claims types.Claims, currentPassword string, newPassword string) types.Error {
+	user, customErr := CheckCurrentPassword(ctx, claims, currentPassword)
+	if customErr != nil {
+		return customErr
+	}
+
+	customErr = ValidatePassword(newPassword, user)
+	if customErr != nil {
+		return customErr
+	}
+
+	passwordHash, err := HashPassword(newPassword)
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, err.Error())
+	}
+
+	userId, err := strconv.Atoi(user.Id)
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, "could not convert user id to an int")
+	}
+
+	return users.ChangePassword(ctx, userId, passwordHash, claims.SessionId)
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/mail"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const PASSWORD_RESET_VALID_TIME = 1 * time.Hour
+
+// RequestPasswordReset mails a one time reset token to the user with the given email. Nothing
+// is sent, and no error returned, when no user has that email.
+func RequestPasswordReset(ctx context.Context, email string) types.Error {
+	user, customErr := users.GetUserByEmail(ctx, email)
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusNotFound {
+			return nil
+		}
+		return customErr
+	}
+
+	userId, err := strconv.Atoi(user.Id)
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, "could not convert user id to an int")
+	}
+
+	token := randomToken(32)
+	if token == "" {
+		return customerrors.New(http.StatusInternalServerError, "could not generate reset token")
+	}
+
+	now := time.Now()
+	_, customErr = resets.CreatePasswordReset(ctx, types.PasswordReset{
+		UserId:    userId,
+		TokenHash: HashToken(token),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(PASSWORD_RESET_VALID_TIME).Unix(),
+	})
+	if customErr != nil {
+		return customErr
+	}
+
+	message := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your SetsISaw password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your SetsISaw account. If it was you, %s\n\n"+
+			"It can only be used once and expires in %d minutes. If you did not ask for this you can ignore this email.",
+			user.Username, tokenInstructions("SETSISAW_PASSWORD_RESET_URL", token, "follow this link to choose a new one", "reset it with this token"), int(PASSWORD_RESET_VALID_TIME.Minutes())),
+	}
+
+	// Sent in the background so the response takes as long whether or not the account exists.
+	sendMail(message, userId)
+	return nil
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset and signs the user
+// out everywhere.
+func ResetPassword(ctx context.Context, token string, password string) types.Error {
+	invalid := customerrors.New(http.StatusBadRequest, "invalid or expired reset token")
+
+	reset, customErr := resets.GetPasswordResetByHash(ctx, HashToken(token))
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusNotFound {
+			return invalid
+		}
+		return customErr
+	}
+
+	if reset.UsedAt != 0 || time.Now().Unix() > reset.ExpiresAt {
+		return invalid
+	}
+
+	user, customErr := users.GetUser(ctx, strconv.Itoa(reset.UserId))
+	if customErr != nil {
+		return customErr
+	}
+
+	customErr = ValidatePassword(password, user)
+	if customErr != nil {
+		return customErr
+	}
+
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, err.Error())
+	}
+
+	customErr = resets.ResetPassword(ctx, reset, passwordHash)
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusConflict {
+			return invalid
+		}
+		return customErr
+	}
+
+	log.Printf("Password of user %d was reset, all sessions revoked", reset.UserId)
+	return nil
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const ROLE_USER = "USER"
+const ROLE_EDITOR = "EDITOR"
+const ROLE_ADMIN = "ADMIN"
+
+// ROLE_RANKS orders the built in roles, for settings that apply to a role "and above". Roles
+// added through SETSISAW_ROLES_FILE are not ranked.
+var ROLE_RANKS = map[string]int{
+	ROLE_USER:   1,
+	ROLE_EDITOR: 2,
+	ROLE_ADMIN:  3,
+}
+
+// Permissions are granted to roles and embedded in tokens as scopes. A permission without an
+// ":any" suffix only covers the caller's own records.
+const PERMISSION_USERS_READ_ANY = "users:read:any"
+const PERMISSION_USERS_MANAGE = "users:manage"
+const PERMISSION_ARTISTS_READ = "artists:read"
+const PERMISSION_ARTISTS_CREATE = "artists:create"
+const PERMISSION_ARTISTS_UPDATE = "artists:update"
+const PERMISSION_ARTISTS_DELETE = "artists:delete"
+const PERMISSION_ARTISTS_MERGE = "artists:merge"
+const PERMISSION_LOCATIONS_READ = "locations:read"
+const PERMISSION_LOCATIONS_CREATE = "locations:create"
+const PERMISSION_LOCATIONS_UPDATE = "locations:update"
+const PERMISSION_LOCATIONS_DELETE = "locations:delete"
+const PERMISSION_LOCATIONS_MERGE = "locations:merge"
+const PERMISSION_SETS_READ = "sets:read"
+const PERMISSION_SETS_WRITE = "sets:write"
+const PERMISSION_SETS_READ_ANY = "sets:read:any"
+const PERMISSION_SETS_WRITE_ANY = "sets:write:any"
+
+var PERMISSIONS = []string{
+	PERMISSION_USERS_READ_ANY,
+	PERMISSION_USERS_MANAGE,
+	PERMISSION_ARTISTS_READ,
+	PERMISSION_ARTISTS_CREATE,
+	PERMISSION_ARTISTS_UPDATE,
+	PERMISSION_ARTISTS_DELETE,
+	PERMISSION_ARTISTS_MERGE,
+	PERMISSION_LOCATIONS_READ,
+	PERMISSION_LOCATIONS_CREATE,
+	PERMISSION_LOCATIONS_UPDATE,
+	PERMISSION_LOCATIONS_DELETE,
+	PERMISSION_LOCATIONS_MERGE,
+	PERMISSION_SETS_READ,
+	PERMISSION_SETS_WRITE,
+	PERMISSION_SETS_READ_ANY,
+	PERMISSION_SETS_WRITE_ANY,
+}
+
+var userPermissions = []string{
+	PERMISSION_ARTISTS_READ,
+	PERMISSION_ARTISTS_CREATE,
+	PERMISSION_LOCATIONS_READ,
+	PERMISSION_SETS_READ,
+	PERMISSION_SETS_WRITE,
+}
+
+var editorPermissions = append([]string{
+	PERMISSION_USERS_READ_ANY,
+	PERMISSION_ARTISTS_UPDATE,
+	PERMISSION_ARTISTS_DELETE,
+	PERMISSION_ARTISTS_MERGE,
+	PERMISSION_LOCATIONS_CREATE,
+	PERMISSION_LOCATIONS_UPDATE,
+	PERMISSION_LOCATIONS_DELETE,
+	PERMISSION_LOCATIONS_MERGE,
+	PERMISSION_SETS_READ_ANY,
+	PERMISSION_SETS_WRITE_ANY,
+}, userPermissions...)
+
+// DEFAULT_ROLE_PERMISSIONS is used unless SETSISAW_ROLES_FILE points at a JSON file with the
+// same shape, e.g. {"USER": ["artists:read", "sets:read"], ...}.
+var DEFAULT_ROLE_PERMISSIONS = map[string][]string{
+	ROLE_USER:   userPermissions,
+	ROLE_EDITOR: editorPermissions,
+	ROLE_ADMIN:  PERMISSIONS,
+}
+
+var rolePermissions map[string][]string
+
+func loadRolePermissions() {
+	rolePermissions = DEFAULT_ROLE_PERMISSIONS
+
+	path := os.Getenv("SETSISAW_ROLES_FILE")
+	if path == "" {
+		return
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("could not read roles file %s: %s", path, err.Error())
+	}
+
+	roles := map[string][]string{}
+	err = json.Unmarshal(raw, &roles)
+	if err != nil {
+		log.Fatalf("could not parse roles file %s: %s", path, err.Error())
+	}
+
+	known := map[string]bool{}
+	for _, permission := range PERMISSIONS {
+		known[permission] = true
+	}
+
+	rolePermissions = map[string][]string{}
+	for role, permissions := range roles {
+		for _, permission := range permissions {
+			if !known[permission] {
+				log.Fatalf("roles file %s grants unknown permission %s to %s", path, permission, role)
+			}
+		}
+		rolePermissions[strings.ToUpper(role)] = permissions
+	}
+}
+
+// PermissionsForRole returns the scopes to embed in a token for a user with the given role.
+func PermissionsForRole(role string) []string {
+	permissions := append([]string{}, rolePermissions[strings.ToUpper(role)]...)
+	sort.Strings(permissions)
+	return permissions
+}
+
+// IsRole reports whether role is one of the configured roles.
+func IsRole(role string) bool {
+	_, ok := rolePermissions[strings.ToUpper(role)]
+	return ok
+}
+
+// HasPermission reports whether the token behind claims was granted permission.
+func HasPermission(claims types.Claims, permission string) bool {
+	for _, scope := range claims.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsRoleAtLeast reports whether role ranks at or above minimum. Unranked roles are never.
+func IsRoleAtLeast(role string, minimum string) bool {
+	rank := ROLE_RANKS[strings.ToUpper(role)]
+	return rank != 0 && rank >= ROLE_RANKS[strings.ToUpper(minimum)]
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const REFRESH_TOKEN_VALID_TIME = 30 * 24 * time.Hour
+
+// Device describes where a session was started from, it is shown to users managing their sessions.
+type Device struct {
+	UserAgent string
+	IpAddress string
+}
+
+// GetDevice reads the device info for a session from the request.
+func GetDevice(c *gin.Context) Device {
+	return Device{UserAgent: c.Request.UserAgent(), IpAddress: c.ClientIP()}
+}
+
+// StartSession signs the user in on a device, returning an access token and the refresh
+// token for a new session family. Only a hash of the refresh token is stored, so it can not be
+// recovered later.
+func StartSession(ctx context.Context, user types.User, device Device) (string, string, types.Error) {
+	userId, err := strconv.Atoi(user.Id)
+	if err != nil {
+		return "", "", customerrors.New(http.StatusInternalServerError, "could not convert user id to an int")
+	}
+
+	claims, customErr := userClaims(ctx, user)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	accessToken, refreshToken, session, customErr := newSessionTokens(claims, userId, randomToken(16), device)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	_, customErr = sessions.CreateSession(ctx, session)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new refresh token,
+// revoking the one presented. Presenting a refresh token that was already exchanged means it
+// has leaked, so every session descended from the same sign in is revoked.
+func RefreshSession(ctx context.Context, refreshToken string, device Device) (string, string, types.Error) {
+	session, customErr := sessions.GetSessionByTokenHash(ctx, HashToken(refreshToken))
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusNotFound {
+			return "", "", customerrors.New(http.StatusUnauthorized, "invalid refresh token")
+		}
+		return "", "", customErr
+	}
+
+	if session.RevokedAt != 0 {
+		return "", "", revokeFamily(ctx, session)
+	}
+
+	if time.Now().Unix() > session.ExpiresAt {
+		return "", "", customerrors.New(http.StatusUnauthorized, "refresh token has expired")
+	}
+
+	user, customErr := users.GetUser(ctx, strconv.Itoa(session.UserId))
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	claims, customErr := userClaims(ctx, user)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	accessToken, nextToken, next, customErr := newSessionTokens(claims, session.UserId, session.FamilyId, device)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	_, customErr = sessions.RotateSession(ctx, session.Id, next)
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusConflict {
+			// Someone else exchanged the same token between our read and the rotation.
+			return "", "", revokeFamily(ctx, session)
+		}
+		return "", "", customErr
+	}
+
+	return accessToken, nextToken, nil
+}
+
+// SignOut revokes the session the token in claims was issued for, along with the token itself.
+func SignOut(ctx context.Context, claims types.Claims) types.Error {
+	if claims.ApiTokenId != 0 {
+		return customerrors.New(http.StatusBadRequest, fmt.Sprintf("personal access tokens can not sign out, revoke it with DELETE /user/current/tokens/%d", claims.ApiTokenId))
+	}
+
+	if claims.SessionId != "" {
+		customErr := sessions.RevokeSessionFamily(ctx, claims.SessionId)
+		if customErr != nil {
+			return customErr
+		}
+	}
+
+	revoked, customErr := sessions.IsTokenRevoked(ctx, claims.StandardClaims.Id)
+	if customErr != nil || revoked {
+		return customErr
+	}
+
+	return sessions.RevokeToken(ctx, claims.StandardClaims.Id, claims.ExpiresAt)
+}
+
+func revokeFamily(ctx context.Context, session types.Session) types.Error {
+	log.Printf("Refresh token reuse detected for user %d, revoking session family", session.UserId)
+
+	customErr := sessions.RevokeSessionFamily(ctx, session.FamilyId)
+	if customErr != nil {
+		return customErr
+	}
+
+	return customerrors.New(http.StatusUnauthorized, "refresh token was already used, all sessions from this sign in have been revoked")
+}
+
+// newSessionTokens issues an access token with claims and a refresh token for a session in familyId.
+func newSessionTokens(claims types.Claims, userId int, familyId string, device Device) (string, string, types.Session, types.Error) {
+	refreshToken := randomToken(32)
+	if refreshToken == "" || familyId == "" {
+		return "", "", types.Session{}, customerrors.New(http.StatusInternalServerError, "could not generate refresh token")
+	}
+
+	claims.SessionId = familyId
+	accessToken, claims, customErr := newToken(claims, TOKEN_VALID_TIME)
+	if customErr != nil {
+		return "", "", types.Session{}, customErr
+	}
+
+	now := time.Now()
+	session := types.Session{
+		UserId:          userId,
+		FamilyId:        familyId,
+		TokenHash:       HashToken(refreshToken),
+		UserAgent:       truncate(device.UserAgent, 255),
+		IpAddress:       truncate(device.IpAddress, 64),
+		CreatedAt:       now.Unix(),
+		LastUsedAt:      now.Unix(),
+		ExpiresAt:       now.Add(REFRESH_TOKEN_VALID_TIME).Unix(),
+		AccessTokenId:   claims.StandardClaims.Id,
+		AccessExpiresAt: claims.ExpiresAt,
+	}
+
+	return accessToken, refreshToken, session, nil
+}
+
+// randomToken returns n random bytes encoded for use in URLs and headers, or "" if the system
+// random source fails.
+func randomToken(n int) string {
+	raw := make([]byte, n)
+	_, err := rand.Read(raw)
+	if err != nil {
+		log.Printf("could not read random bytes: %s", err.Error())
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// HashToken is how opaque tokens are stored, they are random enough that a plain SHA-256 is
+// sufficient and lets them be looked up by hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(value string, length int) string {
+	if len(value) > length {
+		return value[:length]
+	}
+
+	return value
+}
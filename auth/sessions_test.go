@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// staleSessionStore returns every session as it was the first time it was read, like a refresh
+// that read its session just before a concurrent refresh of the same token rotated it.
+type staleSessionStore struct {
+	*database.MemoryStore
+	read map[string]types.Session
+}
+
+func (s staleSessionStore) GetSessionByTokenHash(ctx context.Context, tokenHash string) (types.Session, types.Error) {
+	if session, ok := s.read[tokenHash]; ok {
+		return session, nil
+	}
+
+	session, customErr := s.MemoryStore.GetSessionByTokenHash(ctx, tokenHash)
+	if customErr == nil {
+		s.read[tokenHash] = session
+	}
+	return session, customErr
+}
+
+func TestRefreshSession(t *testing.T) {
+	// Each step presents one of the refresh tokens issued so far, 0 being the one from signing
+	// in, or -1 for one that was never issued. Successful refreshes add the next token.
+	type refreshStep struct {
+		token  int
+		status int
+	}
+
+	tests := []struct {
+		name string
+		// stale reads sessions through staleSessionStore.
+		stale bool
+		// expired starts the session with a refresh token that has already expired.
+		expired bool
+		steps   []refreshStep
+		// familyRevoked is whether the last access token issued ends up revoked.
+		familyRevoked bool
+	}{
+		{name: "rotates on every refresh", steps: []refreshStep{{0, http.StatusOK}, {1, http.StatusOK}, {2, http.StatusOK}}},
+		{name: "an unknown token", steps: []refreshStep{{-1, http.StatusUnauthorized}, {0, http.StatusOK}}},
+		{name: "reusing the first token revokes the family", steps: []refreshStep{{0, http.StatusOK}, {1, http.StatusOK}, {0, http.StatusUnauthorized}, {2, http.StatusUnauthorized}}, familyRevoked: true},
+		{name: "reusing the previous token revokes the family", steps: []refreshStep{{0, http.StatusOK}, {1, http.StatusOK}, {1, http.StatusUnauthorized}, {2, http.StatusUnauthorized}}, familyRevoked: true},
+		{name: "a racing refresh of the same token revokes the family", stale: true, steps: []refreshStep{{0, http.StatusOK}, {0, http.StatusUnauthorized}, {1, http.StatusUnauthorized}}, familyRevoked: true},
+		{name: "an expired token", expired: true, steps: []refreshStep{{0, http.StatusUnauthorized}, {0, http.StatusUnauthorized}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := useMemoryStore(t)
+			user := testUser(t, store, "ida", ROLE_EDITOR)
+			if test.stale {
+				sessions = staleSessionStore{MemoryStore: store, read: map[string]types.Session{}}
+			}
+
+			accessToken, refreshToken, customErr := StartSession(ctx, user, Device{UserAgent: "test"})
+			if customErr != nil {
+				t.Fatal(customErr.Description())
+			}
+
+			if test.expired {
+				claims, _ := userClaims(ctx, user)
+				var session types.Session
+				accessToken, refreshToken, session, customErr = newSessionTokens(claims, userId(t, user), randomToken(16), Device{})
+				if customErr != nil {
+					t.Fatal(customErr.Description())
+				}
+				session.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+				store.CreateSession(ctx, session)
+			}
+
+			// Another sign in, which must not be affected by anything done to the first one.
+			_, otherToken, customErr := StartSession(ctx, user, Device{UserAgent: "other"})
+			if customErr != nil {
+				t.Fatal(customErr.Description())
+			}
+
+			refreshTokens := []string{refreshToken}
+			for i, step := range test.steps {
+				presented := "never-issued"
+				if step.token >= 0 {
+					presented = refreshTokens[step.token]
+				}
+
+				nextAccess, nextRefresh, customErr := RefreshSession(ctx, presented, Device{UserAgent: "test"})
+				status := http.StatusOK
+				if customErr != nil {
+					status = customErr.StatusCode()
+				}
+				if status != step.status {
+					t.Fatalf("step %d: refreshing token %d returned %d, expected %d", i, step.token, status, step.status)
+				}
+				if customErr != nil {
+					continue
+				}
+
+				if nextRefresh == presented || nextAccess == accessToken {
+					t.Fatalf("step %d: refreshing did not issue new tokens", i)
+				}
+				if claims := tokenClaims(t, nextAccess); claims.Id != user.Id || claims.SessionId != tokenClaims(t, accessToken).SessionId {
+					t.Fatalf("step %d: the new access token is for user %s in session %s", i, claims.Id, claims.SessionId)
+				}
+				accessToken = nextAccess
+				refreshTokens = append(refreshTokens, nextRefresh)
+			}
+
+			revoked, _ := store.IsTokenRevoked(ctx, tokenClaims(t, accessToken).StandardClaims.Id)
+			if revoked != test.familyRevoked {
+				t.Fatalf("the last access token revoked is %t, expected %t", revoked, test.familyRevoked)
+			}
+
+			_, _, customErr = RefreshSession(ctx, otherToken, Device{UserAgent: "other"})
+			if customErr != nil {
+				t.Fatalf("the other sign in could not refresh: %s", customErr.Description())
+			}
+		})
+	}
+}
+
+func TestRefreshSessionReissuesScopes(t *testing.T) {
+	ctx := context.Background()
+	store := useMemoryStore(t)
+	user := testUser(t, store, "ida", ROLE_USER)
+
+	_, refreshToken, customErr := StartSession(ctx, user, Device{})
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+
+	// Permissions are read when the token is issued, not copied from the previous one.
+	rolePermissions = map[string][]string{ROLE_USER: {PERMISSION_SETS_READ}}
+	t.Cleanup(loadRolePermissions)
+
+	accessToken, _, customErr := RefreshSession(ctx, refreshToken, Device{})
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+
+	claims := tokenClaims(t, accessToken)
+	if len(claims.Scopes) != 1 || claims.Scopes[0] != PERMISSION_SETS_READ {
+		t.Fatalf("refreshed token has scopes %v", claims.Scopes)
+	}
+
+	active, _ := store.GetActiveSessionsForUser(ctx, userId(t, user))
+	if len(active) != 1 {
+		t.Fatalf("%d sessions are active after refreshing, expected 1", len(active))
+	}
+}
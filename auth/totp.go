@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TOTP parameters from RFC 6238, the defaults every authenticator app supports.
+const TOTP_PERIOD = 30
+const TOTP_DIGITS = 6
+const TOTP_SECRET_BYTES = 20
+
+// TOTP_SKEW is how many periods either side of now a code is accepted for, to allow for clock
+// drift and codes typed just as they changed.
+const TOTP_SKEW = 1
+
+const DEFAULT_TOTP_ISSUER = "SetsISaw"
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTotpSecret returns a random base32 secret, or "" if the system random source fails.
+func newTotpSecret() string {
+	raw := make([]byte, TOTP_SECRET_BYTES)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return ""
+	}
+
+	return totpEncoding.EncodeToString(raw)
+}
+
+// totpCode is the code for secret at the given time step, per RFC 4226.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%uint32(math.Pow10(TOTP_DIGITS))), nil
+}
+
+// checkTotp returns the time step code is valid for at now, or 0 if it is not valid.
+func checkTotp(secret string, code string, now time.Time) int64 {
+	current := now.Unix() / TOTP_PERIOD
+	for step := current - TOTP_SKEW; step <= current+TOTP_SKEW; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step
+		}
+	}
+
+	return 0
+}
+
+// otpauthUri is the key URI authenticator apps read from a QR code, see
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+func otpauthUri(secret string, username string) string {
+	issuer := os.Getenv("SETSISAW_TOTP_ISSUER")
+	if issuer == "" {
+		issuer = DEFAULT_TOTP_ISSUER
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	query.Set("period", fmt.Sprint(TOTP_PERIOD))
+
+	label := url.PathEscape(issuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTotpCode(t *testing.T) {
+	// The SHA1 test vectors from RFC 6238 appendix B. They have eight digits, codes are the
+	// same number modulo 10^6 so the last six are what authenticator apps show.
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, test := range tests {
+		step := test.unix / TOTP_PERIOD
+		expected := test.code[len(test.code)-TOTP_DIGITS:]
+
+		code, err := totpCode(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("code at %d is %s, expected %s", test.unix, code, expected)
+		}
+
+		if found := checkTotp(secret, expected, time.Unix(test.unix, 0)); found != step {
+			t.Errorf("code at %d was checked as step %d, expected %d", test.unix, found, step)
+		}
+	}
+}
+
+func TestCheckTotpSkew(t *testing.T) {
+	secret := newTotpSecret()
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / TOTP_PERIOD
+
+	for offset := int64(-2); offset <= 2; offset++ {
+		code, err := totpCode(secret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := current + offset
+		if offset < -TOTP_SKEW || offset > TOTP_SKEW {
+			expected = 0
+		}
+		if step := checkTotp(secret, code, now); step != expected {
+			t.Errorf("code %d periods from now was checked as step %d, expected %d", offset, step, expected)
+		}
+	}
+
+	if step := checkTotp("not base32!", "123456", now); step != 0 {
+		t.Errorf("a code for an invalid secret was checked as step %d", step)
+	}
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"context"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/dgrijalva/jwt-go"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TWO_FACTOR_REQUIRED_ROLE_SETTING names the setting holding the lowest role that must use
+// two-factor authentication, "" when nobody has to.
+const TWO_FACTOR_REQUIRED_ROLE_SETTING = "two_factor_required_role"
+
+// TWO_FACTOR_CHALLENGE_AUDIENCE marks the tokens SignIn returns in place of an access token to
+// users with two-factor authentication, they only work with CompleteTwoFactorChallenge.
+const TWO_FACTOR_CHALLENGE_AUDIENCE = "setsisaw:2fa-challenge"
+const TWO_FACTOR_CHALLENGE_VALID_TIME = 5 * time.Minute
+
+const RECOVERY_CODE_COUNT = 10
+
+// TwoFactorStatus is what a user sees about their own two-factor authentication.
+type TwoFactorStatus struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// GetTwoFactorRequiredRole returns the lowest role that must use two-factor authentication, or
+// "" when it is optional for everyone.
+func GetTwoFactorRequiredRole(ctx context.Context) (string, types.Error) {
+	return settings.GetSetting(ctx, TWO_FACTOR_REQUIRED_ROLE_SETTING)
+}
+
+// SetTwoFactorRequiredRole requires two-factor authentication for role and every role ranked
+// above it, or for nobody when role is "". Users who have not enabled it keep a USER's
+// permissions until they do.
+func SetTwoFactorRequiredRole(ctx context.Context, role string) types.Error {
+	role = strings.ToUpper(role)
+	if role != "" && ROLE_RANKS[role] == 0 {
+		return customerrors.New(http.StatusBadRequest, "role must be one of USER, EDITOR or ADMIN, or empty to make two-factor authentication optional")
+	}
+
+	return settings.SetSetting(ctx, TWO_FACTOR_REQUIRED_ROLE_SETTING, role)
+}
+
+// GetTwoFactorStatus returns whether the caller in claims has two-factor authentication
+// enabled, and whether their role requires it.
+func GetTwoFactorStatus(ctx context.Context, claims types.Claims) (TwoFactorStatus, types.Error) {
+	var status TwoFactorStatus
+	userId, customErr := claimsUserId(claims)
+	if customErr != nil {
+		return status, customErr
+	}
+
+	status.Required, customErr = isTwoFactorRequired(ctx, claims.Role)
+	if customErr != nil {
+		return status, customErr
+	}
+
+	status.Enabled, customErr = isTwoFactorEnabled(ctx, userId)
+	if customErr != nil || !status.Enabled {
+		return status, customErr
+	}
+
+	status.RecoveryCodesRemaining, customErr = twoFactors.CountRecoveryCodes(ctx, userId)
+	return status, customErr
+}
+
+// EnrollTwoFactor starts enrolling the caller in claims, returning the new secret and the
+// otpauth URI to show as a QR code. It is not enabled until ConfirmTwoFactor.
+func EnrollTwoFactor(ctx context.Context, claims types.Claims) (string, string, types.Error) {
+	userId, customErr := claimsUserId(claims)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	secret := newTotpSecret()
+	if secret == "" {
+		return "", "", customerrors.New(http.StatusInternalServerError, "could not generate secret")
+	}
+
+	customErr = twoFactors.StartTwoFactorEnrollment(ctx, types.TwoFactor{UserId: userId, Secret: secret, CreatedAt: time.Now().Unix()})
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	return secret, otpauthUri(secret, claims.Username), nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication for the caller in claims once they show a
+// code from their authenticator, and returns their recovery codes. They are only shown here.
+func ConfirmTwoFactor(ctx context.Context, claims types.Claims, code string) ([]string, types.Error) {
+	userId, customErr := claimsUserId(claims)
+	if customErr != nil {
+		return nil, customErr
+	}
+
+	twoFactor, customErr := twoFactors.GetTwoFactor(ctx, userId)
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusNotFound {
+			return nil, customerrors.New(http.StatusBadRequest, "start enrolling with POST /user/current/2fa first")
+		}
+		return nil, customErr
+	}
+
+	if twoFactor.EnabledAt != 0 {
+		return nil, customerrors.New(http.StatusConflict, "two-factor authentication is already enabled")
+	}
+
+	step := checkTotp(twoFactor.Secret, normalizeCode(code), time.Now())
+	if step == 0 {
+		return nil, customerrors.New(http.StatusUnauthorized, "invalid code")
+	}
+
+	codes, hashes, customErr := newRecoveryCodes()
+	if customErr != nil {
+		return nil, customErr
+	}
+
+	customErr = twoFactors.EnableTwoFactor(ctx, userId, step, hashes)
+	if customErr != nil {
+		return nil, customErr
+	}
+
+	log.Printf("User %d enabled two-factor authentication", userId)
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off for the caller in claims, given a
+// current code or a recovery code. Users whose role requires it can not turn it off.
+func DisableTwoFactor(ctx context.Context, claims types.Claims, code string) types.Error {
+	userId, customErr := claimsUserId(claims)
+	if customErr != nil {
+		return customErr
+	}
+
+	required, customErr := isTwoFactorRequired(ctx, claims.Role)
+	if customErr != nil {
+		return customErr
+	}
+
+	if required {
+		return customerrors.New(http.StatusForbidden, "two-factor authentication is required for your role")
+	}
+
+	customErr = checkSecondFactor(ctx, userId, code)
+	if customErr != nil {
+		return customErr
+	}
+
+	log.Printf("User %d disabled two-factor authentication", userId)
+	return twoFactors.DisableTwoFactor(ctx, userId)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the caller in claims, given a current
+// code or a recovery code.
+func RegenerateRecoveryCodes(ctx context.Context, claims types.Claims, code string) ([]string, types.Error) {
+	userId, customErr := claimsUserId(claims)
+	if customErr != nil {
+		return nil, customErr
+	}
+
+	customErr = checkSecondFactor(ctx, userId, code)
+	if customErr != nil {
+		return nil, customErr
+	}
+
+	codes, hashes, customErr := newRecoveryCodes()
+	if customErr != nil {
+		return nil, customErr
+	}
+
+	return codes, twoFactors.ReplaceRecoveryCodes(ctx, userId, hashes)
+}
+
+// StartTwoFactorChallenge returns a challenge token for a user who just gave their password,
+// or "" when they do not use two-factor authentication and can be signed in straight away.
+func StartTwoFactorChallenge(ctx context.Context, user types.User) (string, types.Error) {
+	userId, err := strconv.Atoi(user.Id)
+	if err != nil {
+		return "", customerrors.New(http.StatusInternalServerError, "could not convert user id to an int")
+	}
+
+	enabled, customErr := isTwoFactorEnabled(ctx, userId)
+	if customErr != nil || !enabled {
+		return "", customErr
+	}
+
+	claims := types.Claims{Username: user.Username, Id: user.Id, Scopes: []string{}}
+	claims.Audience = TWO_FACTOR_CHALLENGE_AUDIENCE
+	challenge, _, customErr := newToken(claims, TWO_FACTOR_CHALLENGE_VALID_TIME)
+	return challenge, customErr
+}
+
+// CompleteTwoFactorChallenge signs in the user a challenge token from StartTwoFactorChallenge
+// was issued to, given a current code or a recovery code. Each challenge works once.
+func CompleteTwoFactorChallenge(ctx context.Context, challenge string, code string, device Device) (string, string, types.Error) {
+	invalid := customerrors.New(http.StatusUnauthorized, "invalid or expired challenge token, sign in again")
+
+	claims := &types.Claims{}
+	tkn, err := jwt.ParseWithClaims(challenge, claims, verificationKey)
+	if err != nil || !tkn.Valid || claims.Audience != TWO_FACTOR_CHALLENGE_AUDIENCE || claims.StandardClaims.Id == "" {
+		return "", "", invalid
+	}
+
+	revoked, customErr := sessions.IsTokenRevoked(ctx, claims.StandardClaims.Id)
+	if customErr != nil {
+		return "", "", customErr
+	}
+	if revoked {
+		return "", "", invalid
+	}
+
+	userId, customErr := claimsUserId(*claims)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	// Guessing codes counts against the username like guessing passwords.
+	customErr = BeginLoginAttempt(ctx, claims.Username, device.IpAddress)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	customErr = checkSecondFactor(ctx, userId, code)
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusUnauthorized {
+			RecordLoginFailure(ctx, claims.Username, device.IpAddress)
+		} else {
+			CancelLoginAttempt(ctx, claims.Username, device.IpAddress)
+		}
+		return "", "", customErr
+	}
+	RecordLoginSuccess(ctx, claims.Username, device.IpAddress)
+
+	customErr = sessions.RevokeToken(ctx, claims.StandardClaims.Id, claims.ExpiresAt)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	user, customErr := users.GetUser(ctx, claims.Id)
+	if customErr != nil {
+		return "", "", customErr
+	}
+
+	return StartSession(ctx, user, device)
+}
+
+// checkSecondFactor accepts a current TOTP code, each at most once, or an unused recovery code,
+// which is then used up.
+func checkSecondFactor(ctx context.Context, userId int, code string) types.Error {
+	invalid := customerrors.New(http.StatusUnauthorized, "invalid code")
+
+	twoFactor, customErr := twoFactors.GetTwoFactor(ctx, userId)
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusNotFound {
+			return customerrors.New(http.StatusBadRequest, "two-factor authentication is not enabled")
+		}
+		return customErr
+	}
+
+	if twoFactor.EnabledAt == 0 {
+		return customerrors.New(http.StatusBadRequest, "two-factor authentication is not enabled")
+	}
+
+	code = normalizeCode(code)
+	if len(code) == TOTP_DIGITS {
+		step := checkTotp(twoFactor.Secret, code, time.Now())
+		if step == 0 {
+			return invalid
+		}
+
+		customErr = twoFactors.UseTotpStep(ctx, userId, step)
+		if customErr != nil && customErr.StatusCode() == http.StatusConflict {
+			return invalid
+		}
+		return customErr
+	}
+
+	customErr = twoFactors.UseRecoveryCode(ctx, userId, HashToken(code))
+	if customErr != nil && customErr.StatusCode() == http.StatusNotFound {
+		return invalid
+	}
+
+	if customErr == nil {
+		log.Printf("User %d signed in with a recovery code", userId)
+	}
+	return customErr
+}
+
+// twoFactorPending reports whether user's role requires two-factor authentication that they
+// have not enabled yet.
+func twoFactorPending(ctx context.Context, user types.User) (bool, types.Error) {
+	required, customErr := isTwoFactorRequired(ctx, user.Role)
+	if customErr != nil || !required {
+		return false, customErr
+	}
+
+	userId, err := strconv.Atoi(user.Id)
+	if err != nil {
+		return false, customerrors.New(http.StatusInternalServerError, "could not convert user id to an int")
+	}
+
+	enabled, customErr := isTwoFactorEnabled(ctx, userId)
+	return !enabled, customErr
+}
+
+func isTwoFactorRequired(ctx context.Context, role string) (bool, types.Error) {
+	minimum, customErr := GetTwoFactorRequiredRole(ctx)
+	if customErr != nil || minimum == "" {
+		return false, customErr
+	}
+
+	return IsRoleAtLeast(role, minimum), nil
+}
+
+func isTwoFactorEnabled(ctx context.Context, userId int) (bool, types.Error) {
+	twoFactor, customErr := twoFactors.GetTwoFactor(ctx, userId)
+	if customErr != nil {
+		if customErr.StatusCode() == http.StatusNotFound {
+			return false, nil
+		}
+		return false, customErr
+	}
+
+	return twoFactor.EnabledAt != 0, nil
+}
+
+// newRecoveryCodes returns RECOVERY_CODE_COUNT codes formatted for people, along with the hashes
+// to store.
+func newRecoveryCodes() ([]string, []string, types.Error) {
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	hashes := make([]string, 0, RECOVERY_CODE_COUNT)
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		secret := newTotpSecret()
+		if secret == "" {
+			return nil, nil, customerrors.New(http.StatusInternalServerError, "could not generate recovery codes")
+		}
+
+		code := strings.ToLower(secret[:10])
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, HashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeCode lets codes be typed with spaces, dashes or in either case.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func claimsUserId(claims types.Claims) (int, types.Error) {
+	userId, err := strconv.Atoi(claims.Id)
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "could not convert user id to an int")
+	}
+
+	return userId, nil
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTwoFactorSignIn(t *testing.T) {
+	ctx := context.Background()
+	store := useMemoryStore(t)
+	user := testUser(t, store, "ida", ROLE_USER)
+	claims, customErr := userClaims(ctx, user)
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+
+	secret, _, customErr := EnrollTwoFactor(ctx, claims)
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+
+	current := time.Now().Unix() / TOTP_PERIOD
+	confirmed, _ := totpCode(secret, current)
+	recoveryCodes, customErr := ConfirmTwoFactor(ctx, claims, confirmed)
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+	if len(recoveryCodes) != RECOVERY_CODE_COUNT {
+		t.Fatalf("%d recovery codes, expected %d", len(recoveryCodes), RECOVERY_CODE_COUNT)
+	}
+	next, _ := totpCode(secret, current+1)
+
+	// Each code is presented with a new challenge.
+	tests := []struct {
+		name   string
+		code   string
+		status int
+	}{
+		{name: "the code used to confirm", code: confirmed, status: http.StatusUnauthorized},
+		{name: "a later code", code: next, status: http.StatusOK},
+		{name: "the same code again", code: next, status: http.StatusUnauthorized},
+		{name: "a recovery code", code: recoveryCodes[0], status: http.StatusOK},
+		{name: "the same recovery code again", code: recoveryCodes[0], status: http.StatusUnauthorized},
+		{name: "a recovery code typed without its dash and in upper case", code: strings.ToUpper(strings.Replace(recoveryCodes[1], "-", " ", 1)), status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		challenge, customErr := StartTwoFactorChallenge(ctx, user)
+		if customErr != nil {
+			t.Fatal(customErr.Description())
+		}
+
+		_, _, customErr = CompleteTwoFactorChallenge(ctx, challenge, test.code, Device{})
+		status := http.StatusOK
+		if customErr != nil {
+			status = customErr.StatusCode()
+		}
+		if status != test.status {
+			t.Errorf("%s: returned %d, expected %d", test.name, status, test.status)
+		}
+	}
+
+	// Steps are only ever used in order, so neither the last one nor an earlier one comes back.
+	for _, step := range []int64{current + 1, current} {
+		customErr = store.UseTotpStep(ctx, userId(t, user), step)
+		if customErr == nil || customErr.StatusCode() != http.StatusConflict {
+			t.Errorf("step %d was used again", step)
+		}
+	}
+	customErr = store.UseTotpStep(ctx, userId(t, user), current+2)
+	if customErr != nil {
+		t.Errorf("a new step was refused: %s", customErr.Description())
+	}
+
+	status, _ := GetTwoFactorStatus(ctx, claims)
+	if status.RecoveryCodesRemaining != RECOVERY_CODE_COUNT-2 {
+		t.Fatalf("%d recovery codes remain, expected %d", status.RecoveryCodesRemaining, RECOVERY_CODE_COUNT-2)
+	}
+}
+
+func TestTwoFactorChallengeToken(t *testing.T) {
+	ctx := context.Background()
+	store := useMemoryStore(t)
+	user := testUser(t, store, "ida", ROLE_USER)
+	claims, _ := userClaims(ctx, user)
+
+	secret, _, _ := EnrollTwoFactor(ctx, claims)
+	current := time.Now().Unix() / TOTP_PERIOD
+	code, _ := totpCode(secret, current-1)
+	_, customErr := ConfirmTwoFactor(ctx, claims, code)
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+
+	challenge, customErr := StartTwoFactorChallenge(ctx, user)
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+
+	// A challenge is signed like an access token, only its audience tells them apart.
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/authcheck", nil)
+	c.Request.Header.Set("Authorization", "Bearer "+challenge)
+	_, customErr = GetUserInfo(c)
+	if customErr == nil || customErr.StatusCode() != http.StatusUnauthorized {
+		t.Fatal("a challenge token was accepted as an access token")
+	}
+
+	code, _ = totpCode(secret, current)
+	accessToken, _, customErr := CompleteTwoFactorChallenge(ctx, challenge, code, Device{})
+	if customErr != nil {
+		t.Fatal(customErr.Description())
+	}
+
+	// Each challenge works once, even with a code that has not been used yet.
+	code, _ = totpCode(secret, current+1)
+	_, _, customErr = CompleteTwoFactorChallenge(ctx, challenge, code, Device{})
+	if customErr == nil || customErr.StatusCode() != http.StatusUnauthorized {
+		t.Fatal("a challenge was completed twice")
+	}
+
+	// An access token is no challenge either.
+	_, _, customErr = CompleteTwoFactorChallenge(ctx, accessToken, code, Device{})
+	if customErr == nil || customErr.StatusCode() != http.StatusUnauthorized {
+		t.Fatal("an access token was accepted as a challenge")
+	}
+
+	c.Request.Header.Set("Authorization", "Bearer "+accessToken)
+	_, customErr = GetUserInfo(c)
+	if customErr != nil {
+		t.Fatalf("the access token was refused: %s", customErr.Description())
+	}
+}
@@ -1,17 +1,56 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"github.com/AnthonyNixon/setsisaw/env"
 	"log"
+	"math"
 	"os"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 var DSN = ""
 
-func Initialize() {
+// Default connection pool settings, each can be overridden with the matching
+// SETSISAW_DB_* environment variable.
+const (
+	DEFAULT_MAX_OPEN_CONNS    = 10
+	DEFAULT_MAX_IDLE_CONNS    = 5
+	DEFAULT_CONN_MAX_LIFETIME = 5 * time.Minute
+	DEFAULT_QUERY_TIMEOUT     = 5 * time.Second
+	DEFAULT_SQLITE_PATH       = "setsisaw.db"
+)
+
+// QueryTimeout bounds how long a single request may spend talking to the database.
+var QueryTimeout = DEFAULT_QUERY_TIMEOUT
+
+// Initialize sets up the storage backend chosen by SETSISAW_DB_DRIVER, one of "mysql"
+// (the default), "sqlite" or "memory".
+func Initialize() Store {
 	log.Print("Initializing Database...")
+	driver := os.Getenv("SETSISAW_DB_DRIVER")
+
+	switch driver {
+	case "", "mysql":
+		return NewSQLStore(openMySQL(), "mysql")
+	case "sqlite":
+		return NewSQLStore(openSQLite(), "sqlite")
+	case "memory":
+		log.Print("Using in-memory storage, all data will be lost on exit")
+		log.Print("done")
+		return NewMemoryStore()
+	default:
+		log.Fatalf("Unknown SETSISAW_DB_DRIVER %q. Not Starting.", driver)
+		return nil
+	}
+}
+
+// openMySQL opens the shared connection pool used for the lifetime of the process.
+func openMySQL() *sql.DB {
 	var DB_USER = os.Getenv("SETSISAW_DB_USER")
 	var DB_PASS = os.Getenv("SETSISAW_DB_PASS")
 	var DB_HOST = os.Getenv("SETSISAW_DB_HOST")
@@ -41,27 +80,58 @@ func Initialize() {
 
 	DSN = DB_USER + ":" + DB_PASS + "@tcp(" + DB_HOST + ":3306)/" + DB_NAME
 
-	connection, err := GetConnection()
+	db, err := sql.Open("mysql", DSN)
+	if err != nil {
+		log.Fatal("Failed to initiate database connection. Not Starting.")
+	}
+
+	db.SetMaxOpenConns(env.Int("SETSISAW_DB_MAX_OPEN_CONNS", DEFAULT_MAX_OPEN_CONNS, 0, math.MaxInt32))
+	db.SetMaxIdleConns(env.Int("SETSISAW_DB_MAX_IDLE_CONNS", DEFAULT_MAX_IDLE_CONNS, 0, math.MaxInt32))
+	db.SetConnMaxLifetime(env.Duration("SETSISAW_DB_CONN_MAX_LIFETIME", DEFAULT_CONN_MAX_LIFETIME, 0))
+	QueryTimeout = env.Duration("SETSISAW_DB_QUERY_TIMEOUT", DEFAULT_QUERY_TIMEOUT, time.Second)
+
+	// make sure our connection is available
+	ctx, cancel := NewContext(context.Background())
+	defer cancel()
+	err = db.PingContext(ctx)
 	if err != nil {
 		log.Fatal("Failed to initiate database connection. Not Starting.")
 	}
 
-	_ = connection.Close()
 	log.Print("done")
+	return db
 }
 
-func GetConnection() (*sql.DB, error) {
-	// Open DB connection
-	db, err := sql.Open("mysql", DSN)
+// openSQLite opens the database file at SETSISAW_DB_PATH (default setsisaw.db). SQLite only
+// allows one writer at a time, so the pool is limited to a single connection.
+func openSQLite() *sql.DB {
+	path := os.Getenv("SETSISAW_DB_PATH")
+	if path == "" {
+		path = DEFAULT_SQLITE_PATH
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
 	if err != nil {
-		return nil, err
+		log.Fatal("Failed to open SQLite database. Not Starting. " + err.Error())
 	}
 
-	// make sure our connection is available
-	err = db.Ping()
+	db.SetMaxOpenConns(1)
+	QueryTimeout = env.Duration("SETSISAW_DB_QUERY_TIMEOUT", DEFAULT_QUERY_TIMEOUT, time.Second)
+
+	ctx, cancel := NewContext(context.Background())
+	defer cancel()
+	err = db.PingContext(ctx)
 	if err != nil {
-		return nil, err
+		log.Fatal("Failed to open SQLite database. Not Starting. " + err.Error())
 	}
 
-	return db, nil
+	log.Printf("Using SQLite database %s", path)
+	log.Print("done")
+	return db
+}
+
+// NewContext derives a context for database work from parent, cancelled after QueryTimeout
+// or as soon as parent is done (e.g. when the client disconnects).
+func NewContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, QueryTimeout)
 }
@@ -0,0 +1,1776 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/names"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps everything in process memory. It is meant for local
+// development and tests; all data is lost when the process exits. Text comparisons are
+// case-insensitive to match MySQL's default collation.
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	users         map[int]types.User
+	artists       map[int]types.Artist
+	locations     map[int]types.Location
+	festivals     map[int]types.Festival
+	stages        map[int]types.Stage
+	sets          map[int]types.Set
+	sessions      map[int]types.Session
+	apiTokens     map[int]types.ApiToken
+	resets        map[int]types.PasswordReset
+	verifications map[int]types.EmailVerification
+	identities    map[int]types.UserIdentity
+	twoFactors    map[int]types.TwoFactor
+	// recoveryCodes maps user ids to their unused recovery code hashes.
+	recoveryCodes map[int]map[string]bool
+	settings      map[string]string
+	throttles     map[string]types.LoginThrottle
+	artistAliases map[int]types.ArtistAlias
+	// artistRedirects maps the ids of merged artists to the artist they were merged into.
+	artistRedirects map[int]int
+	// revokedTokens maps revoked access token ids to when they expire.
+	revokedTokens map[string]int64
+	// roleChanges is the audit trail kept by ChangeUserRole.
+	roleChanges []types.RoleChange
+
+	lastUserId         int
+	lastArtistId       int
+	lastLocationId     int
+	lastFestivalId     int
+	lastStageId        int
+	lastSetId          int
+	lastRoleChangeId   int
+	lastSessionId      int
+	lastApiTokenId     int
+	lastResetId        int
+	lastVerificationId int
+	lastIdentityId     int
+	lastArtistAliasId  int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         make(map[int]types.User),
+		artists:       make(map[int]types.Artist),
+		locations:     make(map[int]types.Location),
+		festivals:     make(map[int]types.Festival),
+		stages:        make(map[int]types.Stage),
+		sets:          make(map[int]types.Set),
+		sessions:      make(map[int]types.Session),
+		apiTokens:     make(map[int]types.ApiToken),
+		resets:        make(map[int]types.PasswordReset),
+		verifications: make(map[int]types.EmailVerification),
+		identities:    make(map[int]types.UserIdentity),
+		twoFactors:    make(map[int]types.TwoFactor),
+		recoveryCodes: make(map[int]map[string]bool),
+		settings:      make(map[string]string),
+		throttles:     make(map[string]types.LoginThrottle),
+		artistAliases: make(map[int]types.ArtistAlias),
+
+		revokedTokens:   make(map[string]int64),
+		artistRedirects: make(map[int]int),
+	}
+}
+
+// Users
+
+func (s *MemoryStore) IsUserUnique(ctx context.Context, username string, email string) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Username, username) || strings.EqualFold(user.Email, email) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) IsUserUpdateUnique(ctx context.Context, update types.User) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Id == update.Id {
+			continue
+		}
+		if strings.EqualFold(user.Username, update.Username) || strings.EqualFold(user.Email, update.Email) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, user types.User) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastUserId++
+	user.Id = strconv.Itoa(s.lastUserId)
+	user.Role = "USER"
+	user.EmailVerified = false
+	s.users[s.lastUserId] = user
+
+	return s.lastUserId, nil
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, id string) (types.User, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[memoryId(id)]
+	if !ok {
+		return types.User{}, customerrors.New(http.StatusNotFound, "user not found")
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+func (s *MemoryStore) GetUserByUsername(ctx context.Context, username string) (types.User, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Username, username) {
+			return user, nil
+		}
+	}
+
+	return types.User{}, customerrors.New(http.StatusNotFound, "user not found")
+}
+
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (types.User, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Email, email) {
+			user.Password = ""
+			return user, nil
+		}
+	}
+
+	return types.User{}, customerrors.New(http.StatusNotFound, "user not found")
+}
+
+func (s *MemoryStore) GetAllUsers(ctx context.Context, page types.PageRequest) ([]types.User, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, USER_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]types.User, 0, len(s.users))
+	for _, user := range s.users {
+		user.Password = ""
+		all = append(all, user)
+	}
+
+	indexes, info := query.pageIndexes(len(all), func(i int) (interface{}, int) {
+		return userSortValue(all[i], query.order.field), memoryId(all[i].Id)
+	})
+
+	users := make([]types.User, 0, len(indexes))
+	for _, i := range indexes {
+		users = append(users, all[i])
+	}
+
+	return users, info, nil
+}
+
+func (s *MemoryStore) UpdateUser(ctx context.Context, update types.User) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := memoryId(update.Id)
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+
+	user.Username = update.Username
+	user.Email = update.Email
+	user.FirstName = update.FirstName
+	user.LastName = update.LastName
+	user.EmailVerified = update.EmailVerified
+	s.users[id] = user
+
+	return nil
+}
+
+func (s *MemoryStore) ChangeUserRole(ctx context.Context, change types.RoleChange) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[change.UserId]
+	if !ok {
+		return nil
+	}
+
+	user.Role = change.NewRole
+	s.users[change.UserId] = user
+
+	s.lastRoleChangeId++
+	change.Id = s.lastRoleChangeId
+	change.ChangedAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+	s.roleChanges = append(s.roleChanges, change)
+
+	s.revokeUserApiTokens(change.UserId)
+	s.revokeSessions(func(session types.Session) bool {
+		return session.UserId == change.UserId
+	})
+	return nil
+}
+
+func (s *MemoryStore) UpdatePassword(ctx context.Context, userId int, passwordHash string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setPassword(userId, passwordHash)
+	return nil
+}
+
+func (s *MemoryStore) ChangePassword(ctx context.Context, userId int, passwordHash string, keepFamilyId string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setPassword(userId, passwordHash)
+	s.revokeUserApiTokens(userId)
+	s.revokeSessions(func(session types.Session) bool {
+		return session.UserId == userId && session.FamilyId != keepFamilyId
+	})
+	return nil
+}
+
+// Artists
+
+func (s *MemoryStore) IsArtistUnique(ctx context.Context, name string) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return !s.isArtistNameTaken(names.Normalize(name), 0), nil
+}
+
+func (s *MemoryStore) CreateArtist(ctx context.Context, artist types.Artist) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastArtistId++
+	artist.Id = s.lastArtistId
+	s.artists[artist.Id] = artist
+
+	return artist.Id, nil
+}
+
+func (s *MemoryStore) GetArtist(ctx context.Context, id string) (types.Artist, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	artist, ok := s.artists[memoryId(id)]
+	if !ok {
+		return artist, customerrors.New(http.StatusNotFound, "artist not found")
+	}
+
+	return artist, nil
+}
+
+func (s *MemoryStore) GetAllArtists(ctx context.Context, page types.PageRequest) ([]types.Artist, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, ARTIST_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]types.Artist, 0, len(s.artists))
+	for _, artist := range s.artists {
+		all = append(all, artist)
+	}
+
+	indexes, info := query.pageIndexes(len(all), func(i int) (interface{}, int) {
+		return artistSortValue(all[i], query.order.field), all[i].Id
+	})
+
+	artists := make([]types.Artist, 0, len(indexes))
+	for _, i := range indexes {
+		artists = append(artists, all[i])
+	}
+
+	return artists, info, nil
+}
+
+func (s *MemoryStore) GetArtistDefaultGenre(ctx context.Context, name string, id int) (string, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if artist, ok := s.artists[id]; ok {
+		return artist.DefaultGenre, nil
+	}
+
+	normalized := names.Normalize(name)
+	for _, artist := range s.artists {
+		if names.Normalize(artist.Name) == normalized {
+			return artist.DefaultGenre, nil
+		}
+	}
+
+	for _, alias := range s.artistAliases {
+		if names.Normalize(alias.Name) == normalized {
+			return s.artists[alias.ArtistId].DefaultGenre, nil
+		}
+	}
+
+	return "", customerrors.New(http.StatusNotFound, "artist not found")
+}
+
+func (s *MemoryStore) IsArtistUpdateUnique(ctx context.Context, artist types.Artist) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return !s.isArtistNameTaken(names.Normalize(artist.Name), artist.Id), nil
+}
+
+func (s *MemoryStore) UpdateArtist(ctx context.Context, artist types.Artist) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.artists[artist.Id]; ok {
+		s.artists[artist.Id] = artist
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteArtist(ctx context.Context, id int, cascade bool) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.artists[id]; !ok {
+		return 0, customerrors.New(http.StatusNotFound, fmt.Sprintf("artist %d not found", id))
+	}
+
+	sets := 0
+	for _, set := range s.sets {
+		if set.ArtistId == id {
+			sets++
+		}
+	}
+
+	if sets > 0 && !cascade {
+		return 0, customerrors.New(http.StatusConflict, fmt.Sprintf("artist has %d sets, merge it into another artist or delete them too with cascade=true", sets))
+	}
+
+	for setId, set := range s.sets {
+		if set.ArtistId == id {
+			delete(s.sets, setId)
+		}
+	}
+
+	for from, to := range s.artistRedirects {
+		if to == id {
+			delete(s.artistRedirects, from)
+		}
+	}
+
+	for aliasId, alias := range s.artistAliases {
+		if alias.ArtistId == id {
+			delete(s.artistAliases, aliasId)
+		}
+	}
+
+	delete(s.artists, id)
+	return sets, nil
+}
+
+func (s *MemoryStore) MergeArtists(ctx context.Context, artistId int, duplicateIds []int) (types.ArtistMerge, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	merge := types.ArtistMerge{ArtistId: artistId, MergedArtistIds: duplicateIds}
+	if _, ok := s.artists[artistId]; !ok {
+		return merge, customerrors.New(http.StatusNotFound, "artist not found")
+	}
+
+	// Check everything first, there is no transaction to roll back.
+	for _, duplicateId := range duplicateIds {
+		if _, ok := s.artists[duplicateId]; !ok {
+			return merge, customerrors.New(http.StatusNotFound, fmt.Sprintf("artist %d not found", duplicateId))
+		}
+	}
+
+	for _, duplicateId := range duplicateIds {
+		for setId, set := range s.sets {
+			if set.ArtistId != duplicateId {
+				continue
+			}
+
+			moved := set
+			moved.ArtistId = artistId
+			if s.hasSameSet(moved) {
+				delete(s.sets, setId)
+				merge.DuplicateSetsRemoved++
+				continue
+			}
+
+			s.sets[setId] = moved
+			merge.SetsMoved++
+		}
+
+		for from, to := range s.artistRedirects {
+			if to == duplicateId {
+				s.artistRedirects[from] = artistId
+			}
+		}
+
+		for aliasId, alias := range s.artistAliases {
+			if alias.ArtistId == duplicateId {
+				alias.ArtistId = artistId
+				s.artistAliases[aliasId] = alias
+			}
+		}
+
+		duplicate := s.artists[duplicateId]
+		delete(s.artists, duplicateId)
+		if !s.isArtistNameTaken(names.Normalize(duplicate.Name), 0) {
+			s.lastArtistAliasId++
+			s.artistAliases[s.lastArtistAliasId] = types.ArtistAlias{Id: s.lastArtistAliasId, ArtistId: artistId, Name: duplicate.Name, CreatedAt: time.Now().Unix()}
+		}
+		s.artistRedirects[duplicateId] = artistId
+	}
+
+	return merge, nil
+}
+
+func (s *MemoryStore) GetArtistRedirect(ctx context.Context, id string) (int, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	artistId, ok := s.artistRedirects[memoryId(id)]
+	if !ok {
+		return 0, customerrors.New(http.StatusNotFound, "artist not found")
+	}
+
+	return artistId, nil
+}
+
+func (s *MemoryStore) GetArtistNames(ctx context.Context) ([]types.ArtistName, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	artistNames := make([]types.ArtistName, 0, len(s.artists)+len(s.artistAliases))
+	for _, artist := range s.artists {
+		artistNames = append(artistNames, types.ArtistName{Artist: artist, Name: artist.Name, NormalizedName: names.Normalize(artist.Name)})
+	}
+
+	for _, alias := range s.artistAliases {
+		artistNames = append(artistNames, types.ArtistName{Artist: s.artists[alias.ArtistId], Name: alias.Name, NormalizedName: names.Normalize(alias.Name)})
+	}
+
+	return artistNames, nil
+}
+
+func (s *MemoryStore) GetArtistAliases(ctx context.Context, artistId int) ([]types.ArtistAlias, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	aliases := make([]types.ArtistAlias, 0)
+	for _, alias := range s.artistAliases {
+		if alias.ArtistId == artistId {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Name < aliases[j].Name })
+	return aliases, nil
+}
+
+func (s *MemoryStore) CreateArtistAlias(ctx context.Context, alias types.ArtistAlias) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isArtistNameTaken(names.Normalize(alias.Name), 0) {
+		return 0, customerrors.New(http.StatusConflict, "an artist is already known by that name")
+	}
+
+	s.lastArtistAliasId++
+	alias.Id = s.lastArtistAliasId
+	s.artistAliases[alias.Id] = alias
+
+	return alias.Id, nil
+}
+
+func (s *MemoryStore) DeleteArtistAlias(ctx context.Context, artistId int, aliasId int) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alias, ok := s.artistAliases[aliasId]
+	if !ok || alias.ArtistId != artistId {
+		return customerrors.New(http.StatusNotFound, "alias not found")
+	}
+
+	delete(s.artistAliases, aliasId)
+	return nil
+}
+
+// isArtistNameTaken reports whether an artist other than exceptArtistId is known by normalized,
+// by name or alias. It requires the caller to hold the read or write lock.
+func (s *MemoryStore) isArtistNameTaken(normalized string, exceptArtistId int) bool {
+	for _, artist := range s.artists {
+		if artist.Id != exceptArtistId && names.Normalize(artist.Name) == normalized {
+			return true
+		}
+	}
+
+	for _, alias := range s.artistAliases {
+		if alias.ArtistId != exceptArtistId && names.Normalize(alias.Name) == normalized {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Locations
+
+func (s *MemoryStore) IsLocationUnique(ctx context.Context, location types.Location) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, existing := range s.locations {
+		if sameLocationPlace(existing, location) && (!existing.IsFestival || existing.Year == location.Year) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) IsLocationUpdateUnique(ctx context.Context, location types.Location) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, existing := range s.locations {
+		if existing.Id != location.Id && sameLocationPlace(existing, location) && existing.Year == location.Year {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) CreateLocation(ctx context.Context, location types.Location) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastLocationId++
+	location.Id = s.lastLocationId
+	s.locations[location.Id] = location
+
+	return location.Id, nil
+}
+
+func (s *MemoryStore) GetLocation(ctx context.Context, id string) (types.Location, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	location, ok := s.locations[memoryId(id)]
+	if !ok {
+		return location, customerrors.New(http.StatusNotFound, "location not found")
+	}
+
+	return location, nil
+}
+
+func (s *MemoryStore) GetAllLocations(ctx context.Context, page types.PageRequest) ([]types.Location, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, LOCATION_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]types.Location, 0, len(s.locations))
+	for _, location := range s.locations {
+		all = append(all, location)
+	}
+
+	indexes, info := query.pageIndexes(len(all), func(i int) (interface{}, int) {
+		return locationSortValue(all[i], query.order.field), all[i].Id
+	})
+
+	locations := make([]types.Location, 0, len(indexes))
+	for _, i := range indexes {
+		locations = append(locations, all[i])
+	}
+
+	return locations, info, nil
+}
+
+func (s *MemoryStore) UpdateLocation(ctx context.Context, location types.Location) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.locations[location.Id]; ok {
+		s.locations[location.Id] = location
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteLocation(ctx context.Context, id int, cascade bool) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.locations[id]; !ok {
+		return 0, customerrors.New(http.StatusNotFound, fmt.Sprintf("location %d not found", id))
+	}
+
+	sets := 0
+	for _, set := range s.sets {
+		if set.LocationId == id {
+			sets++
+		}
+	}
+
+	if sets > 0 && !cascade {
+		return 0, customerrors.New(http.StatusConflict, fmt.Sprintf("location has %d sets, merge it into another location or delete them too with cascade=true", sets))
+	}
+
+	for setId, set := range s.sets {
+		if set.LocationId == id {
+			delete(s.sets, setId)
+		}
+	}
+
+	for stageId, stage := range s.stages {
+		if stage.LocationId == id {
+			delete(s.stages, stageId)
+		}
+	}
+
+	delete(s.locations, id)
+	return sets, nil
+}
+
+func (s *MemoryStore) MergeLocations(ctx context.Context, locationId int, duplicateIds []int) (types.LocationMerge, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	merge := types.LocationMerge{LocationId: locationId, MergedLocationIds: duplicateIds}
+	location, ok := s.locations[locationId]
+	if !ok {
+		return merge, customerrors.New(http.StatusNotFound, "location not found")
+	}
+
+	// Check everything first, there is no transaction to roll back.
+	for _, duplicateId := range duplicateIds {
+		duplicate, ok := s.locations[duplicateId]
+		if !ok {
+			return merge, customerrors.New(http.StatusNotFound, fmt.Sprintf("location %d not found", duplicateId))
+		}
+
+		if duplicate.IsFestival != location.IsFestival {
+			return merge, customerrors.New(http.StatusBadRequest, fmt.Sprintf("location %d and location %d are not both festivals, they can not be merged", locationId, duplicateId))
+		}
+	}
+
+	for _, duplicateId := range duplicateIds {
+		// Stages move along, those with the same name as one already at locationId are folded into it.
+		kept := map[string]int{}
+		for _, stage := range s.stages {
+			if stage.LocationId == locationId {
+				kept[strings.ToLower(stage.Name)] = stage.Id
+			}
+		}
+
+		folded := map[int]int{}
+		for stageId, stage := range s.stages {
+			if stage.LocationId != duplicateId {
+				continue
+			}
+
+			if keptId, ok := kept[strings.ToLower(stage.Name)]; ok {
+				folded[stageId] = keptId
+				delete(s.stages, stageId)
+				continue
+			}
+
+			stage.LocationId = locationId
+			s.stages[stageId] = stage
+		}
+
+		for setId, set := range s.sets {
+			if set.LocationId != duplicateId {
+				continue
+			}
+
+			moved := set
+			moved.LocationId = locationId
+			if keptId, ok := folded[moved.StageId]; ok {
+				moved.StageId = keptId
+			}
+			if s.hasSameSet(moved) {
+				delete(s.sets, setId)
+				merge.DuplicateSetsRemoved++
+				continue
+			}
+
+			s.sets[setId] = moved
+			merge.SetsMoved++
+		}
+
+		delete(s.locations, duplicateId)
+	}
+
+	return merge, nil
+}
+
+func (s *MemoryStore) IsFestival(ctx context.Context, locationId int) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	location, ok := s.locations[locationId]
+	if !ok {
+		return false, customerrors.New(http.StatusNotFound, "location not found")
+	}
+
+	return location.IsFestival, nil
+}
+
+// Stages
+
+func (s *MemoryStore) GetStages(ctx context.Context, locationId int) ([]types.Stage, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stages := make([]types.Stage, 0)
+	for _, stage := range s.stages {
+		if stage.LocationId == locationId {
+			stages = append(stages, stage)
+		}
+	}
+
+	sort.Slice(stages, func(i, j int) bool { return strings.ToLower(stages[i].Name) < strings.ToLower(stages[j].Name) })
+	return stages, nil
+}
+
+func (s *MemoryStore) GetStage(ctx context.Context, id string) (types.Stage, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stage, ok := s.stages[memoryId(id)]
+	if !ok {
+		return stage, customerrors.New(http.StatusNotFound, "stage not found")
+	}
+
+	return stage, nil
+}
+
+func (s *MemoryStore) IsStageUnique(ctx context.Context, stage types.Stage) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, existing := range s.stages {
+		if existing.Id != stage.Id && existing.LocationId == stage.LocationId && strings.EqualFold(existing.Name, stage.Name) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) CreateStage(ctx context.Context, stage types.Stage) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastStageId++
+	stage.Id = s.lastStageId
+	s.stages[stage.Id] = stage
+
+	return stage.Id, nil
+}
+
+func (s *MemoryStore) UpdateStage(ctx context.Context, stage types.Stage) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.stages[stage.Id]; ok {
+		existing.Name = stage.Name
+		s.stages[stage.Id] = existing
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteStage(ctx context.Context, locationId int, stageId int) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stage, ok := s.stages[stageId]
+	if !ok || stage.LocationId != locationId {
+		return 0, customerrors.New(http.StatusNotFound, "stage not found")
+	}
+
+	cleared := 0
+	for setId, set := range s.sets {
+		if set.StageId == stageId {
+			set.StageId = 0
+			s.sets[setId] = set
+			cleared++
+		}
+	}
+
+	delete(s.stages, stageId)
+	return cleared, nil
+}
+
+// Festivals
+
+func (s *MemoryStore) IsFestivalUnique(ctx context.Context, name string) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, festival := range s.festivals {
+		if strings.EqualFold(festival.Name, name) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) IsFestivalUpdateUnique(ctx context.Context, festival types.Festival) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, existing := range s.festivals {
+		if existing.Id != festival.Id && strings.EqualFold(existing.Name, festival.Name) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) CreateFestival(ctx context.Context, festival types.Festival) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastFestivalId++
+	festival.Id = s.lastFestivalId
+	s.festivals[festival.Id] = festival
+
+	return festival.Id, nil
+}
+
+func (s *MemoryStore) GetFestival(ctx context.Context, id string) (types.Festival, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	festival, ok := s.festivals[memoryId(id)]
+	if !ok {
+		return festival, customerrors.New(http.StatusNotFound, "festival not found")
+	}
+
+	return festival, nil
+}
+
+func (s *MemoryStore) GetFestivalByName(ctx context.Context, name string) (types.Festival, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, festival := range s.festivals {
+		if strings.EqualFold(festival.Name, name) {
+			return festival, nil
+		}
+	}
+
+	return types.Festival{}, customerrors.New(http.StatusNotFound, "festival not found")
+}
+
+func (s *MemoryStore) GetAllFestivals(ctx context.Context, page types.PageRequest) ([]types.Festival, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, FESTIVAL_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]types.Festival, 0, len(s.festivals))
+	for _, festival := range s.festivals {
+		all = append(all, festival)
+	}
+
+	indexes, info := query.pageIndexes(len(all), func(i int) (interface{}, int) {
+		return festivalSortValue(all[i], query.order.field), all[i].Id
+	})
+
+	festivals := make([]types.Festival, 0, len(indexes))
+	for _, i := range indexes {
+		festivals = append(festivals, all[i])
+	}
+
+	return festivals, info, nil
+}
+
+func (s *MemoryStore) UpdateFestival(ctx context.Context, festival types.Festival) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.festivals[festival.Id]; ok {
+		s.festivals[festival.Id] = festival
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetFestivalEditions(ctx context.Context, festivalId int) ([]types.Location, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	editions := make([]types.Location, 0)
+	for _, location := range s.locations {
+		if location.FestivalId == festivalId {
+			editions = append(editions, location)
+		}
+	}
+
+	sort.Slice(editions, func(i, j int) bool {
+		if editions[i].Year != editions[j].Year {
+			return editions[i].Year < editions[j].Year
+		}
+		return editions[i].Id < editions[j].Id
+	})
+	return editions, nil
+}
+
+// Sets
+
+func (s *MemoryStore) IsSetUnique(ctx context.Context, set types.Set) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, existing := range s.sets {
+		if sameSet(existing, set) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) IsSetUpdateUnique(ctx context.Context, set types.Set) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, existing := range s.sets {
+		if existing.Id != set.Id && sameSet(existing, set) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) CreateSet(ctx context.Context, set types.Set) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSetId++
+	set.Id = s.lastSetId
+	s.sets[set.Id] = set
+
+	return set.Id, nil
+}
+
+func (s *MemoryStore) GetSet(ctx context.Context, id string) (types.Set, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set, ok := s.joinSet(s.sets[memoryId(id)])
+	if !ok {
+		return set, customerrors.New(http.StatusNotFound, "set not found")
+	}
+
+	return set, nil
+}
+
+func (s *MemoryStore) ListSets(ctx context.Context, filter types.SetFilter, page types.PageRequest) ([]types.Set, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, SET_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]types.Set, 0)
+	for _, set := range s.sets {
+		set, ok := s.joinSet(set)
+		if filter.FestivalId != 0 && s.locations[set.LocationId].FestivalId != filter.FestivalId {
+			continue
+		}
+		if ok && matchesSetFilter(set, filter) {
+			all = append(all, set)
+		}
+	}
+
+	indexes, info := query.pageIndexes(len(all), func(i int) (interface{}, int) {
+		return setSortValue(all[i], query.order.field), all[i].Id
+	})
+
+	sets := make([]types.Set, 0, len(indexes))
+	for _, i := range indexes {
+		sets = append(sets, all[i])
+	}
+
+	return sets, info, nil
+}
+
+func (s *MemoryStore) UpdateSet(ctx context.Context, update types.Set) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set, ok := s.sets[update.Id]
+	if !ok {
+		return nil
+	}
+
+	set.ArtistId = update.ArtistId
+	set.LocationId = update.LocationId
+	set.StageId = update.StageId
+	set.Date = update.Date
+	set.Metadata = update.Metadata
+	s.sets[set.Id] = set
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteSet(ctx context.Context, id int) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sets, id)
+	return nil
+}
+
+// Sessions
+
+func (s *MemoryStore) CreateSession(ctx context.Context, session types.Session) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createSession(session), nil
+}
+
+func (s *MemoryStore) GetSession(ctx context.Context, id string) (types.Session, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[memoryId(id)]
+	if !ok {
+		return session, customerrors.New(http.StatusNotFound, "session not found")
+	}
+
+	return session, nil
+}
+
+func (s *MemoryStore) GetSessionByTokenHash(ctx context.Context, tokenHash string) (types.Session, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, session := range s.sessions {
+		if session.TokenHash == tokenHash {
+			return session, nil
+		}
+	}
+
+	return types.Session{}, customerrors.New(http.StatusNotFound, "session not found")
+}
+
+func (s *MemoryStore) GetActiveSessionsForUser(ctx context.Context, userId int) ([]types.Session, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now().Unix()
+	sessions := make([]types.Session, 0)
+	for _, session := range s.sessions {
+		if session.UserId == userId && session.RevokedAt == 0 && session.ExpiresAt > now {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].LastUsedAt != sessions[j].LastUsedAt {
+			return sessions[i].LastUsedAt > sessions[j].LastUsedAt
+		}
+		return sessions[i].Id > sessions[j].Id
+	})
+
+	return sessions, nil
+}
+
+func (s *MemoryStore) RotateSession(ctx context.Context, oldId int, next types.Session) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.sessions[oldId]
+	if !ok || old.RevokedAt != 0 {
+		return 0, customerrors.New(http.StatusConflict, "session was already revoked")
+	}
+
+	old.RevokedAt = next.CreatedAt
+	s.sessions[oldId] = old
+	if old.AccessTokenId != "" && old.AccessExpiresAt > next.CreatedAt {
+		s.revokedTokens[old.AccessTokenId] = old.AccessExpiresAt
+	}
+
+	return s.createSession(next), nil
+}
+
+func (s *MemoryStore) RevokeSessionFamily(ctx context.Context, familyId string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeSessions(func(session types.Session) bool { return session.FamilyId == familyId })
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserSessions(ctx context.Context, userId int) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeSessions(func(session types.Session) bool { return session.UserId == userId })
+	return nil
+}
+
+func (s *MemoryStore) RevokeToken(ctx context.Context, tokenId string, expiresAt int64) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneRevokedTokens()
+	s.revokedTokens[tokenId] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) IsTokenRevoked(ctx context.Context, tokenId string) (bool, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, revoked := s.revokedTokens[tokenId]
+	return revoked, nil
+}
+
+// revokeSessions revokes the matching sessions and their unexpired access tokens, the caller
+// must hold the write lock.
+func (s *MemoryStore) revokeSessions(match func(session types.Session) bool) {
+	s.pruneRevokedTokens()
+
+	now := time.Now().Unix()
+	for id, session := range s.sessions {
+		if !match(session) {
+			continue
+		}
+
+		if session.AccessTokenId != "" && session.AccessExpiresAt > now {
+			s.revokedTokens[session.AccessTokenId] = session.AccessExpiresAt
+		}
+
+		if session.RevokedAt == 0 {
+			session.RevokedAt = now
+			s.sessions[id] = session
+		}
+	}
+}
+
+func (s *MemoryStore) pruneRevokedTokens() {
+	now := time.Now().Unix()
+	for tokenId, expiresAt := range s.revokedTokens {
+		if expiresAt < now {
+			delete(s.revokedTokens, tokenId)
+		}
+	}
+}
+
+// createSession stores a new session, the caller must hold the write lock.
+func (s *MemoryStore) createSession(session types.Session) int {
+	s.lastSessionId++
+	session.Id = s.lastSessionId
+	s.sessions[session.Id] = session
+	return session.Id
+}
+
+// API tokens
+
+func (s *MemoryStore) CreateApiToken(ctx context.Context, token types.ApiToken) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastApiTokenId++
+	token.Id = s.lastApiTokenId
+	s.apiTokens[token.Id] = token
+	return token.Id, nil
+}
+
+func (s *MemoryStore) GetApiToken(ctx context.Context, id string) (types.ApiToken, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.apiTokens[memoryId(id)]
+	if !ok {
+		return token, customerrors.New(http.StatusNotFound, "token not found")
+	}
+
+	return token, nil
+}
+
+func (s *MemoryStore) GetApiTokenByHash(ctx context.Context, tokenHash string) (types.ApiToken, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.apiTokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+
+	return types.ApiToken{}, customerrors.New(http.StatusNotFound, "token not found")
+}
+
+func (s *MemoryStore) GetApiTokensForUser(ctx context.Context, userId int) ([]types.ApiToken, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make([]types.ApiToken, 0)
+	for _, token := range s.apiTokens {
+		if token.UserId == userId && token.RevokedAt == 0 {
+			tokens = append(tokens, token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Id < tokens[j].Id })
+	return tokens, nil
+}
+
+func (s *MemoryStore) RevokeApiToken(ctx context.Context, id int) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, ok := s.apiTokens[id]; ok && token.RevokedAt == 0 {
+		token.RevokedAt = time.Now().Unix()
+		s.apiTokens[id] = token
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) revokeUserApiTokens(userId int) {
+	now := time.Now().Unix()
+	for id, token := range s.apiTokens {
+		if token.UserId == userId && token.RevokedAt == 0 {
+			token.RevokedAt = now
+			s.apiTokens[id] = token
+		}
+	}
+}
+
+func (s *MemoryStore) TouchApiToken(ctx context.Context, id int, usedAt int64) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, ok := s.apiTokens[id]; ok {
+		token.LastUsedAt = usedAt
+		s.apiTokens[id] = token
+	}
+
+	return nil
+}
+
+// Password resets
+
+func (s *MemoryStore) CreatePasswordReset(ctx context.Context, reset types.PasswordReset) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastResetId++
+	reset.Id = s.lastResetId
+	s.resets[reset.Id] = reset
+	return reset.Id, nil
+}
+
+func (s *MemoryStore) GetPasswordResetByHash(ctx context.Context, tokenHash string) (types.PasswordReset, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, reset := range s.resets {
+		if reset.TokenHash == tokenHash {
+			return reset, nil
+		}
+	}
+
+	return types.PasswordReset{}, customerrors.New(http.StatusNotFound, "password reset not found")
+}
+
+func (s *MemoryStore) ResetPassword(ctx context.Context, reset types.PasswordReset, passwordHash string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.resets[reset.Id].UsedAt != 0 {
+		return customerrors.New(http.StatusConflict, "password reset was already used")
+	}
+
+	now := time.Now().Unix()
+	for id, outstanding := range s.resets {
+		if outstanding.UserId == reset.UserId && outstanding.UsedAt == 0 {
+			outstanding.UsedAt = now
+			s.resets[id] = outstanding
+		}
+	}
+
+	s.setPassword(reset.UserId, passwordHash)
+
+	s.revokeUserApiTokens(reset.UserId)
+	s.revokeSessions(func(session types.Session) bool { return session.UserId == reset.UserId })
+	return nil
+}
+
+// Email verifications
+
+func (s *MemoryStore) CreateEmailVerification(ctx context.Context, verification types.EmailVerification) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastVerificationId++
+	verification.Id = s.lastVerificationId
+	s.verifications[verification.Id] = verification
+	return verification.Id, nil
+}
+
+func (s *MemoryStore) GetEmailVerificationByHash(ctx context.Context, tokenHash string) (types.EmailVerification, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, verification := range s.verifications {
+		if verification.TokenHash == tokenHash {
+			return verification, nil
+		}
+	}
+
+	return types.EmailVerification{}, customerrors.New(http.StatusNotFound, "email verification not found")
+}
+
+func (s *MemoryStore) VerifyEmail(ctx context.Context, verification types.EmailVerification) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.verifications[verification.Id]
+	if stored.UsedAt != 0 {
+		return customerrors.New(http.StatusConflict, "email verification was already used")
+	}
+
+	user, ok := s.users[verification.UserId]
+	if !ok || !strings.EqualFold(user.Email, verification.Email) {
+		return customerrors.New(http.StatusConflict, "email has changed since the verification was sent")
+	}
+
+	stored.UsedAt = time.Now().Unix()
+	s.verifications[verification.Id] = stored
+	user.EmailVerified = true
+	s.users[verification.UserId] = user
+	return nil
+}
+
+// User identities
+
+func (s *MemoryStore) GetUserIdentity(ctx context.Context, issuer string, subject string) (types.UserIdentity, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, ok := s.findUserIdentity(issuer, subject)
+	if !ok {
+		return identity, customerrors.New(http.StatusNotFound, "identity not linked")
+	}
+
+	return identity, nil
+}
+
+func (s *MemoryStore) LinkUserIdentity(ctx context.Context, identity types.UserIdentity) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.linkUserIdentity(identity)
+}
+
+func (s *MemoryStore) CreateUserWithIdentity(ctx context.Context, user types.User, identity types.UserIdentity) (int, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.findUserIdentity(identity.Issuer, identity.Subject); ok {
+		return 0, customerrors.New(http.StatusConflict, "identity is already linked to a user")
+	}
+
+	s.lastUserId++
+	user.Id = strconv.Itoa(s.lastUserId)
+	user.Role = "USER"
+	user.EmailVerified = true
+	s.users[s.lastUserId] = user
+
+	identity.UserId = s.lastUserId
+	_, customErr := s.linkUserIdentity(identity)
+	if customErr != nil {
+		return 0, customErr
+	}
+
+	return s.lastUserId, nil
+}
+
+func (s *MemoryStore) TouchUserIdentity(ctx context.Context, id int, email string, loginAt int64) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if identity, ok := s.identities[id]; ok {
+		identity.Email = email
+		identity.LastLoginAt = loginAt
+		s.identities[id] = identity
+	}
+
+	return nil
+}
+
+// findUserIdentity requires the caller to hold the read or write lock.
+func (s *MemoryStore) findUserIdentity(issuer string, subject string) (types.UserIdentity, bool) {
+	for _, identity := range s.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return identity, true
+		}
+	}
+
+	return types.UserIdentity{}, false
+}
+
+// linkUserIdentity requires the caller to hold the write lock.
+func (s *MemoryStore) linkUserIdentity(identity types.UserIdentity) (int, types.Error) {
+	if _, ok := s.findUserIdentity(identity.Issuer, identity.Subject); ok {
+		return 0, customerrors.New(http.StatusConflict, "identity is already linked to a user")
+	}
+
+	s.lastIdentityId++
+	identity.Id = s.lastIdentityId
+	s.identities[identity.Id] = identity
+	return identity.Id, nil
+}
+
+// Two-factor authentication
+
+func (s *MemoryStore) GetTwoFactor(ctx context.Context, userId int) (types.TwoFactor, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	twoFactor, ok := s.twoFactors[userId]
+	if !ok {
+		return twoFactor, customerrors.New(http.StatusNotFound, "two-factor authentication is not set up")
+	}
+
+	return twoFactor, nil
+}
+
+func (s *MemoryStore) StartTwoFactorEnrollment(ctx context.Context, twoFactor types.TwoFactor) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.twoFactors[twoFactor.UserId].EnabledAt != 0 {
+		return customerrors.New(http.StatusConflict, "two-factor authentication is already enabled")
+	}
+
+	twoFactor.EnabledAt = 0
+	s.twoFactors[twoFactor.UserId] = twoFactor
+	return nil
+}
+
+func (s *MemoryStore) EnableTwoFactor(ctx context.Context, userId int, step int64, recoveryCodeHashes []string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	twoFactor, ok := s.twoFactors[userId]
+	if !ok || twoFactor.EnabledAt != 0 {
+		return customerrors.New(http.StatusConflict, "two-factor authentication is already enabled")
+	}
+
+	twoFactor.EnabledAt = time.Now().Unix()
+	twoFactor.LastUsedStep = step
+	s.twoFactors[userId] = twoFactor
+	s.setRecoveryCodes(userId, recoveryCodeHashes)
+	return nil
+}
+
+func (s *MemoryStore) DisableTwoFactor(ctx context.Context, userId int) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.twoFactors, userId)
+	delete(s.recoveryCodes, userId)
+	return nil
+}
+
+func (s *MemoryStore) UseTotpStep(ctx context.Context, userId int, step int64) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	twoFactor, ok := s.twoFactors[userId]
+	if !ok || twoFactor.LastUsedStep >= step {
+		return customerrors.New(http.StatusConflict, "code was already used")
+	}
+
+	twoFactor.LastUsedStep = step
+	s.twoFactors[userId] = twoFactor
+	return nil
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(ctx context.Context, userId int, recoveryCodeHashes []string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setRecoveryCodes(userId, recoveryCodeHashes)
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(ctx context.Context, userId int, codeHash string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.recoveryCodes[userId][codeHash] {
+		return customerrors.New(http.StatusNotFound, "recovery code not found")
+	}
+
+	delete(s.recoveryCodes[userId], codeHash)
+	return nil
+}
+
+func (s *MemoryStore) CountRecoveryCodes(ctx context.Context, userId int) (int, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.recoveryCodes[userId]), nil
+}
+
+// setRecoveryCodes requires the caller to hold the write lock.
+func (s *MemoryStore) setRecoveryCodes(userId int, recoveryCodeHashes []string) {
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, codeHash := range recoveryCodeHashes {
+		codes[codeHash] = true
+	}
+
+	s.recoveryCodes[userId] = codes
+}
+
+// Settings
+
+func (s *MemoryStore) GetSetting(ctx context.Context, name string) (string, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.settings[name], nil
+}
+
+func (s *MemoryStore) SetSetting(ctx context.Context, name string, value string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.settings[name] = value
+	return nil
+}
+
+// Login throttles
+
+func (s *MemoryStore) GetLoginThrottle(ctx context.Context, key string) (types.LoginThrottle, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	throttle, ok := s.throttles[key]
+	if !ok {
+		return types.LoginThrottle{Key: key}, nil
+	}
+
+	return throttle, nil
+}
+
+func (s *MemoryStore) GetActiveLoginThrottles(ctx context.Context, now int64, since int64) ([]types.LoginThrottle, types.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	throttles := make([]types.LoginThrottle, 0)
+	for _, throttle := range s.throttles {
+		if throttle.LockedUntil > now || throttle.LastFailureAt > since {
+			throttles = append(throttles, throttle)
+		}
+	}
+
+	sort.Slice(throttles, func(i, j int) bool { return throttles[i].LastFailureAt > throttles[j].LastFailureAt })
+	return throttles, nil
+}
+
+func (s *MemoryStore) AddLoginFailure(ctx context.Context, previous types.LoginThrottle, now int64, forgetBefore int64) (types.LoginThrottle, types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	throttle, ok := s.throttles[previous.Key]
+	if !ok {
+		throttle = types.LoginThrottle{Key: previous.Key}
+	}
+
+	if throttle.Failures != previous.Failures || throttle.LastFailureAt != previous.LastFailureAt {
+		return types.LoginThrottle{}, customerrors.New(http.StatusConflict, "login throttle changed")
+	}
+
+	if throttle.LastFailureAt <= forgetBefore {
+		throttle.Failures = 0
+	}
+
+	throttle.Failures++
+	throttle.LastFailureAt = now
+	s.throttles[previous.Key] = throttle
+	return throttle, nil
+}
+
+func (s *MemoryStore) RemoveLoginFailure(ctx context.Context, key string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if throttle, ok := s.throttles[key]; ok && throttle.Failures > 0 {
+		throttle.Failures--
+		s.throttles[key] = throttle
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) LockLogin(ctx context.Context, key string, until int64) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if throttle, ok := s.throttles[key]; ok {
+		throttle.LockedUntil = until
+		s.throttles[key] = throttle
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) ClearLoginThrottle(ctx context.Context, key string) types.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.throttles, key)
+	return nil
+}
+
+// setPassword requires the caller to hold the write lock.
+func (s *MemoryStore) setPassword(userId int, passwordHash string) {
+	if user, ok := s.users[userId]; ok {
+		user.Password = passwordHash
+		s.users[userId] = user
+	}
+}
+
+// matchesSetFilter applies the same conditions as setListQuery, except FestivalId which needs the location.
+func matchesSetFilter(set types.Set, filter types.SetFilter) bool {
+	if filter.UserId != 0 && set.UserId != filter.UserId {
+		return false
+	}
+	if filter.ArtistId != 0 && set.ArtistId != filter.ArtistId {
+		return false
+	}
+	if filter.LocationId != 0 && set.LocationId != filter.LocationId {
+		return false
+	}
+	if filter.StageId != 0 && set.StageId != filter.StageId {
+		return false
+	}
+	if filter.Stage != "" && !strings.EqualFold(set.StageName, filter.Stage) {
+		return false
+	}
+	if filter.Genre != "" && !strings.EqualFold(set.Metadata.Genre, filter.Genre) {
+		return false
+	}
+	if filter.MinRating != 0 && set.Metadata.Rating < filter.MinRating {
+		return false
+	}
+	if filter.From != "" && set.Date < filter.From {
+		return false
+	}
+	if filter.To != "" && set.Date > filter.To {
+		return false
+	}
+
+	return true
+}
+
+// joinSet fills in the artist and location names for a set, reporting false when either
+// is missing, just like the INNER JOINs in the SQL queries.
+func (s *MemoryStore) joinSet(set types.Set) (types.Set, bool) {
+	artist, ok := s.artists[set.ArtistId]
+	if !ok || set.Id == 0 {
+		return set, false
+	}
+
+	location, ok := s.locations[set.LocationId]
+	if !ok {
+		return set, false
+	}
+
+	set.ArtistName = artist.Name
+	set.LocationName = location.Name
+	set.StageName = s.stages[set.StageId].Name
+	return set, true
+}
+
+func sameLocationPlace(a types.Location, b types.Location) bool {
+	return strings.EqualFold(a.Name, b.Name) &&
+		strings.EqualFold(a.City, b.City) &&
+		strings.EqualFold(a.State, b.State) &&
+		strings.EqualFold(a.Country, b.Country)
+}
+
+// hasSameSet reports whether another set matches set, the caller must hold the read or write lock.
+func (s *MemoryStore) hasSameSet(set types.Set) bool {
+	for _, existing := range s.sets {
+		if existing.Id != set.Id && sameSet(existing, set) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sameSet(a types.Set, b types.Set) bool {
+	return a.UserId == b.UserId && a.ArtistId == b.ArtistId && a.LocationId == b.LocationId && a.Date == b.Date
+}
+
+// memoryId converts a path id to a map key, anything unparsable becomes 0 which is never used.
+func memoryId(id string) int {
+	parsed, err := strconv.Atoi(id)
+	if err != nil {
+		return 0
+	}
+
+	return parsed
+}
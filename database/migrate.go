@@ -0,0 +1,350 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations live in migrations/<driver>/ as pairs of NNNN_description.up.sql and
+// NNNN_description.down.sql files. Statements are separated by a ";" at the end of a line.
+//
+// Each script runs in a transaction, but MySQL commits every schema change as it is made, so a
+// MySQL script that fails partway leaves its earlier statements applied. A migration's version
+// is recorded as dirty before its script runs and only marked clean once it succeeds, and no
+// migrations run while one is dirty until it is resolved by hand and marked with Force.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationState struct {
+	Migration
+	Applied bool
+	Dirty   bool
+}
+
+// Migrate applies every migration that has not been recorded in schema_migrations yet,
+// oldest first, and returns how many were applied.
+func Migrate(ctx context.Context, store *SQLStore) (int, error) {
+	migrations, applied, dirty, err := loadMigrationState(ctx, store)
+	if err != nil {
+		return 0, err
+	}
+
+	if dirty != 0 {
+		return 0, dirtyError(dirty)
+	}
+
+	count := 0
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		log.Printf("Applying migration %04d_%s", migration.Version, migration.Name)
+		err = runMigration(ctx, store, migration, true)
+		if err != nil {
+			return count, fmt.Errorf("migration %04d_%s failed, %s", migration.Version, migration.Name, err.Error())
+		}
+		count++
+	}
+
+	// Names can only be normalized in Go, so rows from before normalized_name and festival_id
+	// existed are filled in here.
+	err = store.normalizeArtistNames(ctx)
+	if err != nil {
+		return count, fmt.Errorf("could not normalize artist names, %s", err.Error())
+	}
+
+	err = store.linkFestivalEditions(ctx)
+	if err != nil {
+		return count, fmt.Errorf("could not add festival locations to series, %s", err.Error())
+	}
+
+	return count, nil
+}
+
+// Rollback reverts the most recently applied migrations, at most steps of them,
+// and returns how many were reverted.
+func Rollback(ctx context.Context, store *SQLStore, steps int) (int, error) {
+	migrations, applied, dirty, err := loadMigrationState(ctx, store)
+	if err != nil {
+		return 0, err
+	}
+
+	if dirty != 0 {
+		return 0, dirtyError(dirty)
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := migrations[i]
+		if !applied[migration.Version] {
+			continue
+		}
+
+		log.Printf("Reverting migration %04d_%s", migration.Version, migration.Name)
+		err = runMigration(ctx, store, migration, false)
+		if err != nil {
+			return count, fmt.Errorf("rollback of %04d_%s failed, %s", migration.Version, migration.Name, err.Error())
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// MigrationStatus lists every known migration and whether it has been applied.
+func MigrationStatus(ctx context.Context, store *SQLStore) ([]MigrationState, error) {
+	migrations, applied, dirty, err := loadMigrationState(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		states = append(states, MigrationState{migration, applied[migration.Version], migration.Version == dirty})
+	}
+
+	return states, nil
+}
+
+// Force records migration version as applied or not, and clean, without running it. It is how
+// a dirty migration is resolved once its changes were finished or undone by hand.
+func Force(ctx context.Context, store *SQLStore, version int, applied bool) error {
+	migrations, _, _, err := loadMigrationState(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.Version != version {
+			continue
+		}
+
+		tx, err := store.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		_, err = tx.ExecContext(ctx, DELETE_MIGRATION, version)
+		if err != nil {
+			return err
+		}
+
+		if applied {
+			_, err = tx.ExecContext(ctx, INSERT_MIGRATION, version, migration.Name, false)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit()
+	}
+
+	return fmt.Errorf("there is no migration %04d", version)
+}
+
+// loadMigrationState returns every known migration, which versions are recorded as applied and
+// the version of the dirty migration, 0 if none is.
+func loadMigrationState(ctx context.Context, store *SQLStore) ([]Migration, map[int]bool, int, error) {
+	migrations, err := loadMigrations(path.Join("migrations", store.Driver))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	db := store.DB
+	_, err = db.ExecContext(ctx, CREATE_SCHEMA_MIGRATIONS_TABLE)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("could not create schema_migrations table, %s", err.Error())
+	}
+
+	// schema_migrations tables from before dirty tracking need the column added.
+	_, err = db.ExecContext(ctx, HAS_SCHEMA_MIGRATIONS_DIRTY)
+	if err != nil {
+		_, err = db.ExecContext(ctx, ADD_SCHEMA_MIGRATIONS_DIRTY)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("could not add dirty to schema_migrations, %s", err.Error())
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, GET_APPLIED_MIGRATIONS)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("could not read schema_migrations, %s", err.Error())
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	dirty := 0
+	for rows.Next() {
+		var version int
+		var isDirty bool
+		err := rows.Scan(&version, &isDirty)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		applied[version] = true
+		if isDirty {
+			dirty = version
+		}
+	}
+
+	return migrations, applied, dirty, rows.Err()
+}
+
+func dirtyError(version int) error {
+	return fmt.Errorf("migration %04d is dirty, it failed partway and may be half applied. Finish or undo its changes by hand, "+
+		"then run migrate force %d applied or migrate force %d pending", version, version, version)
+}
+
+// runMigration runs migration's up or down script, marking its version dirty until the script
+// succeeds. SQLite rolls back a failed script entirely, so there the mark is undone again.
+func runMigration(ctx context.Context, store *SQLStore, migration Migration, up bool) error {
+	var err error
+	script, finish, undo := migration.Up, MARK_MIGRATION_CLEAN, DELETE_MIGRATION
+	if up {
+		_, err = store.DB.ExecContext(ctx, INSERT_MIGRATION, migration.Version, migration.Name, true)
+	} else {
+		script, finish, undo = migration.Down, DELETE_MIGRATION, MARK_MIGRATION_CLEAN
+		_, err = store.DB.ExecContext(ctx, MARK_MIGRATION_DIRTY, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = runScript(ctx, store.DB, script, finish, migration.Version)
+	if err != nil && store.Driver == "sqlite" {
+		_, undoErr := store.DB.ExecContext(context.Background(), undo, migration.Version)
+		if undoErr != nil {
+			log.Printf("could not clear dirty migration %04d, %s", migration.Version, undoErr.Error())
+		}
+	}
+
+	return err
+}
+
+func runScript(ctx context.Context, db *sql.DB, script string, record string, args ...interface{}) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range splitStatements(script) {
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad migration file name %s", fileName)
+		}
+
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %s", fileName)
+		}
+
+		contents, err := fs.ReadFile(migrationFiles, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = migration
+		}
+
+		if direction == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func splitStatements(script string) []string {
+	statements := make([]string, 0)
+	var current strings.Builder
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+
+	return statements
+}
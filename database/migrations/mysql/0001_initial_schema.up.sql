@@ -0,0 +1,49 @@
+CREATE TABLE IF NOT EXISTS users (
+    id INT NOT NULL AUTO_INCREMENT,
+    username VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    password VARCHAR(255) NOT NULL,
+    first_name VARCHAR(255) NULL,
+    last_name VARCHAR(255) NULL,
+    role VARCHAR(32) NOT NULL DEFAULT 'USER',
+    PRIMARY KEY (id),
+    UNIQUE KEY users_username (username),
+    UNIQUE KEY users_email (email)
+);
+
+CREATE TABLE IF NOT EXISTS artists (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    default_genre VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS locations (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NULL,
+    city VARCHAR(255) NULL,
+    state VARCHAR(255) NULL,
+    country VARCHAR(255) NULL,
+    is_festival BOOLEAN NOT NULL DEFAULT FALSE,
+    year INT NULL,
+    PRIMARY KEY (id)
+);
+
+-- date is stored as YYYY-MM-DD text so festival sets without a known day can leave it empty.
+CREATE TABLE IF NOT EXISTS sets (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    artist_id INT NOT NULL,
+    location_id INT NOT NULL,
+    date VARCHAR(10) NOT NULL DEFAULT '',
+    rating INT NOT NULL DEFAULT 0,
+    genre VARCHAR(255) NOT NULL DEFAULT '',
+    length INT NOT NULL DEFAULT 0,
+    notes TEXT NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY sets_unique_set (user_id, artist_id, location_id, date),
+    CONSTRAINT sets_user_fk FOREIGN KEY (user_id) REFERENCES users (id),
+    CONSTRAINT sets_artist_fk FOREIGN KEY (artist_id) REFERENCES artists (id),
+    CONSTRAINT sets_location_fk FOREIGN KEY (location_id) REFERENCES locations (id)
+);
@@ -0,0 +1,12 @@
+-- Audit trail of every change to a user's role.
+CREATE TABLE IF NOT EXISTS role_changes (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    changed_by INT NOT NULL,
+    old_role VARCHAR(32) NOT NULL,
+    new_role VARCHAR(32) NOT NULL,
+    changed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    CONSTRAINT role_changes_user_fk FOREIGN KEY (user_id) REFERENCES users (id),
+    CONSTRAINT role_changes_changed_by_fk FOREIGN KEY (changed_by) REFERENCES users (id)
+);
@@ -0,0 +1,19 @@
+-- One row per refresh token. Rotating a token revokes its row and adds a new one to the same
+-- family, so a revoked token coming back means it was stolen and the whole family is revoked.
+-- Times are unix seconds.
+CREATE TABLE IF NOT EXISTS sessions (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    family_id VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL,
+    last_used_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    revoked_at BIGINT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY sessions_token_hash (token_hash),
+    KEY sessions_family (family_id),
+    CONSTRAINT sessions_user_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS revoked_tokens;
+ALTER TABLE sessions DROP COLUMN access_expires_at;
+ALTER TABLE sessions DROP COLUMN access_token_id;
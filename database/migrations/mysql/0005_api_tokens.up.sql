@@ -0,0 +1,16 @@
+-- Personal access tokens. Only a hash of the token is kept, scopes is a space separated list
+-- of permissions and times are unix seconds.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    scopes TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    last_used_at BIGINT NOT NULL DEFAULT 0,
+    revoked_at BIGINT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY api_tokens_token_hash (token_hash),
+    CONSTRAINT api_tokens_user_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
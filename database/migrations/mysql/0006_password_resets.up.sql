@@ -0,0 +1,12 @@
+-- One time password reset tokens, only a hash of each is kept. Times are unix seconds.
+CREATE TABLE IF NOT EXISTS password_resets (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    used_at BIGINT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY password_resets_token_hash (token_hash),
+    CONSTRAINT password_resets_user_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
@@ -0,0 +1,18 @@
+-- Accounts that already exist are treated as verified, new ones start unverified.
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET email_verified = TRUE;
+
+-- One time email verification tokens, only a hash of each is kept. The token verifies email
+-- only while it is still the user's email. Times are unix seconds.
+CREATE TABLE IF NOT EXISTS email_verifications (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL,
+    used_at BIGINT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY email_verifications_token_hash (token_hash),
+    CONSTRAINT email_verifications_user_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
@@ -0,0 +1,29 @@
+-- TOTP secrets, a row without enabled_at is an enrollment waiting to be confirmed. last_used_step
+-- is the last accepted 30 second time step, so a code can not be replayed. Times are unix seconds.
+CREATE TABLE IF NOT EXISTS two_factor (
+    user_id INT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    created_at BIGINT NOT NULL,
+    enabled_at BIGINT NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id),
+    CONSTRAINT two_factor_user_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- One time recovery codes, only a hash of each is kept.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at BIGINT NULL,
+    PRIMARY KEY (id),
+    KEY recovery_codes_user (user_id),
+    CONSTRAINT recovery_codes_user_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- Instance wide settings changed through the API.
+CREATE TABLE IF NOT EXISTS settings (
+    name VARCHAR(64) NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (name)
+);
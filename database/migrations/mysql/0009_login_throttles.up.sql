@@ -0,0 +1,8 @@
+-- Failed sign in attempts, keyed by "username:<name>" or "ip:<address>". Times are unix seconds.
+CREATE TABLE IF NOT EXISTS login_throttles (
+    throttle_key VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at BIGINT NOT NULL,
+    locked_until BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (throttle_key)
+);
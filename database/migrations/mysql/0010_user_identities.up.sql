@@ -0,0 +1,14 @@
+-- Links users to accounts at an OpenID Connect provider, by the provider's issuer and subject.
+-- Times are unix seconds.
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    created_at BIGINT NOT NULL,
+    last_login_at BIGINT NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY user_identities_issuer_subject (issuer, subject),
+    CONSTRAINT user_identities_user_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
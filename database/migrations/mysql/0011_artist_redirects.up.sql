@@ -0,0 +1,9 @@
+-- Artists that were merged into another one, so their old ids keep resolving. Times are unix
+-- seconds.
+CREATE TABLE IF NOT EXISTS artist_redirects (
+    from_id INT NOT NULL,
+    to_id INT NOT NULL,
+    created_at BIGINT NOT NULL,
+    PRIMARY KEY (from_id),
+    KEY artist_redirects_to_id (to_id)
+);
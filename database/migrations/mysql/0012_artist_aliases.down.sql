@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS artist_aliases;
+DROP INDEX artists_normalized_name ON artists;
+ALTER TABLE artists DROP COLUMN normalized_name;
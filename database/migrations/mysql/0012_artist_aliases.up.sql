@@ -0,0 +1,16 @@
+-- normalized_name is names.Normalize(name), filled in by the API after migrating since SQL can
+-- not compute it.
+ALTER TABLE artists ADD COLUMN normalized_name VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX artists_normalized_name ON artists (normalized_name);
+
+-- Other names an artist goes by. Times are unix seconds.
+CREATE TABLE IF NOT EXISTS artist_aliases (
+    id INT NOT NULL AUTO_INCREMENT,
+    artist_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    normalized_name VARCHAR(255) NOT NULL,
+    created_at BIGINT NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY artist_aliases_normalized_name (normalized_name),
+    CONSTRAINT artist_aliases_artist_fk FOREIGN KEY (artist_id) REFERENCES artists (id)
+);
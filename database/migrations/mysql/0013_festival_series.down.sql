@@ -0,0 +1,6 @@
+ALTER TABLE locations DROP FOREIGN KEY locations_festival_fk;
+ALTER TABLE locations DROP COLUMN venue;
+ALTER TABLE locations DROP COLUMN end_date;
+ALTER TABLE locations DROP COLUMN start_date;
+ALTER TABLE locations DROP COLUMN festival_id;
+DROP TABLE IF EXISTS festivals;
@@ -0,0 +1,16 @@
+-- A festival series, like Bonnaroo, whose yearly editions are festival locations. Existing
+-- festival locations are added to a series by the API after migrating, see linkFestivalEditions.
+CREATE TABLE IF NOT EXISTS festivals (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY festivals_name (name)
+);
+
+-- start_date and end_date are YYYY-MM-DD, empty when not known.
+ALTER TABLE locations ADD COLUMN festival_id INT NULL;
+ALTER TABLE locations ADD COLUMN start_date VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE locations ADD COLUMN end_date VARCHAR(10) NOT NULL DEFAULT '';
+ALTER TABLE locations ADD COLUMN venue VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE locations ADD CONSTRAINT locations_festival_fk FOREIGN KEY (festival_id) REFERENCES festivals (id);
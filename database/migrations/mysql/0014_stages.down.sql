@@ -0,0 +1,3 @@
+ALTER TABLE sets DROP FOREIGN KEY sets_stage_fk;
+ALTER TABLE sets DROP COLUMN stage_id;
+DROP TABLE IF EXISTS stages;
@@ -0,0 +1,12 @@
+-- Stages of a venue or festival edition. A set may say which stage it was on.
+CREATE TABLE IF NOT EXISTS stages (
+    id INT NOT NULL AUTO_INCREMENT,
+    location_id INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY stages_location_name (location_id, name),
+    CONSTRAINT stages_location_fk FOREIGN KEY (location_id) REFERENCES locations (id)
+);
+
+ALTER TABLE sets ADD COLUMN stage_id INT NULL;
+ALTER TABLE sets ADD CONSTRAINT sets_stage_fk FOREIGN KEY (stage_id) REFERENCES stages (id);
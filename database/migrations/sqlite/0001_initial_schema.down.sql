@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS sets;
+DROP TABLE IF EXISTS locations;
+DROP TABLE IF EXISTS artists;
+DROP TABLE IF EXISTS users;
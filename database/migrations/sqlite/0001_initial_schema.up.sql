@@ -0,0 +1,41 @@
+-- Text columns use NOCASE so uniqueness checks match MySQL's case-insensitive collation.
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL COLLATE NOCASE UNIQUE,
+    email TEXT NOT NULL COLLATE NOCASE UNIQUE,
+    password TEXT NOT NULL,
+    first_name TEXT NULL,
+    last_name TEXT NULL,
+    role TEXT NOT NULL DEFAULT 'USER'
+);
+
+CREATE TABLE IF NOT EXISTS artists (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL COLLATE NOCASE,
+    default_genre TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS locations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL COLLATE NOCASE,
+    description TEXT NULL,
+    city TEXT NULL COLLATE NOCASE,
+    state TEXT NULL COLLATE NOCASE,
+    country TEXT NULL COLLATE NOCASE,
+    is_festival BOOLEAN NOT NULL DEFAULT FALSE,
+    year INTEGER NULL
+);
+
+-- date is stored as YYYY-MM-DD text so festival sets without a known day can leave it empty.
+CREATE TABLE IF NOT EXISTS sets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id),
+    artist_id INTEGER NOT NULL REFERENCES artists (id),
+    location_id INTEGER NOT NULL REFERENCES locations (id),
+    date TEXT NOT NULL DEFAULT '',
+    rating INTEGER NOT NULL DEFAULT 0,
+    genre TEXT NOT NULL DEFAULT '',
+    length INTEGER NOT NULL DEFAULT 0,
+    notes TEXT NOT NULL DEFAULT '',
+    UNIQUE (user_id, artist_id, location_id, date)
+);
@@ -0,0 +1,9 @@
+-- Audit trail of every change to a user's role.
+CREATE TABLE IF NOT EXISTS role_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id),
+    changed_by INTEGER NOT NULL REFERENCES users (id),
+    old_role TEXT NOT NULL,
+    new_role TEXT NOT NULL,
+    changed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,17 @@
+-- One row per refresh token. Rotating a token revokes its row and adds a new one to the same
+-- family, so a revoked token coming back means it was stolen and the whole family is revoked.
+-- Times are unix seconds.
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id),
+    family_id TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    last_used_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    revoked_at INTEGER NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_family ON sessions (family_id);
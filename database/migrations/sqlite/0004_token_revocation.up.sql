@@ -0,0 +1,9 @@
+-- Sessions remember the access token issued with them so revoking a session can also revoke
+-- that token through revoked_tokens, which GetUserInfo checks on every request.
+ALTER TABLE sessions ADD COLUMN access_token_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN access_expires_at INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_id TEXT NOT NULL PRIMARY KEY,
+    expires_at INTEGER NOT NULL
+);
@@ -0,0 +1,24 @@
+-- TOTP secrets, a row without enabled_at is an enrollment waiting to be confirmed. last_used_step
+-- is the last accepted 30 second time step, so a code can not be replayed. Times are unix seconds.
+CREATE TABLE IF NOT EXISTS two_factor (
+    user_id INTEGER PRIMARY KEY REFERENCES users (id),
+    secret TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    enabled_at INTEGER NULL,
+    last_used_step INTEGER NOT NULL DEFAULT 0
+);
+
+-- One time recovery codes, only a hash of each is kept.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id),
+    code_hash TEXT NOT NULL,
+    used_at INTEGER NULL
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_user ON recovery_codes (user_id);
+
+-- Instance wide settings changed through the API.
+CREATE TABLE IF NOT EXISTS settings (
+    name TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
@@ -0,0 +1,7 @@
+-- Failed sign in attempts, keyed by "username:<name>" or "ip:<address>". Times are unix seconds.
+CREATE TABLE IF NOT EXISTS login_throttles (
+    throttle_key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at INTEGER NOT NULL,
+    locked_until INTEGER NOT NULL DEFAULT 0
+);
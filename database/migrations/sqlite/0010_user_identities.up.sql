@@ -0,0 +1,12 @@
+-- Links users to accounts at an OpenID Connect provider, by the provider's issuer and subject.
+-- Times are unix seconds.
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id),
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    last_login_at INTEGER NOT NULL,
+    UNIQUE (issuer, subject)
+);
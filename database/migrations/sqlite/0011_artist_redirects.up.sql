@@ -0,0 +1,9 @@
+-- Artists that were merged into another one, so their old ids keep resolving. Times are unix
+-- seconds.
+CREATE TABLE IF NOT EXISTS artist_redirects (
+    from_id INTEGER PRIMARY KEY,
+    to_id INTEGER NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS artist_redirects_to_id ON artist_redirects (to_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS artist_aliases;
+DROP INDEX IF EXISTS artists_normalized_name;
+ALTER TABLE artists DROP COLUMN normalized_name;
@@ -0,0 +1,13 @@
+-- normalized_name is names.Normalize(name), filled in by the API after migrating since SQL can
+-- not compute it.
+ALTER TABLE artists ADD COLUMN normalized_name TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS artists_normalized_name ON artists (normalized_name);
+
+-- Other names an artist goes by. Times are unix seconds.
+CREATE TABLE IF NOT EXISTS artist_aliases (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    artist_id INTEGER NOT NULL REFERENCES artists (id),
+    name TEXT NOT NULL,
+    normalized_name TEXT NOT NULL UNIQUE,
+    created_at INTEGER NOT NULL
+);
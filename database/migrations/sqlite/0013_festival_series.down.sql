@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS locations_festival_id;
+ALTER TABLE locations DROP COLUMN venue;
+ALTER TABLE locations DROP COLUMN end_date;
+ALTER TABLE locations DROP COLUMN start_date;
+ALTER TABLE locations DROP COLUMN festival_id;
+DROP TABLE IF EXISTS festivals;
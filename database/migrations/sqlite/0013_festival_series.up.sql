@@ -0,0 +1,14 @@
+-- A festival series, like Bonnaroo, whose yearly editions are festival locations. Existing
+-- festival locations are added to a series by the API after migrating, see linkFestivalEditions.
+CREATE TABLE IF NOT EXISTS festivals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL COLLATE NOCASE UNIQUE,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+-- start_date and end_date are YYYY-MM-DD, empty when not known.
+ALTER TABLE locations ADD COLUMN festival_id INTEGER NULL REFERENCES festivals (id);
+ALTER TABLE locations ADD COLUMN start_date TEXT NOT NULL DEFAULT '';
+ALTER TABLE locations ADD COLUMN end_date TEXT NOT NULL DEFAULT '';
+ALTER TABLE locations ADD COLUMN venue TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS locations_festival_id ON locations (festival_id);
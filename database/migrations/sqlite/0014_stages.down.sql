@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS sets_stage_id;
+ALTER TABLE sets DROP COLUMN stage_id;
+DROP TABLE IF EXISTS stages;
@@ -0,0 +1,10 @@
+-- Stages of a venue or festival edition. A set may say which stage it was on.
+CREATE TABLE IF NOT EXISTS stages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    location_id INTEGER NOT NULL REFERENCES locations (id),
+    name TEXT NOT NULL COLLATE NOCASE,
+    UNIQUE (location_id, name)
+);
+
+ALTER TABLE sets ADD COLUMN stage_id INTEGER NULL REFERENCES stages (id);
+CREATE INDEX IF NOT EXISTS sets_stage_id ON sets (stage_id);
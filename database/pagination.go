@@ -0,0 +1,278 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_PAGE_LIMIT = 50
+const MAX_PAGE_LIMIT = 200
+
+// Sortable fields for each list, mapped to the SQL expression they sort on.
+var ARTIST_SORTS = map[string]string{"id": "id", "name": "name"}
+var LOCATION_SORTS = map[string]string{"id": "id", "name": "name", "year": "COALESCE(year, 0)"}
+var FESTIVAL_SORTS = map[string]string{"id": "id", "name": "name"}
+var USER_SORTS = map[string]string{"id": "id", "username": "username"}
+var SET_SORTS = map[string]string{"id": "sets.id", "date": "sets.date", "rating": "sets.rating", "artist": "artists.name"}
+
+// numericSorts are the sort fields with whole number values, the others sort text.
+var numericSorts = map[string]bool{"id": true, "year": true, "rating": true}
+
+type sortOrder struct {
+	field      string
+	column     string
+	descending bool
+}
+
+// pageCursor marks the last row of a page by its sort value and id, so the next page can
+// continue after it even when rows are added or removed in between.
+type pageCursor struct {
+	Value interface{} `json:"v"`
+	Id    int         `json:"id"`
+}
+
+type pageQuery struct {
+	order  sortOrder
+	cursor *pageCursor
+	limit  int
+}
+
+func parsePageRequest(page types.PageRequest, sorts map[string]string) (pageQuery, types.Error) {
+	query := pageQuery{limit: page.Limit}
+	if query.limit <= 0 {
+		query.limit = DEFAULT_PAGE_LIMIT
+	}
+	if query.limit > MAX_PAGE_LIMIT {
+		query.limit = MAX_PAGE_LIMIT
+	}
+
+	field := page.Sort
+	if field == "" {
+		field = "id"
+	}
+	if strings.HasPrefix(field, "-") {
+		query.order.descending = true
+		field = field[1:]
+	}
+
+	column, ok := sorts[field]
+	if !ok {
+		return query, customerrors.New(http.StatusBadRequest, fmt.Sprintf("cannot sort by %s", field))
+	}
+	query.order.field = field
+	query.order.column = column
+
+	if page.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+		if err != nil {
+			return query, customerrors.New(http.StatusBadRequest, "invalid cursor")
+		}
+
+		query.cursor = &pageCursor{}
+		err = json.Unmarshal(raw, query.cursor)
+		if err != nil {
+			return query, customerrors.New(http.StatusBadRequest, "invalid cursor")
+		}
+
+		// The value is passed on as a query argument, so it must be what the field sorts on.
+		value, ok := cursorValue(query.cursor.Value, numericSorts[field])
+		if !ok {
+			return query, customerrors.New(http.StatusBadRequest, "invalid cursor")
+		}
+		query.cursor.Value = value
+	}
+
+	return query, nil
+}
+
+// cursorValue checks a decoded cursor value is a whole number for numeric sorts or a string
+// otherwise, returning it as an int64 or string.
+func cursorValue(value interface{}, numeric bool) (interface{}, bool) {
+	switch v := value.(type) {
+	case float64:
+		if !numeric || v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+			return nil, false
+		}
+		return int64(v), true
+	case string:
+		return v, !numeric
+	default:
+		return nil, false
+	}
+}
+
+func encodeCursor(value interface{}, id int) string {
+	raw, _ := json.Marshal(pageCursor{value, id})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// keysetCondition returns the SQL condition selecting rows after the cursor.
+func (q pageQuery) keysetCondition(idColumn string) (string, []interface{}) {
+	operator := ">"
+	if q.order.descending {
+		operator = "<"
+	}
+
+	condition := fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", q.order.column, operator, q.order.column, idColumn, operator)
+	return condition, []interface{}{q.cursor.Value, q.cursor.Value, q.cursor.Id}
+}
+
+func (q pageQuery) orderBy(idColumn string) string {
+	direction := "ASC"
+	if q.order.descending {
+		direction = "DESC"
+	}
+
+	return fmt.Sprintf(" ORDER BY %s %s, %s %s", q.order.column, direction, idColumn, direction)
+}
+
+// info builds the paging info for a page fetched with one extra row, returning how many of
+// the fetched rows belong on this page.
+func (q pageQuery) info(total int, fetched int, key func(i int) (interface{}, int)) (types.PageInfo, int) {
+	info := types.PageInfo{Total: total}
+	if fetched <= q.limit {
+		return info, fetched
+	}
+
+	value, id := key(q.limit - 1)
+	info.NextCursor = encodeCursor(value, id)
+	return info, q.limit
+}
+
+// pageIndexes sorts n items by their (sort value, id) key and returns the indexes of the
+// requested page along with the paging info. It is the in-memory equivalent of the
+// keyset queries built by the SQL store.
+func (q pageQuery) pageIndexes(n int, key func(i int) (interface{}, int)) ([]int, types.PageInfo) {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	compare := func(a interface{}, aId int, b interface{}, bId int) int {
+		result := compareValues(a, b)
+		if result == 0 {
+			result = aId - bId
+		}
+		if q.order.descending {
+			result = -result
+		}
+		return result
+	}
+
+	sort.SliceStable(indexes, func(i, j int) bool {
+		a, aId := key(indexes[i])
+		b, bId := key(indexes[j])
+		return compare(a, aId, b, bId) < 0
+	})
+
+	start := 0
+	if q.cursor != nil {
+		for start < len(indexes) {
+			value, id := key(indexes[start])
+			if compare(value, id, q.cursor.Value, q.cursor.Id) > 0 {
+				break
+			}
+			start++
+		}
+	}
+
+	info := types.PageInfo{Total: n}
+	end := start + q.limit
+	if end < len(indexes) {
+		value, id := key(indexes[end-1])
+		info.NextCursor = encodeCursor(value, id)
+	} else {
+		end = len(indexes)
+	}
+
+	return indexes[start:end], info
+}
+
+// compareValues orders two sort values the way the databases do: numbers numerically and
+// text case-insensitively.
+func compareValues(a interface{}, b interface{}) int {
+	aNumber, aIsNumber := toFloat(a)
+	bNumber, bIsNumber := toFloat(b)
+	if aIsNumber && bIsNumber {
+		switch {
+		case aNumber < bNumber:
+			return -1
+		case aNumber > bNumber:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	return strings.Compare(strings.ToLower(fmt.Sprint(a)), strings.ToLower(fmt.Sprint(b)))
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func artistSortValue(artist types.Artist, field string) interface{} {
+	if field == "name" {
+		return artist.Name
+	}
+
+	return artist.Id
+}
+
+func locationSortValue(location types.Location, field string) interface{} {
+	switch field {
+	case "name":
+		return location.Name
+	case "year":
+		return location.Year
+	default:
+		return location.Id
+	}
+}
+
+func festivalSortValue(festival types.Festival, field string) interface{} {
+	if field == "name" {
+		return festival.Name
+	}
+
+	return festival.Id
+}
+
+func userSortValue(user types.User, field string) interface{} {
+	if field == "username" {
+		return user.Username
+	}
+
+	id, _ := strconv.Atoi(user.Id)
+	return id
+}
+
+func setSortValue(set types.Set, field string) interface{} {
+	switch field {
+	case "date":
+		return set.Date
+	case "rating":
+		return set.Metadata.Rating
+	case "artist":
+		return set.ArtistName
+	default:
+		return set.Id
+	}
+}
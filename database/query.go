@@ -0,0 +1,99 @@
+package database
+
+import (
+	"github.com/AnthonyNixon/setsisaw/types"
+	"strings"
+)
+
+// listQuery builds the SELECT and COUNT for a list endpoint from one of the statement pairs in
+// statements.go. Conditions only ever reference columns and ? placeholders, the values are
+// carried separately in args, so nothing a user sends is ever formatted into the SQL text.
+type listQuery struct {
+	selectQuery string
+	countQuery  string
+	idColumn    string
+	conditions  []string
+	args        []interface{}
+}
+
+func newListQuery(selectQuery string, countQuery string, idColumn string) *listQuery {
+	return &listQuery{
+		selectQuery: selectQuery,
+		countQuery:  countQuery,
+		idColumn:    idColumn,
+	}
+}
+
+// where adds a condition, every ? in it must have a matching value in args.
+func (q *listQuery) where(condition string, args ...interface{}) *listQuery {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, args...)
+	return q
+}
+
+// count returns the query counting every row that matches the conditions.
+func (q *listQuery) count() (string, []interface{}) {
+	return q.countQuery + whereClause(q.conditions), q.args
+}
+
+// page returns the query for one page of rows, fetching one extra row so the caller can
+// tell whether there is a next page.
+func (q *listQuery) page(page pageQuery) (string, []interface{}) {
+	conditions := append([]string{}, q.conditions...)
+	args := append([]interface{}{}, q.args...)
+
+	if page.cursor != nil {
+		condition, cursorArgs := page.keysetCondition(q.idColumn)
+		conditions = append(conditions, condition)
+		args = append(args, cursorArgs...)
+	}
+
+	args = append(args, page.limit+1)
+	return q.selectQuery + whereClause(conditions) + page.orderBy(q.idColumn) + " LIMIT ?", args
+}
+
+// setListQuery turns a SetFilter into the set listing query, adding one condition per filter that is set.
+func setListQuery(filter types.SetFilter) *listQuery {
+	query := newListQuery(SELECT_SETS, COUNT_SETS, "sets.id")
+
+	if filter.UserId != 0 {
+		query.where("sets.user_id = ?", filter.UserId)
+	}
+	if filter.ArtistId != 0 {
+		query.where("sets.artist_id = ?", filter.ArtistId)
+	}
+	if filter.LocationId != 0 {
+		query.where("sets.location_id = ?", filter.LocationId)
+	}
+	if filter.FestivalId != 0 {
+		query.where("locations.festival_id = ?", filter.FestivalId)
+	}
+	if filter.StageId != 0 {
+		query.where("sets.stage_id = ?", filter.StageId)
+	}
+	if filter.Stage != "" {
+		query.where("stages.name = ?", filter.Stage)
+	}
+	if filter.Genre != "" {
+		query.where("sets.genre = ?", filter.Genre)
+	}
+	if filter.MinRating != 0 {
+		query.where("sets.rating >= ?", filter.MinRating)
+	}
+	if filter.From != "" {
+		query.where("sets.date >= ?", filter.From)
+	}
+	if filter.To != "" {
+		query.where("sets.date <= ?", filter.To)
+	}
+
+	return query
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
@@ -0,0 +1,1517 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/names"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLStore is the Store backed by a SQL database, either MySQL or SQLite.
+type SQLStore struct {
+	DB     *sql.DB
+	Driver string
+}
+
+func NewSQLStore(db *sql.DB, driver string) *SQLStore {
+	return &SQLStore{DB: db, Driver: driver}
+}
+
+// Users
+
+func (s *SQLStore) IsUserUnique(ctx context.Context, username string, email string) (bool, types.Error) {
+	return s.isUnique(ctx, IS_USER_UNIQUE_QUERY, username, email)
+}
+
+func (s *SQLStore) IsUserUpdateUnique(ctx context.Context, user types.User) (bool, types.Error) {
+	return s.isUnique(ctx, IS_USER_UPDATE_UNIQUE, user.Id, user.Username, user.Email)
+}
+
+func (s *SQLStore) CreateUser(ctx context.Context, user types.User) (int, types.Error) {
+	return s.insert(ctx, INSERT_NEW_USER, user.Username, user.Email, user.Password, user.FirstName, user.LastName)
+}
+
+func (s *SQLStore) GetUser(ctx context.Context, id string) (types.User, types.Error) {
+	var user types.User
+	err := s.DB.QueryRowContext(ctx, GET_SPECIFIC_USER, id).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Role, &user.EmailVerified)
+	if err != nil {
+		return user, notFoundOr(err, "user not found")
+	}
+
+	return user, nil
+}
+
+func (s *SQLStore) GetUserByUsername(ctx context.Context, username string) (types.User, types.Error) {
+	var user types.User
+	err := s.DB.QueryRowContext(ctx, GET_USER_BY_USERNAME, username).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Role, &user.EmailVerified, &user.Password)
+	if err != nil {
+		return user, notFoundOr(err, "user not found")
+	}
+
+	return user, nil
+}
+
+func (s *SQLStore) GetUserByEmail(ctx context.Context, email string) (types.User, types.Error) {
+	var user types.User
+	err := s.DB.QueryRowContext(ctx, GET_USER_BY_EMAIL, email).Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Role, &user.EmailVerified)
+	if err != nil {
+		return user, notFoundOr(err, "user not found")
+	}
+
+	return user, nil
+}
+
+func (s *SQLStore) GetAllUsers(ctx context.Context, page types.PageRequest) ([]types.User, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, USER_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	user := types.User{}
+	users := make([]types.User, 0)
+	total, customErr := s.listPage(ctx, newListQuery(SELECT_USERS, COUNT_USERS, "id"), query, func(rows *sql.Rows) error {
+		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Role, &user.EmailVerified)
+		users = append(users, user)
+		return err
+	})
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	info, keep := query.info(total, len(users), func(i int) (interface{}, int) {
+		id, _ := strconv.Atoi(users[i].Id)
+		return userSortValue(users[i], query.order.field), id
+	})
+	return users[:keep], info, nil
+}
+
+func (s *SQLStore) UpdateUser(ctx context.Context, user types.User) types.Error {
+	return s.exec(ctx, UPDATE_USER, user.Username, user.Email, user.FirstName, user.LastName, user.EmailVerified, user.Id)
+}
+
+func (s *SQLStore) ChangeUserRole(ctx context.Context, change types.RoleChange) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		now := time.Now().Unix()
+		customErr := txExec(ctx, tx, UPDATE_USER_ROLE, change.NewRole, change.UserId)
+		if customErr != nil {
+			return customErr
+		}
+
+		customErr = txExec(ctx, tx, INSERT_ROLE_CHANGE, change.UserId, change.ChangedBy, change.OldRole, change.NewRole)
+		if customErr != nil {
+			return customErr
+		}
+
+		customErr = txExec(ctx, tx, REVOKE_USER_API_TOKENS, now, change.UserId)
+		if customErr != nil {
+			return customErr
+		}
+
+		return txRevokeSessions(ctx, tx, REVOKE_USER_ACCESS_TOKENS, REVOKE_USER_SESSIONS, now, change.UserId)
+	})
+}
+
+func (s *SQLStore) UpdatePassword(ctx context.Context, userId int, passwordHash string) types.Error {
+	return s.exec(ctx, UPDATE_USER_PASSWORD, passwordHash, userId)
+}
+
+func (s *SQLStore) ChangePassword(ctx context.Context, userId int, passwordHash string, keepFamilyId string) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		now := time.Now().Unix()
+		customErr := txExec(ctx, tx, UPDATE_USER_PASSWORD, passwordHash, userId)
+		if customErr != nil {
+			return customErr
+		}
+
+		customErr = txExec(ctx, tx, REVOKE_USER_API_TOKENS, now, userId)
+		if customErr != nil {
+			return customErr
+		}
+
+		return txRevokeSessions(ctx, tx, REVOKE_OTHER_USER_ACCESS_TOKENS, REVOKE_OTHER_USER_SESSIONS, now, userId, keepFamilyId)
+	})
+}
+
+// Artists
+
+func (s *SQLStore) IsArtistUnique(ctx context.Context, name string) (bool, types.Error) {
+	normalized := names.Normalize(name)
+	return s.isUnique(ctx, IS_ARTIST_UNIQUE_QUERY, normalized, normalized)
+}
+
+func (s *SQLStore) CreateArtist(ctx context.Context, artist types.Artist) (int, types.Error) {
+	return s.insert(ctx, INSERT_NEW_ARTIST, artist.Name, names.Normalize(artist.Name), artist.DefaultGenre)
+}
+
+func (s *SQLStore) GetArtist(ctx context.Context, id string) (types.Artist, types.Error) {
+	artist, err := scanArtist(s.DB.QueryRowContext(ctx, GET_SPECIFIC_ARTIST, id))
+	if err != nil {
+		return artist, notFoundOr(err, "artist not found")
+	}
+
+	return artist, nil
+}
+
+func (s *SQLStore) GetAllArtists(ctx context.Context, page types.PageRequest) ([]types.Artist, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, ARTIST_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	artist := types.Artist{}
+	artists := make([]types.Artist, 0)
+	total, customErr := s.listPage(ctx, newListQuery(SELECT_ARTISTS, COUNT_ARTISTS, "id"), query, func(rows *sql.Rows) error {
+		err := rows.Scan(&artist.Id, &artist.Name, &artist.DefaultGenre)
+		artists = append(artists, artist)
+		return err
+	})
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	info, keep := query.info(total, len(artists), func(i int) (interface{}, int) {
+		return artistSortValue(artists[i], query.order.field), artists[i].Id
+	})
+	return artists[:keep], info, nil
+}
+
+func (s *SQLStore) GetArtistDefaultGenre(ctx context.Context, name string, id int) (string, types.Error) {
+	var genre string
+	normalized := names.Normalize(name)
+	err := s.DB.QueryRowContext(ctx, GET_ARTIST_DEFAULT_GENRE, id, normalized, normalized, id).Scan(&genre)
+	if err != nil {
+		return "", notFoundOr(err, "artist not found")
+	}
+
+	return genre, nil
+}
+
+func (s *SQLStore) IsArtistUpdateUnique(ctx context.Context, artist types.Artist) (bool, types.Error) {
+	normalized := names.Normalize(artist.Name)
+	return s.isUnique(ctx, IS_ARTIST_UPDATE_UNIQUE, artist.Id, normalized, artist.Id, normalized)
+}
+
+func (s *SQLStore) UpdateArtist(ctx context.Context, artist types.Artist) types.Error {
+	return s.exec(ctx, UPDATE_ARTIST, artist.Name, names.Normalize(artist.Name), artist.DefaultGenre, artist.Id)
+}
+
+func (s *SQLStore) DeleteArtist(ctx context.Context, id int, cascade bool) (int, types.Error) {
+	var deleted int
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		var sets int
+		err := tx.QueryRowContext(ctx, COUNT_ARTIST_SETS, id).Scan(&sets)
+		if err != nil {
+			return customerrors.New(http.StatusInternalServerError, "could not count sets, "+err.Error())
+		}
+
+		if sets > 0 && !cascade {
+			return customerrors.New(http.StatusConflict, fmt.Sprintf("artist has %d sets, merge it into another artist or delete them too with cascade=true", sets))
+		}
+
+		removed, customErr := txExecCount(ctx, tx, DELETE_ARTIST_SETS, id)
+		if customErr != nil {
+			return customErr
+		}
+		deleted = int(removed)
+
+		customErr = txExec(ctx, tx, DELETE_ARTIST_REDIRECTS, id)
+		if customErr != nil {
+			return customErr
+		}
+
+		customErr = txExec(ctx, tx, DELETE_ARTIST_ALIASES, id)
+		if customErr != nil {
+			return customErr
+		}
+
+		return txDeleteArtist(ctx, tx, id)
+	})
+
+	return deleted, customErr
+}
+
+func (s *SQLStore) MergeArtists(ctx context.Context, artistId int, duplicateIds []int) (types.ArtistMerge, types.Error) {
+	merge := types.ArtistMerge{ArtistId: artistId, MergedArtistIds: duplicateIds}
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		_, err := scanArtist(tx.QueryRowContext(ctx, GET_SPECIFIC_ARTIST, artistId))
+		if err != nil {
+			return notFoundOr(err, "artist not found")
+		}
+
+		now := time.Now().Unix()
+		for _, duplicateId := range duplicateIds {
+			removed, customErr := txExecCount(ctx, tx, DELETE_MERGED_DUPLICATE_SETS, duplicateId, artistId)
+			if customErr != nil {
+				return customErr
+			}
+
+			moved, customErr := txExecCount(ctx, tx, MOVE_ARTIST_SETS, artistId, duplicateId)
+			if customErr != nil {
+				return customErr
+			}
+
+			customErr = txExec(ctx, tx, MOVE_ARTIST_REDIRECTS, artistId, duplicateId)
+			if customErr != nil {
+				return customErr
+			}
+
+			customErr = txExec(ctx, tx, MOVE_ARTIST_ALIASES, artistId, duplicateId)
+			if customErr != nil {
+				return customErr
+			}
+
+			customErr = txExec(ctx, tx, ALIAS_MERGED_ARTIST, artistId, now, duplicateId, artistId)
+			if customErr != nil {
+				return customErr
+			}
+
+			customErr = txDeleteArtist(ctx, tx, duplicateId)
+			if customErr != nil {
+				return customErr
+			}
+
+			customErr = txExec(ctx, tx, INSERT_ARTIST_REDIRECT, duplicateId, artistId, now)
+			if customErr != nil {
+				return customErr
+			}
+
+			merge.DuplicateSetsRemoved += int(removed)
+			merge.SetsMoved += int(moved)
+		}
+
+		return nil
+	})
+
+	return merge, customErr
+}
+
+func (s *SQLStore) GetArtistRedirect(ctx context.Context, id string) (int, types.Error) {
+	var artistId int
+	err := s.DB.QueryRowContext(ctx, GET_ARTIST_REDIRECT, id).Scan(&artistId)
+	if err != nil {
+		return 0, notFoundOr(err, "artist not found")
+	}
+
+	return artistId, nil
+}
+
+func (s *SQLStore) GetArtistNames(ctx context.Context) ([]types.ArtistName, types.Error) {
+	rows, err := s.DB.QueryContext(ctx, SELECT_ARTIST_NAMES)
+	if err != nil {
+		return nil, customerrors.New(http.StatusInternalServerError, "could not query database, "+err.Error())
+	}
+	defer rows.Close()
+
+	artistNames := make([]types.ArtistName, 0)
+	for rows.Next() {
+		var name types.ArtistName
+		err := rows.Scan(&name.Artist.Id, &name.Artist.Name, &name.Artist.DefaultGenre, &name.Name, &name.NormalizedName)
+		if err != nil {
+			return nil, customerrors.New(http.StatusInternalServerError, "could not scan row, "+err.Error())
+		}
+		artistNames = append(artistNames, name)
+	}
+
+	return artistNames, nil
+}
+
+func (s *SQLStore) GetArtistAliases(ctx context.Context, artistId int) ([]types.ArtistAlias, types.Error) {
+	rows, err := s.DB.QueryContext(ctx, GET_ARTIST_ALIASES, artistId)
+	if err != nil {
+		return nil, customerrors.New(http.StatusInternalServerError, "could not query database, "+err.Error())
+	}
+	defer rows.Close()
+
+	aliases := make([]types.ArtistAlias, 0)
+	for rows.Next() {
+		var alias types.ArtistAlias
+		err := rows.Scan(&alias.Id, &alias.ArtistId, &alias.Name, &alias.CreatedAt)
+		if err != nil {
+			return nil, customerrors.New(http.StatusInternalServerError, "could not scan row, "+err.Error())
+		}
+		aliases = append(aliases, alias)
+	}
+
+	return aliases, nil
+}
+
+// CreateArtistAlias returns a 409 if an artist or alias already has the same normalized name.
+func (s *SQLStore) CreateArtistAlias(ctx context.Context, alias types.ArtistAlias) (int, types.Error) {
+	normalized := names.Normalize(alias.Name)
+	unique, customErr := s.isUnique(ctx, IS_ARTIST_UNIQUE_QUERY, normalized, normalized)
+	if customErr != nil {
+		return 0, customErr
+	}
+
+	if !unique {
+		return 0, customerrors.New(http.StatusConflict, "an artist is already known by that name")
+	}
+
+	return s.insert(ctx, INSERT_ARTIST_ALIAS, alias.ArtistId, alias.Name, normalized, alias.CreatedAt)
+}
+
+func (s *SQLStore) DeleteArtistAlias(ctx context.Context, artistId int, aliasId int) types.Error {
+	result, err := s.DB.ExecContext(ctx, DELETE_ARTIST_ALIAS, aliasId, artistId)
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, "could not delete alias, "+err.Error())
+	}
+
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return customerrors.New(http.StatusNotFound, "alias not found")
+	}
+
+	return nil
+}
+
+// normalizeArtistNames fills in normalized_name for artists created before it existed.
+func (s *SQLStore) normalizeArtistNames(ctx context.Context) error {
+	rows, err := s.DB.QueryContext(ctx, GET_UNNORMALIZED_ARTISTS)
+	if err != nil {
+		return err
+	}
+
+	artists := make([]types.Artist, 0)
+	for rows.Next() {
+		var artist types.Artist
+		err := rows.Scan(&artist.Id, &artist.Name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		artists = append(artists, artist)
+	}
+	rows.Close()
+
+	for _, artist := range artists {
+		_, err := s.DB.ExecContext(ctx, SET_ARTIST_NORMALIZED_NAME, names.Normalize(artist.Name), artist.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// txDeleteArtist returns a 404 if there is no artist id.
+func txDeleteArtist(ctx context.Context, tx *sql.Tx, id int) types.Error {
+	deleted, customErr := txExecCount(ctx, tx, DELETE_ARTIST, id)
+	if customErr == nil && deleted == 0 {
+		return customerrors.New(http.StatusNotFound, fmt.Sprintf("artist %d not found", id))
+	}
+
+	return customErr
+}
+
+func scanArtist(row scanner) (types.Artist, error) {
+	var artist types.Artist
+	err := row.Scan(&artist.Id, &artist.Name, &artist.DefaultGenre)
+	return artist, err
+}
+
+// Locations
+
+func (s *SQLStore) IsLocationUnique(ctx context.Context, location types.Location) (bool, types.Error) {
+	return s.isUnique(ctx, IS_LOCATION_UNIQUE_QUERY, location.Name, location.City, location.State, location.Country, location.Year)
+}
+
+func (s *SQLStore) IsLocationUpdateUnique(ctx context.Context, location types.Location) (bool, types.Error) {
+	return s.isUnique(ctx, IS_LOCATION_UPDATE_UNIQUE, location.Id, location.Name, location.City, location.State, location.Country, location.Year)
+}
+
+func (s *SQLStore) CreateLocation(ctx context.Context, location types.Location) (int, types.Error) {
+	return s.insert(ctx, INSERT_NEW_LOCATION, location.Name, location.Description, location.City, location.State, location.Country, location.IsFestival, location.Year,
+		location.FestivalId, location.StartDate, location.EndDate, location.Venue)
+}
+
+func (s *SQLStore) GetLocation(ctx context.Context, id string) (types.Location, types.Error) {
+	location, err := scanLocation(s.DB.QueryRowContext(ctx, GET_SPECIFIC_LOCATION, id))
+	if err != nil {
+		return location, notFoundOr(err, "location not found")
+	}
+
+	return location, nil
+}
+
+func (s *SQLStore) GetAllLocations(ctx context.Context, page types.PageRequest) ([]types.Location, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, LOCATION_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	locations := make([]types.Location, 0)
+	total, customErr := s.listPage(ctx, newListQuery(SELECT_LOCATIONS, COUNT_LOCATIONS, "id"), query, func(rows *sql.Rows) error {
+		location, err := scanLocation(rows)
+		locations = append(locations, location)
+		return err
+	})
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	info, keep := query.info(total, len(locations), func(i int) (interface{}, int) {
+		return locationSortValue(locations[i], query.order.field), locations[i].Id
+	})
+	return locations[:keep], info, nil
+}
+
+func (s *SQLStore) UpdateLocation(ctx context.Context, location types.Location) types.Error {
+	return s.exec(ctx, UPDATE_LOCATION, location.Name, location.Description, location.City, location.State, location.Country, location.IsFestival, location.Year,
+		location.FestivalId, location.StartDate, location.EndDate, location.Venue, location.Id)
+}
+
+func (s *SQLStore) DeleteLocation(ctx context.Context, id int, cascade bool) (int, types.Error) {
+	var deleted int
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		var sets int
+		err := tx.QueryRowContext(ctx, COUNT_LOCATION_SETS, id).Scan(&sets)
+		if err != nil {
+			return customerrors.New(http.StatusInternalServerError, "could not count sets, "+err.Error())
+		}
+
+		if sets > 0 && !cascade {
+			return customerrors.New(http.StatusConflict, fmt.Sprintf("location has %d sets, merge it into another location or delete them too with cascade=true", sets))
+		}
+
+		removed, customErr := txExecCount(ctx, tx, DELETE_LOCATION_SETS, id)
+		if customErr != nil {
+			return customErr
+		}
+		deleted = int(removed)
+
+		customErr = txExec(ctx, tx, DELETE_LOCATION_STAGES, id)
+		if customErr != nil {
+			return customErr
+		}
+
+		return txDeleteLocation(ctx, tx, id)
+	})
+
+	return deleted, customErr
+}
+
+func (s *SQLStore) MergeLocations(ctx context.Context, locationId int, duplicateIds []int) (types.LocationMerge, types.Error) {
+	merge := types.LocationMerge{LocationId: locationId, MergedLocationIds: duplicateIds}
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		var isFestival bool
+		err := tx.QueryRowContext(ctx, GET_LOCATION_TYPE, locationId).Scan(&isFestival)
+		if err != nil {
+			return notFoundOr(err, "location not found")
+		}
+
+		// A festival edition and a venue are never the same place, whatever they are called.
+		for _, duplicateId := range duplicateIds {
+			var duplicateIsFestival bool
+			err := tx.QueryRowContext(ctx, GET_LOCATION_TYPE, duplicateId).Scan(&duplicateIsFestival)
+			if err != nil {
+				return notFoundOr(err, fmt.Sprintf("location %d not found", duplicateId))
+			}
+
+			if duplicateIsFestival != isFestival {
+				return customerrors.New(http.StatusBadRequest, fmt.Sprintf("location %d and location %d are not both festivals, they can not be merged", locationId, duplicateId))
+			}
+		}
+
+		for _, duplicateId := range duplicateIds {
+			removed, customErr := txExecCount(ctx, tx, DELETE_MERGED_DUPLICATE_LOCATION_SETS, duplicateId, locationId)
+			if customErr != nil {
+				return customErr
+			}
+
+			moved, customErr := txExecCount(ctx, tx, MOVE_LOCATION_SETS, locationId, duplicateId)
+			if customErr != nil {
+				return customErr
+			}
+
+			// Stages move along, those with the same name as one already at locationId are folded into it.
+			customErr = txExec(ctx, tx, MOVE_MERGED_STAGE_SETS, locationId, duplicateId, locationId)
+			if customErr != nil {
+				return customErr
+			}
+
+			customErr = txExec(ctx, tx, DELETE_MERGED_DUPLICATE_STAGES, duplicateId, locationId)
+			if customErr != nil {
+				return customErr
+			}
+
+			customErr = txExec(ctx, tx, MOVE_LOCATION_STAGES, locationId, duplicateId)
+			if customErr != nil {
+				return customErr
+			}
+
+			customErr = txDeleteLocation(ctx, tx, duplicateId)
+			if customErr != nil {
+				return customErr
+			}
+
+			merge.DuplicateSetsRemoved += int(removed)
+			merge.SetsMoved += int(moved)
+		}
+
+		return nil
+	})
+
+	return merge, customErr
+}
+
+// txDeleteLocation returns a 404 if there is no location id.
+func txDeleteLocation(ctx context.Context, tx *sql.Tx, id int) types.Error {
+	deleted, customErr := txExecCount(ctx, tx, DELETE_LOCATION, id)
+	if customErr == nil && deleted == 0 {
+		return customerrors.New(http.StatusNotFound, fmt.Sprintf("location %d not found", id))
+	}
+
+	return customErr
+}
+
+func (s *SQLStore) IsFestival(ctx context.Context, locationId int) (bool, types.Error) {
+	var isFestival bool
+	err := s.DB.QueryRowContext(ctx, GET_LOCATION_TYPE, locationId).Scan(&isFestival)
+	if err != nil {
+		return false, notFoundOr(err, "location not found")
+	}
+
+	return isFestival, nil
+}
+
+func scanLocation(row scanner) (types.Location, error) {
+	var location types.Location
+	err := row.Scan(&location.Id, &location.Name, &location.Description, &location.City, &location.State, &location.Country, &location.IsFestival, &location.Year,
+		&location.FestivalId, &location.StartDate, &location.EndDate, &location.Venue)
+	return location, err
+}
+
+// Festivals
+
+func (s *SQLStore) IsFestivalUnique(ctx context.Context, name string) (bool, types.Error) {
+	return s.isUnique(ctx, IS_FESTIVAL_UNIQUE_QUERY, name)
+}
+
+func (s *SQLStore) IsFestivalUpdateUnique(ctx context.Context, festival types.Festival) (bool, types.Error) {
+	return s.isUnique(ctx, IS_FESTIVAL_UPDATE_UNIQUE, festival.Id, festival.Name)
+}
+
+func (s *SQLStore) CreateFestival(ctx context.Context, festival types.Festival) (int, types.Error) {
+	return s.insert(ctx, INSERT_NEW_FESTIVAL, festival.Name, festival.Description)
+}
+
+func (s *SQLStore) GetFestival(ctx context.Context, id string) (types.Festival, types.Error) {
+	festival, err := scanFestival(s.DB.QueryRowContext(ctx, GET_SPECIFIC_FESTIVAL, id))
+	if err != nil {
+		return festival, notFoundOr(err, "festival not found")
+	}
+
+	return festival, nil
+}
+
+func (s *SQLStore) GetFestivalByName(ctx context.Context, name string) (types.Festival, types.Error) {
+	festival, err := scanFestival(s.DB.QueryRowContext(ctx, GET_FESTIVAL_BY_NAME, name))
+	if err != nil {
+		return festival, notFoundOr(err, "festival not found")
+	}
+
+	return festival, nil
+}
+
+func (s *SQLStore) GetAllFestivals(ctx context.Context, page types.PageRequest) ([]types.Festival, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, FESTIVAL_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	festivals := make([]types.Festival, 0)
+	total, customErr := s.listPage(ctx, newListQuery(SELECT_FESTIVALS, COUNT_FESTIVALS, "id"), query, func(rows *sql.Rows) error {
+		festival, err := scanFestival(rows)
+		festivals = append(festivals, festival)
+		return err
+	})
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	info, keep := query.info(total, len(festivals), func(i int) (interface{}, int) {
+		return festivalSortValue(festivals[i], query.order.field), festivals[i].Id
+	})
+	return festivals[:keep], info, nil
+}
+
+func (s *SQLStore) UpdateFestival(ctx context.Context, festival types.Festival) types.Error {
+	return s.exec(ctx, UPDATE_FESTIVAL, festival.Name, festival.Description, festival.Id)
+}
+
+func (s *SQLStore) GetFestivalEditions(ctx context.Context, festivalId int) ([]types.Location, types.Error) {
+	rows, err := s.DB.QueryContext(ctx, GET_FESTIVAL_EDITIONS, festivalId)
+	if err != nil {
+		return nil, customerrors.New(http.StatusInternalServerError, "could not query database, "+err.Error())
+	}
+	defer rows.Close()
+
+	editions := make([]types.Location, 0)
+	for rows.Next() {
+		edition, err := scanLocation(rows)
+		if err != nil {
+			return nil, customerrors.New(http.StatusInternalServerError, "could not scan row, "+err.Error())
+		}
+		editions = append(editions, edition)
+	}
+
+	return editions, nil
+}
+
+// linkFestivalEditions adds festival locations from before festival series existed to the series
+// named like them without their year, creating it if needed.
+func (s *SQLStore) linkFestivalEditions(ctx context.Context) error {
+	rows, err := s.DB.QueryContext(ctx, GET_UNLINKED_FESTIVAL_LOCATIONS)
+	if err != nil {
+		return err
+	}
+
+	locations := make([]types.Location, 0)
+	for rows.Next() {
+		var location types.Location
+		err := rows.Scan(&location.Id, &location.Name, &location.Year)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		locations = append(locations, location)
+	}
+	rows.Close()
+
+	for _, location := range locations {
+		name := names.FestivalSeries(location.Name, location.Year)
+		festival, customErr := s.GetFestivalByName(ctx, name)
+		if customErr != nil && customErr.StatusCode() != http.StatusNotFound {
+			return fmt.Errorf("%s", customErr.Description())
+		}
+
+		if customErr != nil {
+			festival.Id, customErr = s.CreateFestival(ctx, types.Festival{Name: name})
+			if customErr != nil {
+				return fmt.Errorf("%s", customErr.Description())
+			}
+		}
+
+		_, err := s.DB.ExecContext(ctx, SET_LOCATION_FESTIVAL, festival.Id, location.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func scanFestival(row scanner) (types.Festival, error) {
+	var festival types.Festival
+	err := row.Scan(&festival.Id, &festival.Name, &festival.Description)
+	return festival, err
+}
+
+// Sets
+
+func (s *SQLStore) IsSetUnique(ctx context.Context, set types.Set) (bool, types.Error) {
+	return s.isUnique(ctx, IS_SET_UNIQUE_QUERY, set.UserId, set.ArtistId, set.LocationId, set.Date)
+}
+
+func (s *SQLStore) IsSetUpdateUnique(ctx context.Context, set types.Set) (bool, types.Error) {
+	return s.isUnique(ctx, IS_SET_UPDATE_UNIQUE, set.Id, set.UserId, set.ArtistId, set.LocationId, set.Date)
+}
+
+func (s *SQLStore) CreateSet(ctx context.Context, set types.Set) (int, types.Error) {
+	return s.insert(ctx, INSERT_NEW_SET, set.UserId, set.ArtistId, set.LocationId, set.StageId, set.Date, set.Metadata.Rating, set.Metadata.Genre, set.Metadata.Length, set.Metadata.Notes)
+}
+
+func (s *SQLStore) GetSet(ctx context.Context, id string) (types.Set, types.Error) {
+	set, err := scanSet(s.DB.QueryRowContext(ctx, GET_SPECIFIC_SET, id))
+	if err != nil {
+		return set, notFoundOr(err, "set not found")
+	}
+
+	return set, nil
+}
+
+func (s *SQLStore) ListSets(ctx context.Context, filter types.SetFilter, page types.PageRequest) ([]types.Set, types.PageInfo, types.Error) {
+	query, customErr := parsePageRequest(page, SET_SORTS)
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	sets := make([]types.Set, 0)
+	total, customErr := s.listPage(ctx, setListQuery(filter), query, func(rows *sql.Rows) error {
+		set, err := scanSet(rows)
+		sets = append(sets, set)
+		return err
+	})
+	if customErr != nil {
+		return nil, types.PageInfo{}, customErr
+	}
+
+	info, keep := query.info(total, len(sets), func(i int) (interface{}, int) {
+		return setSortValue(sets[i], query.order.field), sets[i].Id
+	})
+	return sets[:keep], info, nil
+}
+
+func (s *SQLStore) UpdateSet(ctx context.Context, set types.Set) types.Error {
+	return s.exec(ctx, UPDATE_SET, set.ArtistId, set.LocationId, set.StageId, set.Date, set.Metadata.Rating, set.Metadata.Genre, set.Metadata.Length, set.Metadata.Notes, set.Id)
+}
+
+func (s *SQLStore) DeleteSet(ctx context.Context, id int) types.Error {
+	return s.exec(ctx, DELETE_SET, id)
+}
+
+func scanSet(row scanner) (types.Set, error) {
+	var set types.Set
+	err := row.Scan(&set.Id, &set.UserId, &set.ArtistId, &set.ArtistName, &set.LocationId, &set.LocationName, &set.StageId, &set.StageName,
+		&set.Date, &set.Metadata.Rating, &set.Metadata.Genre, &set.Metadata.Length, &set.Metadata.Notes)
+	return set, err
+}
+
+// Stages
+
+func (s *SQLStore) GetStages(ctx context.Context, locationId int) ([]types.Stage, types.Error) {
+	rows, err := s.DB.QueryContext(ctx, GET_LOCATION_STAGES, locationId)
+	if err != nil {
+		return nil, customerrors.New(http.StatusInternalServerError, "could not query database, "+err.Error())
+	}
+	defer rows.Close()
+
+	stages := make([]types.Stage, 0)
+	for rows.Next() {
+		var stage types.Stage
+		err := rows.Scan(&stage.Id, &stage.LocationId, &stage.Name)
+		if err != nil {
+			return nil, customerrors.New(http.StatusInternalServerError, "could not scan row, "+err.Error())
+		}
+		stages = append(stages, stage)
+	}
+
+	return stages, nil
+}
+
+func (s *SQLStore) GetStage(ctx context.Context, id string) (types.Stage, types.Error) {
+	var stage types.Stage
+	err := s.DB.QueryRowContext(ctx, GET_SPECIFIC_STAGE, id).Scan(&stage.Id, &stage.LocationId, &stage.Name)
+	if err != nil {
+		return stage, notFoundOr(err, "stage not found")
+	}
+
+	return stage, nil
+}
+
+func (s *SQLStore) IsStageUnique(ctx context.Context, stage types.Stage) (bool, types.Error) {
+	return s.isUnique(ctx, IS_STAGE_UNIQUE_QUERY, stage.Id, stage.LocationId, stage.Name)
+}
+
+func (s *SQLStore) CreateStage(ctx context.Context, stage types.Stage) (int, types.Error) {
+	return s.insert(ctx, INSERT_NEW_STAGE, stage.LocationId, stage.Name)
+}
+
+func (s *SQLStore) UpdateStage(ctx context.Context, stage types.Stage) types.Error {
+	return s.exec(ctx, UPDATE_STAGE, stage.Name, stage.Id)
+}
+
+func (s *SQLStore) DeleteStage(ctx context.Context, locationId int, stageId int) (int, types.Error) {
+	var cleared int
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		updated, customErr := txExecCount(ctx, tx, CLEAR_SETS_STAGE, stageId)
+		if customErr != nil {
+			return customErr
+		}
+		cleared = int(updated)
+
+		deleted, customErr := txExecCount(ctx, tx, DELETE_STAGE, stageId, locationId)
+		if customErr == nil && deleted == 0 {
+			return customerrors.New(http.StatusNotFound, "stage not found")
+		}
+
+		return customErr
+	})
+
+	return cleared, customErr
+}
+
+// listPage counts every row matching the query, then fetches one page of them and hands each
+// row to scan.
+func (s *SQLStore) listPage(ctx context.Context, list *listQuery, page pageQuery, scan func(rows *sql.Rows) error) (int, types.Error) {
+	var total int
+	query, args := list.count()
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&total)
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "could not count rows, "+err.Error())
+	}
+
+	query, args = list.page(page)
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "could not query database, "+err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err := scan(rows)
+		if err != nil {
+			return 0, customerrors.New(http.StatusInternalServerError, "could not scan row, "+err.Error())
+		}
+	}
+
+	return total, nil
+}
+
+func (s *SQLStore) isUnique(ctx context.Context, query string, args ...interface{}) (bool, types.Error) {
+	var count int
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&count)
+	if err != nil {
+		return false, customerrors.New(http.StatusInternalServerError, "could not determine uniqueness, "+err.Error())
+	}
+
+	return count == 0, nil
+}
+
+func (s *SQLStore) insert(ctx context.Context, query string, args ...interface{}) (int, types.Error) {
+	result, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "error executing insert statement, "+err.Error())
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "could not get inserted id, "+err.Error())
+	}
+
+	return int(id), nil
+}
+
+func (s *SQLStore) exec(ctx context.Context, query string, args ...interface{}) types.Error {
+	_, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, "could not execute statement, "+err.Error())
+	}
+
+	return nil
+}
+
+// Sessions
+
+func (s *SQLStore) CreateSession(ctx context.Context, session types.Session) (int, types.Error) {
+	return s.insert(ctx, INSERT_SESSION, sessionArgs(session)...)
+}
+
+func (s *SQLStore) GetSession(ctx context.Context, id string) (types.Session, types.Error) {
+	return s.getSession(ctx, GET_SESSION, id)
+}
+
+func (s *SQLStore) GetSessionByTokenHash(ctx context.Context, tokenHash string) (types.Session, types.Error) {
+	return s.getSession(ctx, GET_SESSION_BY_TOKEN_HASH, tokenHash)
+}
+
+func (s *SQLStore) GetActiveSessionsForUser(ctx context.Context, userId int) ([]types.Session, types.Error) {
+	rows, err := s.DB.QueryContext(ctx, GET_ACTIVE_SESSIONS_FOR_USER, userId, time.Now().Unix())
+	if err != nil {
+		return nil, customerrors.New(http.StatusInternalServerError, "could not query database, "+err.Error())
+	}
+	defer rows.Close()
+
+	sessions := make([]types.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, customerrors.New(http.StatusInternalServerError, "could not scan row, "+err.Error())
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func (s *SQLStore) RotateSession(ctx context.Context, oldId int, next types.Session) (int, types.Error) {
+	var id int
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		result, err := tx.ExecContext(ctx, REVOKE_ACTIVE_SESSION, next.CreatedAt, oldId)
+		if err != nil {
+			return customerrors.New(http.StatusInternalServerError, "could not revoke session, "+err.Error())
+		}
+
+		revoked, err := result.RowsAffected()
+		if err != nil {
+			return customerrors.New(http.StatusInternalServerError, "could not revoke session, "+err.Error())
+		}
+		if revoked == 0 {
+			return customerrors.New(http.StatusConflict, "session was already revoked")
+		}
+
+		customErr := txExec(ctx, tx, REVOKE_SESSION_ACCESS_TOKEN, oldId, next.CreatedAt)
+		if customErr != nil {
+			return customErr
+		}
+
+		result, err = tx.ExecContext(ctx, INSERT_SESSION, sessionArgs(next)...)
+		if err != nil {
+			return customerrors.New(http.StatusInternalServerError, "error executing insert statement, "+err.Error())
+		}
+
+		lastId, err := result.LastInsertId()
+		if err != nil {
+			return customerrors.New(http.StatusInternalServerError, "could not get inserted id, "+err.Error())
+		}
+
+		id = int(lastId)
+		return nil
+	})
+
+	return id, customErr
+}
+
+func (s *SQLStore) RevokeSessionFamily(ctx context.Context, familyId string) types.Error {
+	return s.revokeSessions(ctx, REVOKE_SESSION_FAMILY_ACCESS_TOKENS, REVOKE_SESSION_FAMILY, familyId)
+}
+
+func (s *SQLStore) RevokeUserSessions(ctx context.Context, userId int) types.Error {
+	return s.revokeSessions(ctx, REVOKE_USER_ACCESS_TOKENS, REVOKE_USER_SESSIONS, userId)
+}
+
+func (s *SQLStore) RevokeToken(ctx context.Context, tokenId string, expiresAt int64) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		customErr := txExec(ctx, tx, DELETE_EXPIRED_REVOKED_TOKENS, time.Now().Unix())
+		if customErr != nil {
+			return customErr
+		}
+
+		return txExec(ctx, tx, INSERT_REVOKED_TOKEN, tokenId, expiresAt)
+	})
+}
+
+func (s *SQLStore) IsTokenRevoked(ctx context.Context, tokenId string) (bool, types.Error) {
+	unique, customErr := s.isUnique(ctx, IS_TOKEN_REVOKED, tokenId)
+	return !unique, customErr
+}
+
+// revokeSessions copies the unexpired access tokens of the sessions matching keys onto the
+// revocation list, then revokes the sessions themselves.
+func (s *SQLStore) revokeSessions(ctx context.Context, revokeTokens string, revokeSessions string, keys ...interface{}) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		return txRevokeSessions(ctx, tx, revokeTokens, revokeSessions, time.Now().Unix(), keys...)
+	})
+}
+
+// txRevokeSessions is revokeSessions within tx. revokeTokens takes the keys followed by now,
+// revokeSessions takes now followed by the keys.
+func txRevokeSessions(ctx context.Context, tx *sql.Tx, revokeTokens string, revokeSessions string, now int64, keys ...interface{}) types.Error {
+	customErr := txExec(ctx, tx, DELETE_EXPIRED_REVOKED_TOKENS, now)
+	if customErr != nil {
+		return customErr
+	}
+
+	customErr = txExec(ctx, tx, revokeTokens, append(keys, now)...)
+	if customErr != nil {
+		return customErr
+	}
+
+	return txExec(ctx, tx, revokeSessions, append([]interface{}{now}, keys...)...)
+}
+
+func (s *SQLStore) getSession(ctx context.Context, query string, args ...interface{}) (types.Session, types.Error) {
+	session, err := scanSession(s.DB.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return session, notFoundOr(err, "session not found")
+	}
+
+	return session, nil
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row scanner) (types.Session, error) {
+	var session types.Session
+	err := row.Scan(&session.Id, &session.UserId, &session.FamilyId, &session.TokenHash, &session.UserAgent, &session.IpAddress, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt, &session.AccessTokenId, &session.AccessExpiresAt)
+	return session, err
+}
+
+func sessionArgs(session types.Session) []interface{} {
+	return []interface{}{session.UserId, session.FamilyId, session.TokenHash, session.UserAgent, session.IpAddress, session.CreatedAt, session.LastUsedAt, session.ExpiresAt, session.AccessTokenId, session.AccessExpiresAt}
+}
+
+// API tokens
+
+func (s *SQLStore) CreateApiToken(ctx context.Context, token types.ApiToken) (int, types.Error) {
+	return s.insert(ctx, INSERT_API_TOKEN, token.UserId, token.Name, token.TokenHash, strings.Join(token.Scopes, " "), token.CreatedAt, token.ExpiresAt)
+}
+
+func (s *SQLStore) GetApiToken(ctx context.Context, id string) (types.ApiToken, types.Error) {
+	return s.getApiToken(ctx, GET_API_TOKEN, id)
+}
+
+func (s *SQLStore) GetApiTokenByHash(ctx context.Context, tokenHash string) (types.ApiToken, types.Error) {
+	return s.getApiToken(ctx, GET_API_TOKEN_BY_HASH, tokenHash)
+}
+
+func (s *SQLStore) GetApiTokensForUser(ctx context.Context, userId int) ([]types.ApiToken, types.Error) {
+	rows, err := s.DB.QueryContext(ctx, GET_API_TOKENS_FOR_USER, userId)
+	if err != nil {
+		return nil, customerrors.New(http.StatusInternalServerError, "could not query database, "+err.Error())
+	}
+	defer rows.Close()
+
+	tokens := make([]types.ApiToken, 0)
+	for rows.Next() {
+		token, err := scanApiToken(rows)
+		if err != nil {
+			return nil, customerrors.New(http.StatusInternalServerError, "could not scan row, "+err.Error())
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+func (s *SQLStore) RevokeApiToken(ctx context.Context, id int) types.Error {
+	return s.exec(ctx, REVOKE_API_TOKEN, time.Now().Unix(), id)
+}
+
+func (s *SQLStore) TouchApiToken(ctx context.Context, id int, usedAt int64) types.Error {
+	return s.exec(ctx, TOUCH_API_TOKEN, usedAt, id)
+}
+
+func (s *SQLStore) getApiToken(ctx context.Context, query string, args ...interface{}) (types.ApiToken, types.Error) {
+	token, err := scanApiToken(s.DB.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return token, notFoundOr(err, "token not found")
+	}
+
+	return token, nil
+}
+
+func scanApiToken(row scanner) (types.ApiToken, error) {
+	var token types.ApiToken
+	var scopes string
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &token.TokenHash, &scopes, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.RevokedAt)
+	token.Scopes = strings.Fields(scopes)
+	return token, err
+}
+
+// Password resets
+
+func (s *SQLStore) CreatePasswordReset(ctx context.Context, reset types.PasswordReset) (int, types.Error) {
+	return s.insert(ctx, INSERT_PASSWORD_RESET, reset.UserId, reset.TokenHash, reset.CreatedAt, reset.ExpiresAt)
+}
+
+func (s *SQLStore) GetPasswordResetByHash(ctx context.Context, tokenHash string) (types.PasswordReset, types.Error) {
+	var reset types.PasswordReset
+	err := s.DB.QueryRowContext(ctx, GET_PASSWORD_RESET_BY_HASH, tokenHash).Scan(&reset.Id, &reset.UserId, &reset.TokenHash, &reset.CreatedAt, &reset.ExpiresAt, &reset.UsedAt)
+	if err != nil {
+		return reset, notFoundOr(err, "password reset not found")
+	}
+
+	return reset, nil
+}
+
+func (s *SQLStore) ResetPassword(ctx context.Context, reset types.PasswordReset, passwordHash string) types.Error {
+	now := time.Now().Unix()
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		customErr := txExecOnce(ctx, tx, "password reset was already used", USE_PASSWORD_RESET, now, reset.Id)
+		if customErr != nil {
+			return customErr
+		}
+
+		customErr = txExec(ctx, tx, USE_USER_PASSWORD_RESETS, now, reset.UserId)
+		if customErr != nil {
+			return customErr
+		}
+
+		customErr = txExec(ctx, tx, UPDATE_USER_PASSWORD, passwordHash, reset.UserId)
+		if customErr != nil {
+			return customErr
+		}
+
+		customErr = txExec(ctx, tx, REVOKE_USER_API_TOKENS, now, reset.UserId)
+		if customErr != nil {
+			return customErr
+		}
+
+		return txRevokeSessions(ctx, tx, REVOKE_USER_ACCESS_TOKENS, REVOKE_USER_SESSIONS, now, reset.UserId)
+	})
+}
+
+// Email verifications
+
+func (s *SQLStore) CreateEmailVerification(ctx context.Context, verification types.EmailVerification) (int, types.Error) {
+	return s.insert(ctx, INSERT_EMAIL_VERIFICATION, verification.UserId, verification.Email, verification.TokenHash, verification.CreatedAt, verification.ExpiresAt)
+}
+
+func (s *SQLStore) GetEmailVerificationByHash(ctx context.Context, tokenHash string) (types.EmailVerification, types.Error) {
+	var verification types.EmailVerification
+	err := s.DB.QueryRowContext(ctx, GET_EMAIL_VERIFICATION_BY_HASH, tokenHash).Scan(&verification.Id, &verification.UserId, &verification.Email, &verification.TokenHash, &verification.CreatedAt, &verification.ExpiresAt, &verification.UsedAt)
+	if err != nil {
+		return verification, notFoundOr(err, "email verification not found")
+	}
+
+	return verification, nil
+}
+
+func (s *SQLStore) VerifyEmail(ctx context.Context, verification types.EmailVerification) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		customErr := txExecOnce(ctx, tx, "email verification was already used", USE_EMAIL_VERIFICATION, time.Now().Unix(), verification.Id)
+		if customErr != nil {
+			return customErr
+		}
+
+		return txExecOnce(ctx, tx, "email has changed since the verification was sent", VERIFY_USER_EMAIL, verification.UserId, verification.Email)
+	})
+}
+
+// User identities
+
+func (s *SQLStore) GetUserIdentity(ctx context.Context, issuer string, subject string) (types.UserIdentity, types.Error) {
+	identity, err := scanUserIdentity(s.DB.QueryRowContext(ctx, GET_USER_IDENTITY, issuer, subject))
+	if err != nil {
+		return identity, notFoundOr(err, "identity not linked")
+	}
+
+	return identity, nil
+}
+
+func (s *SQLStore) LinkUserIdentity(ctx context.Context, identity types.UserIdentity) (int, types.Error) {
+	var id int
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		var customErr types.Error
+		id, customErr = txInsertUserIdentity(ctx, tx, identity)
+		return customErr
+	})
+
+	return id, customErr
+}
+
+func (s *SQLStore) CreateUserWithIdentity(ctx context.Context, user types.User, identity types.UserIdentity) (int, types.Error) {
+	var userId int
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		result, err := tx.ExecContext(ctx, INSERT_NEW_VERIFIED_USER, user.Username, user.Email, user.Password, user.FirstName, user.LastName)
+		if err != nil {
+			return customerrors.New(http.StatusInternalServerError, "error executing insert statement, "+err.Error())
+		}
+
+		lastId, err := result.LastInsertId()
+		if err != nil {
+			return customerrors.New(http.StatusInternalServerError, "could not get inserted id, "+err.Error())
+		}
+
+		userId = int(lastId)
+		identity.UserId = userId
+		_, customErr := txInsertUserIdentity(ctx, tx, identity)
+		return customErr
+	})
+
+	return userId, customErr
+}
+
+func (s *SQLStore) TouchUserIdentity(ctx context.Context, id int, email string, loginAt int64) types.Error {
+	return s.exec(ctx, TOUCH_USER_IDENTITY, email, loginAt, id)
+}
+
+// txInsertUserIdentity links identity within tx, returning a 409 if it is already linked.
+func txInsertUserIdentity(ctx context.Context, tx *sql.Tx, identity types.UserIdentity) (int, types.Error) {
+	_, err := scanUserIdentity(tx.QueryRowContext(ctx, GET_USER_IDENTITY, identity.Issuer, identity.Subject))
+	if err == nil {
+		return 0, customerrors.New(http.StatusConflict, "identity is already linked to a user")
+	}
+	if err != sql.ErrNoRows {
+		return 0, customerrors.New(http.StatusInternalServerError, "could not read identity, "+err.Error())
+	}
+
+	result, err := tx.ExecContext(ctx, INSERT_USER_IDENTITY, identity.UserId, identity.Issuer, identity.Subject, identity.Email, identity.CreatedAt, identity.LastLoginAt)
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "error executing insert statement, "+err.Error())
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "could not get inserted id, "+err.Error())
+	}
+
+	return int(id), nil
+}
+
+func scanUserIdentity(row scanner) (types.UserIdentity, error) {
+	var identity types.UserIdentity
+	err := row.Scan(&identity.Id, &identity.UserId, &identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt)
+	return identity, err
+}
+
+// Two-factor authentication
+
+func (s *SQLStore) GetTwoFactor(ctx context.Context, userId int) (types.TwoFactor, types.Error) {
+	var twoFactor types.TwoFactor
+	err := s.DB.QueryRowContext(ctx, GET_TWO_FACTOR, userId).Scan(&twoFactor.UserId, &twoFactor.Secret, &twoFactor.CreatedAt, &twoFactor.EnabledAt, &twoFactor.LastUsedStep)
+	if err != nil {
+		return twoFactor, notFoundOr(err, "two-factor authentication is not set up")
+	}
+
+	return twoFactor, nil
+}
+
+func (s *SQLStore) StartTwoFactorEnrollment(ctx context.Context, twoFactor types.TwoFactor) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		customErr := txExec(ctx, tx, DELETE_PENDING_TWO_FACTOR, twoFactor.UserId)
+		if customErr != nil {
+			return customErr
+		}
+
+		// The user id is the primary key, so this only fails if an enabled row is left.
+		_, err := tx.ExecContext(ctx, INSERT_TWO_FACTOR, twoFactor.UserId, twoFactor.Secret, twoFactor.CreatedAt)
+		if err != nil {
+			return customerrors.New(http.StatusConflict, "two-factor authentication is already enabled")
+		}
+
+		return nil
+	})
+}
+
+func (s *SQLStore) EnableTwoFactor(ctx context.Context, userId int, step int64, recoveryCodeHashes []string) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		customErr := txExecOnce(ctx, tx, "two-factor authentication is already enabled", ENABLE_TWO_FACTOR, time.Now().Unix(), step, userId)
+		if customErr != nil {
+			return customErr
+		}
+
+		return txReplaceRecoveryCodes(ctx, tx, userId, recoveryCodeHashes)
+	})
+}
+
+func (s *SQLStore) DisableTwoFactor(ctx context.Context, userId int) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		customErr := txExec(ctx, tx, DELETE_RECOVERY_CODES, userId)
+		if customErr != nil {
+			return customErr
+		}
+
+		return txExec(ctx, tx, DELETE_TWO_FACTOR, userId)
+	})
+}
+
+func (s *SQLStore) UseTotpStep(ctx context.Context, userId int, step int64) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		return txExecOnce(ctx, tx, "code was already used", USE_TOTP_STEP, step, userId, step)
+	})
+}
+
+func (s *SQLStore) ReplaceRecoveryCodes(ctx context.Context, userId int, recoveryCodeHashes []string) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		return txReplaceRecoveryCodes(ctx, tx, userId, recoveryCodeHashes)
+	})
+}
+
+func (s *SQLStore) UseRecoveryCode(ctx context.Context, userId int, codeHash string) types.Error {
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		return txExecOnce(ctx, tx, "recovery code not found", USE_RECOVERY_CODE, time.Now().Unix(), userId, codeHash)
+	})
+	if customErr != nil && customErr.StatusCode() == http.StatusConflict {
+		return customerrors.New(http.StatusNotFound, customErr.Description())
+	}
+
+	return customErr
+}
+
+func (s *SQLStore) CountRecoveryCodes(ctx context.Context, userId int) (int, types.Error) {
+	var count int
+	err := s.DB.QueryRowContext(ctx, COUNT_RECOVERY_CODES, userId).Scan(&count)
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "could not count recovery codes, "+err.Error())
+	}
+
+	return count, nil
+}
+
+func txReplaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int, recoveryCodeHashes []string) types.Error {
+	customErr := txExec(ctx, tx, DELETE_RECOVERY_CODES, userId)
+	if customErr != nil {
+		return customErr
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		customErr = txExec(ctx, tx, INSERT_RECOVERY_CODE, userId, codeHash)
+		if customErr != nil {
+			return customErr
+		}
+	}
+
+	return nil
+}
+
+// Settings
+
+func (s *SQLStore) GetSetting(ctx context.Context, name string) (string, types.Error) {
+	var value string
+	err := s.DB.QueryRowContext(ctx, GET_SETTING, name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", customerrors.New(http.StatusInternalServerError, "could not read setting, "+err.Error())
+	}
+
+	return value, nil
+}
+
+// SetSetting replaces the setting within a transaction, MySQL and SQLite spell upserts differently.
+func (s *SQLStore) SetSetting(ctx context.Context, name string, value string) types.Error {
+	return s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		customErr := txExec(ctx, tx, DELETE_SETTING, name)
+		if customErr != nil {
+			return customErr
+		}
+
+		return txExec(ctx, tx, INSERT_SETTING, name, value)
+	})
+}
+
+// Login throttles
+
+func (s *SQLStore) GetLoginThrottle(ctx context.Context, key string) (types.LoginThrottle, types.Error) {
+	throttle, err := scanLoginThrottle(s.DB.QueryRowContext(ctx, GET_LOGIN_THROTTLE, key))
+	if err == sql.ErrNoRows {
+		return types.LoginThrottle{Key: key}, nil
+	}
+	if err != nil {
+		return throttle, customerrors.New(http.StatusInternalServerError, "could not read login throttle, "+err.Error())
+	}
+
+	return throttle, nil
+}
+
+func (s *SQLStore) GetActiveLoginThrottles(ctx context.Context, now int64, since int64) ([]types.LoginThrottle, types.Error) {
+	rows, err := s.DB.QueryContext(ctx, GET_ACTIVE_LOGIN_THROTTLES, now, since)
+	if err != nil {
+		return nil, customerrors.New(http.StatusInternalServerError, "could not query database, "+err.Error())
+	}
+	defer rows.Close()
+
+	throttles := make([]types.LoginThrottle, 0)
+	for rows.Next() {
+		throttle, err := scanLoginThrottle(rows)
+		if err != nil {
+			return nil, customerrors.New(http.StatusInternalServerError, "could not scan row, "+err.Error())
+		}
+		throttles = append(throttles, throttle)
+	}
+
+	return throttles, nil
+}
+
+// AddLoginFailure only counts the failure if the row still holds previous, so concurrent sign
+// ins that read the same throttle can not all be let through.
+func (s *SQLStore) AddLoginFailure(ctx context.Context, previous types.LoginThrottle, now int64, forgetBefore int64) (types.LoginThrottle, types.Error) {
+	var throttle types.LoginThrottle
+	customErr := s.transaction(ctx, func(tx *sql.Tx) types.Error {
+		var customErr types.Error
+		if previous.LastFailureAt == 0 {
+			customErr = txExecOnce(ctx, tx, "login throttle changed", INSERT_LOGIN_THROTTLE, previous.Key, now, previous.Key)
+		} else {
+			customErr = txExecOnce(ctx, tx, "login throttle changed", ADD_LOGIN_FAILURE, forgetBefore, now, previous.Key, previous.Failures, previous.LastFailureAt)
+		}
+		if customErr != nil {
+			return customErr
+		}
+
+		var err error
+		throttle, err = scanLoginThrottle(tx.QueryRowContext(ctx, GET_LOGIN_THROTTLE, previous.Key))
+		if err != nil {
+			return customerrors.New(http.StatusInternalServerError, "could not read login throttle, "+err.Error())
+		}
+
+		return nil
+	})
+
+	return throttle, customErr
+}
+
+func (s *SQLStore) RemoveLoginFailure(ctx context.Context, key string) types.Error {
+	return s.exec(ctx, REMOVE_LOGIN_FAILURE, key)
+}
+
+func (s *SQLStore) LockLogin(ctx context.Context, key string, until int64) types.Error {
+	return s.exec(ctx, LOCK_LOGIN, until, key)
+}
+
+func (s *SQLStore) ClearLoginThrottle(ctx context.Context, key string) types.Error {
+	return s.exec(ctx, DELETE_LOGIN_THROTTLE, key)
+}
+
+func scanLoginThrottle(row scanner) (types.LoginThrottle, error) {
+	var throttle types.LoginThrottle
+	err := row.Scan(&throttle.Key, &throttle.Failures, &throttle.LastFailureAt, &throttle.LockedUntil)
+	return throttle, err
+}
+
+// transaction runs fn inside a transaction, committing only if it returns no error.
+func (s *SQLStore) transaction(ctx context.Context, fn func(tx *sql.Tx) types.Error) types.Error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, "could not start transaction, "+err.Error())
+	}
+
+	customErr := fn(tx)
+	if customErr != nil {
+		tx.Rollback()
+		return customErr
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, "could not commit transaction, "+err.Error())
+	}
+
+	return nil
+}
+
+func txExec(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) types.Error {
+	_, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return customerrors.New(http.StatusInternalServerError, "could not execute statement, "+err.Error())
+	}
+
+	return nil
+}
+
+// txExecOnce runs an update within tx that must change a row, returning a 409 with conflict
+// when it changes none.
+func txExecOnce(ctx context.Context, tx *sql.Tx, conflict string, query string, args ...interface{}) types.Error {
+	changed, customErr := txExecCount(ctx, tx, query, args...)
+	if customErr != nil {
+		return customErr
+	}
+	if changed == 0 {
+		return customerrors.New(http.StatusConflict, conflict)
+	}
+
+	return nil
+}
+
+// txExecCount runs a statement within tx and returns how many rows it changed.
+func txExecCount(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (int64, types.Error) {
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "could not execute statement, "+err.Error())
+	}
+
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return 0, customerrors.New(http.StatusInternalServerError, "could not execute statement, "+err.Error())
+	}
+
+	return changed, nil
+}
+
+func notFoundOr(err error, notFound string) types.Error {
+	if err == sql.ErrNoRows {
+		return customerrors.New(http.StatusNotFound, notFound)
+	}
+
+	return customerrors.New(http.StatusInternalServerError, err.Error())
+}
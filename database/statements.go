@@ -1,34 +1,228 @@
 package database
 
+// Statements are shared by every SQL driver, so stick to syntax that both MySQL and SQLite
+// understand (COALESCE rather than IFNULL, boolean logic rather than IF(), '' for strings).
+
 // Sets
-const GET_ALL_SETS = "select sets.id, user_id, artists.id, artists.name, locations.id, locations.name, sets.date, sets.rating, sets.genre, sets.length, sets.notes " +
-	"FROM sets INNER JOIN artists ON artists.id = sets.artist_id " +
-	"INNER JOIN locations ON locations.id = sets.location_id;"
-const GET_ALL_SETS_FOR_USER_FORMAT = "select sets.id, user_id, artists.id, artists.name, locations.id, locations.name, sets.date, sets.rating, sets.genre, sets.length, sets.notes " +
+// The SELECT_* and COUNT_* statements are completed with WHERE, ORDER BY and LIMIT clauses
+// when listing a page, see listQuery.
+const SELECT_SETS = "select sets.id, user_id, artists.id, artists.name, locations.id, locations.name, COALESCE(sets.stage_id, 0), COALESCE(stages.name, ''), " +
+	"sets.date, sets.rating, sets.genre, sets.length, sets.notes " +
 	"FROM sets INNER JOIN artists ON artists.id = sets.artist_id " +
 	"INNER JOIN locations ON locations.id = sets.location_id " +
-	"WHERE user_id=%d;"
+	"LEFT JOIN stages ON stages.id = sets.stage_id"
+const COUNT_SETS = "select COUNT(*) FROM sets INNER JOIN artists ON artists.id = sets.artist_id " +
+	"INNER JOIN locations ON locations.id = sets.location_id " +
+	"LEFT JOIN stages ON stages.id = sets.stage_id"
 const IS_SET_UNIQUE_QUERY = "select COUNT(*) FROM sets where user_id = ? and artist_id = ? and location_id = ? and date = ?"
-const INSERT_NEW_SET = `insert into sets (user_id, artist_id, location_id, date, rating, genre, length, notes) values(?,?,?,?,?,?,?,?);`
+const INSERT_NEW_SET = `insert into sets (user_id, artist_id, location_id, stage_id, date, rating, genre, length, notes) values(?,?,?,NULLIF(?, 0),?,?,?,?,?);`
+const GET_SPECIFIC_SET = SELECT_SETS + " WHERE sets.id = ?;"
+const IS_SET_UPDATE_UNIQUE = `select COUNT(*) FROM sets where id != ? and user_id = ? and artist_id = ? and location_id = ? and date = ?`
+const UPDATE_SET = `update sets set artist_id = ?, location_id = ?, stage_id = NULLIF(?, 0), date = ?, rating = ?, genre = ?, length = ?, notes = ? WHERE id = ?`
+const DELETE_SET = `delete FROM sets WHERE id = ?`
 
 // Users
-const GET_SPECIFIC_USER = `select id, username, email, IFNULL(first_name,""), IFNULL(last_name,""), role FROM users where id = ?;`
-const GET_ALL_USERS = `select id, username, email, IFNULL(first_name,""), IFNULL(last_name,""), role FROM users;`
+const IS_USER_UNIQUE_QUERY = `select COUNT(*) FROM users where username = ? OR email = ?`
+const INSERT_NEW_USER = `insert into users (username, email, password, first_name, last_name) values(?,?,?,?,?);`
+const GET_USER_BY_USERNAME = `select id, username, email, COALESCE(first_name, ''), COALESCE(last_name, ''), role, email_verified, password FROM users where username = ?;`
+const GET_USER_BY_EMAIL = `select id, username, email, COALESCE(first_name, ''), COALESCE(last_name, ''), role, email_verified FROM users where email = ?;`
+const GET_SPECIFIC_USER = `select id, username, email, COALESCE(first_name, ''), COALESCE(last_name, ''), role, email_verified FROM users where id = ?;`
+const SELECT_USERS = `select id, username, email, COALESCE(first_name, ''), COALESCE(last_name, ''), role, email_verified FROM users`
+const COUNT_USERS = `select COUNT(*) FROM users`
 const IS_USER_UPDATE_UNIQUE = `select COUNT(*) FROM users where id != ? AND (username = ? OR email = ?)`
-const UPDATE_USER = `update users set username = ?, email = ?, first_name = ?, last_name = ?, role = ? WHERE id = ?`
+const UPDATE_USER = `update users set username = ?, email = ?, first_name = ?, last_name = ?, email_verified = ? WHERE id = ?`
+const UPDATE_USER_ROLE = `update users set role = ? WHERE id = ?`
+const UPDATE_USER_PASSWORD = `update users set password = ? WHERE id = ?`
+const INSERT_ROLE_CHANGE = `insert into role_changes (user_id, changed_by, old_role, new_role) values(?,?,?,?);`
 
 // Artists
-const GET_ALL_ARTISTS = "select id, name, default_genre FROM artists;"
+// Artist names are compared by normalized_name, see names.Normalize. An artist's aliases count
+// as its names too.
+const SELECT_ARTISTS = "select id, name, default_genre FROM artists"
+const COUNT_ARTISTS = "select COUNT(*) FROM artists"
 const GET_SPECIFIC_ARTIST = "select id, name, default_genre FROM artists where id = ?;"
-const INSERT_NEW_ARTIST = `insert into artists (name, default_genre) values(?,?);`
-const GET_ARTIST_DEFAULT_GENRE = `select default_genre FROM artists where name = ? or id = ?;`
-const IS_ARTIST_UNIQUE_QUERY = `select COUNT(*) FROM artists where name = ?`
+const INSERT_NEW_ARTIST = `insert into artists (name, normalized_name, default_genre) values(?,?,?);`
+const GET_ARTIST_DEFAULT_GENRE = `select default_genre FROM artists where id = ? OR normalized_name = ? OR id IN (select artist_id FROM artist_aliases where normalized_name = ?) ` +
+	`ORDER BY CASE WHEN id = ? THEN 0 ELSE 1 END LIMIT 1;`
+const IS_ARTIST_UNIQUE_QUERY = `select (select COUNT(*) FROM artists where normalized_name = ?) + (select COUNT(*) FROM artist_aliases where normalized_name = ?)`
+const IS_ARTIST_UPDATE_UNIQUE = `select (select COUNT(*) FROM artists where id != ? AND normalized_name = ?) + ` +
+	`(select COUNT(*) FROM artist_aliases where artist_id != ? AND normalized_name = ?)`
+const UPDATE_ARTIST = `update artists set name = ?, normalized_name = ?, default_genre = ? WHERE id = ?`
+const SELECT_ARTIST_NAMES = "select id, name, default_genre, name, normalized_name FROM artists UNION ALL " +
+	"select artists.id, artists.name, artists.default_genre, artist_aliases.name, artist_aliases.normalized_name FROM artist_aliases " +
+	"INNER JOIN artists ON artists.id = artist_aliases.artist_id"
+const GET_UNNORMALIZED_ARTISTS = `select id, name FROM artists where normalized_name = '';`
+const SET_ARTIST_NORMALIZED_NAME = `update artists set normalized_name = ? WHERE id = ?`
+const DELETE_ARTIST = `delete FROM artists WHERE id = ?`
+const COUNT_ARTIST_SETS = `select COUNT(*) FROM sets where artist_id = ?`
+const DELETE_ARTIST_SETS = `delete FROM sets WHERE artist_id = ?`
+const MOVE_ARTIST_SETS = `update sets set artist_id = ? WHERE artist_id = ?`
+
+// DELETE_MERGED_DUPLICATE_SETS removes the sets of one artist that the same user also has for
+// another. MySQL can not read the table it deletes from in a subquery unless it goes through a
+// derived table first.
+const DELETE_MERGED_DUPLICATE_SETS = "delete FROM sets WHERE artist_id = ? AND (user_id, location_id, date) IN " +
+	"(select user_id, location_id, date FROM (select user_id, location_id, date FROM sets WHERE artist_id = ?) AS kept)"
+
+// Artist aliases
+const GET_ARTIST_ALIASES = `select id, artist_id, name, created_at FROM artist_aliases where artist_id = ? ORDER BY name;`
+const INSERT_ARTIST_ALIAS = `insert into artist_aliases (artist_id, name, normalized_name, created_at) values(?,?,?,?);`
+const DELETE_ARTIST_ALIAS = `delete FROM artist_aliases WHERE id = ? AND artist_id = ?`
+const DELETE_ARTIST_ALIASES = `delete FROM artist_aliases WHERE artist_id = ?`
+const MOVE_ARTIST_ALIASES = `update artist_aliases set artist_id = ? WHERE artist_id = ?`
+
+// ALIAS_MERGED_ARTIST keeps the name of an artist being merged away as an alias of the one it is
+// merged into, unless that name is already taken.
+const ALIAS_MERGED_ARTIST = "insert into artist_aliases (artist_id, name, normalized_name, created_at) " +
+	"select ?, name, normalized_name, ? FROM artists WHERE id = ? AND normalized_name != '' " +
+	"AND normalized_name NOT IN (select normalized_name FROM artists WHERE id = ?) " +
+	"AND normalized_name NOT IN (select normalized_name FROM artist_aliases)"
+
+// Artist redirects
+const GET_ARTIST_REDIRECT = `select to_id FROM artist_redirects where from_id = ?;`
+const INSERT_ARTIST_REDIRECT = `insert into artist_redirects (from_id, to_id, created_at) values(?,?,?);`
+const MOVE_ARTIST_REDIRECTS = `update artist_redirects set to_id = ? WHERE to_id = ?`
+const DELETE_ARTIST_REDIRECTS = `delete FROM artist_redirects WHERE to_id = ?`
 
 // Locations
-const GET_ALL_LOCATIONS = `select id, name, IFNULL(description,""), IFNULL(city,""), IFNULL(state,""), IFNULL(country,""), is_festival, IFNULL(year, 0000) FROM locations;`
-const GET_SPECIFIC_LOCATION = `select id, name, IFNULL(description,""), IFNULL(city,""), IFNULL(state,""), IFNULL(country,""), is_festival, IFNULL(year, 0000) FROM locations WHERE id = ?;`
-const INSERT_NEW_LOCATION = `insert into locations (name, description, city, state, country, is_festival, year) values(?,?,?,?,?,?,?);`
-const IS_LOCATION_UNIQUE_QUERY = `select COUNT(*) FROM locations where name = ? and city = ? and state = ? and country = ? and IF(is_festival = TRUE, year = ?, true );`
+const SELECT_LOCATIONS = `select id, name, COALESCE(description, ''), COALESCE(city, ''), COALESCE(state, ''), COALESCE(country, ''), is_festival, COALESCE(year, 0), ` +
+	`COALESCE(festival_id, 0), start_date, end_date, venue FROM locations`
+const COUNT_LOCATIONS = `select COUNT(*) FROM locations`
+const GET_SPECIFIC_LOCATION = SELECT_LOCATIONS + ` WHERE id = ?;`
+const INSERT_NEW_LOCATION = `insert into locations (name, description, city, state, country, is_festival, year, festival_id, start_date, end_date, venue) ` +
+	`values(?,?,?,?,?,?,?,NULLIF(?, 0),?,?,?);`
+const IS_LOCATION_UNIQUE_QUERY = `select COUNT(*) FROM locations where name = ? and city = ? and state = ? and country = ? and (is_festival = FALSE OR year = ?);`
 const GET_LOCATION_TYPE = `select is_festival FROM locations where id = ?;`
 const IS_LOCATION_UPDATE_UNIQUE = `select COUNT(*) FROM locations where id != ? AND (name = ? AND city = ? AND state = ? AND country = ? AND year = ?)`
-const UPDATE_LOCATION = `update locations set name = ?, description = ?, city = ?, state = ?, country = ?, is_festival = ?, year = ? WHERE id = ?`
+const UPDATE_LOCATION = `update locations set name = ?, description = ?, city = ?, state = ?, country = ?, is_festival = ?, year = ?, ` +
+	`festival_id = NULLIF(?, 0), start_date = ?, end_date = ?, venue = ? WHERE id = ?`
+const DELETE_LOCATION = `delete FROM locations WHERE id = ?`
+const COUNT_LOCATION_SETS = `select COUNT(*) FROM sets where location_id = ?`
+const DELETE_LOCATION_SETS = `delete FROM sets WHERE location_id = ?`
+const MOVE_LOCATION_SETS = `update sets set location_id = ? WHERE location_id = ?`
+
+const DELETE_LOCATION_STAGES = `delete FROM stages WHERE location_id = ?`
+const MOVE_LOCATION_STAGES = `update stages set location_id = ? WHERE location_id = ?`
+
+// MOVE_MERGED_STAGE_SETS points the sets on a stage of a location being merged away at the stage
+// with the same name at the location it is merged into, so DELETE_MERGED_DUPLICATE_STAGES can
+// remove the duplicate stage. Names are compared ignoring case, like IS_STAGE_UNIQUE_QUERY, so
+// the result does not depend on the database's collation.
+const MOVE_MERGED_STAGE_SETS = "update sets set stage_id = (select kept.id FROM stages kept INNER JOIN stages merged ON LOWER(merged.name) = LOWER(kept.name) " +
+	"WHERE merged.id = sets.stage_id AND kept.location_id = ?) " +
+	"WHERE stage_id IN (select merged.id FROM stages merged INNER JOIN stages kept ON LOWER(kept.name) = LOWER(merged.name) WHERE merged.location_id = ? AND kept.location_id = ?)"
+const DELETE_MERGED_DUPLICATE_STAGES = "delete FROM stages WHERE location_id = ? AND LOWER(name) IN " +
+	"(select name FROM (select LOWER(name) AS name FROM stages WHERE location_id = ?) AS kept)"
+
+// DELETE_MERGED_DUPLICATE_LOCATION_SETS is DELETE_MERGED_DUPLICATE_SETS for locations: it removes
+// the sets at one location that the same user also has for the same artist and date at another.
+const DELETE_MERGED_DUPLICATE_LOCATION_SETS = "delete FROM sets WHERE location_id = ? AND (user_id, artist_id, date) IN " +
+	"(select user_id, artist_id, date FROM (select user_id, artist_id, date FROM sets WHERE location_id = ?) AS kept)"
+
+// Stages
+const GET_LOCATION_STAGES = `select id, location_id, name FROM stages WHERE location_id = ? ORDER BY name;`
+const GET_SPECIFIC_STAGE = `select id, location_id, name FROM stages WHERE id = ?;`
+const INSERT_NEW_STAGE = `insert into stages (location_id, name) values(?,?);`
+const IS_STAGE_UNIQUE_QUERY = `select COUNT(*) FROM stages where id != ? AND location_id = ? AND LOWER(name) = LOWER(?)`
+const UPDATE_STAGE = `update stages set name = ? WHERE id = ?`
+const DELETE_STAGE = `delete FROM stages WHERE id = ? AND location_id = ?`
+const CLEAR_SETS_STAGE = `update sets set stage_id = NULL WHERE stage_id = ?`
+
+// Festivals
+const SELECT_FESTIVALS = `select id, name, description FROM festivals`
+const COUNT_FESTIVALS = `select COUNT(*) FROM festivals`
+const GET_SPECIFIC_FESTIVAL = `select id, name, description FROM festivals WHERE id = ?;`
+const GET_FESTIVAL_BY_NAME = `select id, name, description FROM festivals WHERE name = ?;`
+const INSERT_NEW_FESTIVAL = `insert into festivals (name, description) values(?,?);`
+const IS_FESTIVAL_UNIQUE_QUERY = `select COUNT(*) FROM festivals where name = ?`
+const IS_FESTIVAL_UPDATE_UNIQUE = `select COUNT(*) FROM festivals where id != ? AND name = ?`
+const UPDATE_FESTIVAL = `update festivals set name = ?, description = ? WHERE id = ?`
+const GET_FESTIVAL_EDITIONS = SELECT_LOCATIONS + ` WHERE festival_id = ? ORDER BY COALESCE(year, 0), id;`
+const GET_UNLINKED_FESTIVAL_LOCATIONS = `select id, name, COALESCE(year, 0) FROM locations WHERE is_festival = TRUE AND festival_id IS NULL;`
+const SET_LOCATION_FESTIVAL = `update locations set festival_id = ? WHERE id = ?`
+
+// Sessions
+const INSERT_SESSION = `insert into sessions (user_id, family_id, token_hash, user_agent, ip_address, created_at, last_used_at, expires_at, access_token_id, access_expires_at) values(?,?,?,?,?,?,?,?,?,?);`
+const GET_SESSION = `select id, user_id, family_id, token_hash, user_agent, ip_address, created_at, last_used_at, expires_at, COALESCE(revoked_at, 0), access_token_id, access_expires_at FROM sessions where id = ?;`
+const GET_SESSION_BY_TOKEN_HASH = `select id, user_id, family_id, token_hash, user_agent, ip_address, created_at, last_used_at, expires_at, COALESCE(revoked_at, 0), access_token_id, access_expires_at FROM sessions where token_hash = ?;`
+const GET_ACTIVE_SESSIONS_FOR_USER = `select id, user_id, family_id, token_hash, user_agent, ip_address, created_at, last_used_at, expires_at, COALESCE(revoked_at, 0), access_token_id, access_expires_at FROM sessions where user_id = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY last_used_at DESC, id DESC;`
+const REVOKE_ACTIVE_SESSION = `update sessions set revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+const REVOKE_SESSION_FAMILY = `update sessions set revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`
+const REVOKE_USER_SESSIONS = `update sessions set revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`
+const REVOKE_OTHER_USER_SESSIONS = `update sessions set revoked_at = ? WHERE user_id = ? AND family_id != ? AND revoked_at IS NULL`
+
+// Revoked tokens
+const INSERT_REVOKED_TOKEN = `insert into revoked_tokens (token_id, expires_at) values(?,?);`
+const REVOKE_SESSION_ACCESS_TOKEN = `insert into revoked_tokens (token_id, expires_at) select access_token_id, access_expires_at FROM sessions ` +
+	`WHERE id = ? AND access_token_id != '' AND access_expires_at > ? AND access_token_id NOT IN (select token_id FROM revoked_tokens)`
+const REVOKE_SESSION_FAMILY_ACCESS_TOKENS = `insert into revoked_tokens (token_id, expires_at) select access_token_id, access_expires_at FROM sessions ` +
+	`WHERE family_id = ? AND access_token_id != '' AND access_expires_at > ? AND access_token_id NOT IN (select token_id FROM revoked_tokens)`
+const REVOKE_USER_ACCESS_TOKENS = `insert into revoked_tokens (token_id, expires_at) select access_token_id, access_expires_at FROM sessions ` +
+	`WHERE user_id = ? AND access_token_id != '' AND access_expires_at > ? AND access_token_id NOT IN (select token_id FROM revoked_tokens)`
+const REVOKE_OTHER_USER_ACCESS_TOKENS = `insert into revoked_tokens (token_id, expires_at) select access_token_id, access_expires_at FROM sessions ` +
+	`WHERE user_id = ? AND family_id != ? AND access_token_id != '' AND access_expires_at > ? AND access_token_id NOT IN (select token_id FROM revoked_tokens)`
+const IS_TOKEN_REVOKED = `select COUNT(*) FROM revoked_tokens where token_id = ?`
+const DELETE_EXPIRED_REVOKED_TOKENS = `delete FROM revoked_tokens WHERE expires_at < ?`
+
+// API tokens
+const INSERT_API_TOKEN = `insert into api_tokens (user_id, name, token_hash, scopes, created_at, expires_at) values(?,?,?,?,?,?);`
+const GET_API_TOKEN = `select id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at, COALESCE(revoked_at, 0) FROM api_tokens where id = ?;`
+const GET_API_TOKEN_BY_HASH = `select id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at, COALESCE(revoked_at, 0) FROM api_tokens where token_hash = ?;`
+const GET_API_TOKENS_FOR_USER = `select id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at, COALESCE(revoked_at, 0) FROM api_tokens where user_id = ? AND revoked_at IS NULL ORDER BY id;`
+const REVOKE_API_TOKEN = `update api_tokens set revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+const REVOKE_USER_API_TOKENS = `update api_tokens set revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`
+const TOUCH_API_TOKEN = `update api_tokens set last_used_at = ? WHERE id = ?`
+
+// Password resets
+const INSERT_PASSWORD_RESET = `insert into password_resets (user_id, token_hash, created_at, expires_at) values(?,?,?,?);`
+const GET_PASSWORD_RESET_BY_HASH = `select id, user_id, token_hash, created_at, expires_at, COALESCE(used_at, 0) FROM password_resets where token_hash = ?;`
+const USE_PASSWORD_RESET = `update password_resets set used_at = ? WHERE id = ? AND used_at IS NULL`
+const USE_USER_PASSWORD_RESETS = `update password_resets set used_at = ? WHERE user_id = ? AND used_at IS NULL`
+
+// Email verifications
+const INSERT_EMAIL_VERIFICATION = `insert into email_verifications (user_id, email, token_hash, created_at, expires_at) values(?,?,?,?,?);`
+const GET_EMAIL_VERIFICATION_BY_HASH = `select id, user_id, email, token_hash, created_at, expires_at, COALESCE(used_at, 0) FROM email_verifications where token_hash = ?;`
+const USE_EMAIL_VERIFICATION = `update email_verifications set used_at = ? WHERE id = ? AND used_at IS NULL`
+const VERIFY_USER_EMAIL = `update users set email_verified = TRUE WHERE id = ? AND email = ?`
+
+// User identities
+const INSERT_NEW_VERIFIED_USER = `insert into users (username, email, password, first_name, last_name, email_verified) values(?,?,?,?,?,TRUE);`
+const INSERT_USER_IDENTITY = `insert into user_identities (user_id, issuer, subject, email, created_at, last_login_at) values(?,?,?,?,?,?);`
+const GET_USER_IDENTITY = `select id, user_id, issuer, subject, email, created_at, last_login_at FROM user_identities where issuer = ? AND subject = ?;`
+const TOUCH_USER_IDENTITY = `update user_identities set email = ?, last_login_at = ? WHERE id = ?`
+
+// Two-factor authentication
+const GET_TWO_FACTOR = `select user_id, secret, created_at, COALESCE(enabled_at, 0), last_used_step FROM two_factor where user_id = ?;`
+const INSERT_TWO_FACTOR = `insert into two_factor (user_id, secret, created_at) values(?,?,?);`
+const DELETE_TWO_FACTOR = `delete from two_factor WHERE user_id = ?`
+const DELETE_PENDING_TWO_FACTOR = `delete from two_factor WHERE user_id = ? AND enabled_at IS NULL`
+const ENABLE_TWO_FACTOR = `update two_factor set enabled_at = ?, last_used_step = ? WHERE user_id = ? AND enabled_at IS NULL`
+const USE_TOTP_STEP = `update two_factor set last_used_step = ? WHERE user_id = ? AND last_used_step < ?`
+const INSERT_RECOVERY_CODE = `insert into recovery_codes (user_id, code_hash) values(?,?);`
+const DELETE_RECOVERY_CODES = `delete from recovery_codes WHERE user_id = ?`
+const USE_RECOVERY_CODE = `update recovery_codes set used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`
+const COUNT_RECOVERY_CODES = `select COUNT(*) FROM recovery_codes where user_id = ? AND used_at IS NULL`
+
+// Settings
+const GET_SETTING = `select value FROM settings where name = ?;`
+const DELETE_SETTING = `delete from settings WHERE name = ?`
+const INSERT_SETTING = `insert into settings (name, value) values(?,?);`
+
+// Login throttles
+const GET_LOGIN_THROTTLE = `select throttle_key, failures, last_failure_at, locked_until FROM login_throttles where throttle_key = ?;`
+const GET_ACTIVE_LOGIN_THROTTLES = `select throttle_key, failures, last_failure_at, locked_until FROM login_throttles where locked_until > ? OR last_failure_at > ? ORDER BY last_failure_at DESC;`
+const ADD_LOGIN_FAILURE = `update login_throttles set failures = CASE WHEN last_failure_at <= ? THEN 1 ELSE failures + 1 END, last_failure_at = ? WHERE throttle_key = ? AND failures = ? AND last_failure_at = ?`
+const INSERT_LOGIN_THROTTLE = `insert into login_throttles (throttle_key, failures, last_failure_at) select ?,1,? WHERE NOT EXISTS (select 1 FROM login_throttles where throttle_key = ?);`
+const REMOVE_LOGIN_FAILURE = `update login_throttles set failures = failures - 1 WHERE throttle_key = ? AND failures > 0`
+const LOCK_LOGIN = `update login_throttles set locked_until = ? WHERE throttle_key = ?`
+const DELETE_LOGIN_THROTTLE = `delete from login_throttles WHERE throttle_key = ?`
+
+// Migrations
+const CREATE_SCHEMA_MIGRATIONS_TABLE = `create table if not exists schema_migrations (version INT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, dirty INT NOT NULL DEFAULT 0);`
+const HAS_SCHEMA_MIGRATIONS_DIRTY = `select COUNT(dirty) FROM schema_migrations;`
+const ADD_SCHEMA_MIGRATIONS_DIRTY = `alter table schema_migrations add column dirty INT NOT NULL DEFAULT 0;`
+const GET_APPLIED_MIGRATIONS = `select version, dirty FROM schema_migrations ORDER BY version;`
+const INSERT_MIGRATION = `insert into schema_migrations (version, name, dirty) values(?,?,?);`
+const MARK_MIGRATION_DIRTY = `update schema_migrations set dirty = 1 WHERE version = ?;`
+const MARK_MIGRATION_CLEAN = `update schema_migrations set dirty = 0 WHERE version = ?;`
+const DELETE_MIGRATION = `delete FROM schema_migrations WHERE version = ?;`
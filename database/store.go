@@ -0,0 +1,232 @@
+package database
+
+import (
+	"context"
+	"github.com/AnthonyNixon/setsisaw/types"
+)
+
+// Store is everything the API needs from a storage backend. Each method mirrors one of the
+// statements in statements.go so every backend answers the same questions the same way.
+type Store interface {
+	UserStore
+	ArtistStore
+	LocationStore
+	FestivalStore
+	StageStore
+	SetStore
+	SessionStore
+	ApiTokenStore
+	PasswordResetStore
+	EmailVerificationStore
+	IdentityStore
+	TwoFactorStore
+	SettingsStore
+	LoginThrottleStore
+}
+
+// AuthStore is what the auth package needs to sign users in and check their tokens.
+type AuthStore interface {
+	UserStore
+	SessionStore
+	ApiTokenStore
+	PasswordResetStore
+	EmailVerificationStore
+	IdentityStore
+	TwoFactorStore
+	SettingsStore
+	LoginThrottleStore
+}
+
+type UserStore interface {
+	IsUserUnique(ctx context.Context, username string, email string) (bool, types.Error)
+	IsUserUpdateUnique(ctx context.Context, user types.User) (bool, types.Error)
+	// CreateUser stores a new user with the USER role and an unverified email, user.Password
+	// must already be hashed.
+	CreateUser(ctx context.Context, user types.User) (int, types.Error)
+	GetUser(ctx context.Context, id string) (types.User, types.Error)
+	// GetUserByUsername returns the user including their password hash.
+	GetUserByUsername(ctx context.Context, username string) (types.User, types.Error)
+	GetUserByEmail(ctx context.Context, email string) (types.User, types.Error)
+	GetAllUsers(ctx context.Context, page types.PageRequest) ([]types.User, types.PageInfo, types.Error)
+	// UpdateUser updates a user's profile, their role is only ever changed by ChangeUserRole.
+	UpdateUser(ctx context.Context, user types.User) types.Error
+	// ChangeUserRole sets the user's role, records the change and revokes every session and personal
+	// access token of the user, so no token keeps the old role's scopes. All or nothing.
+	ChangeUserRole(ctx context.Context, change types.RoleChange) types.Error
+	// UpdatePassword replaces the user's password hash, e.g. to rehash it with a higher cost.
+	UpdatePassword(ctx context.Context, userId int, passwordHash string) types.Error
+	// ChangePassword sets a new password hash, revokes every session of the user outside keepFamilyId
+	// and every personal access token of theirs, all or nothing.
+	ChangePassword(ctx context.Context, userId int, passwordHash string, keepFamilyId string) types.Error
+}
+
+type ArtistStore interface {
+	// IsArtistUnique reports whether no artist or alias has the same normalized name.
+	IsArtistUnique(ctx context.Context, name string) (bool, types.Error)
+	CreateArtist(ctx context.Context, artist types.Artist) (int, types.Error)
+	GetArtist(ctx context.Context, id string) (types.Artist, types.Error)
+	GetAllArtists(ctx context.Context, page types.PageRequest) ([]types.Artist, types.PageInfo, types.Error)
+	GetArtistDefaultGenre(ctx context.Context, name string, id int) (string, types.Error)
+	IsArtistUpdateUnique(ctx context.Context, artist types.Artist) (bool, types.Error)
+	UpdateArtist(ctx context.Context, artist types.Artist) types.Error
+	// DeleteArtist deletes an artist and returns how many sets went with it. Without cascade it
+	// returns a 409 instead if any set references the artist.
+	DeleteArtist(ctx context.Context, id int, cascade bool) (int, types.Error)
+	// MergeArtists moves every set from the duplicates onto artistId and deletes them, leaving
+	// redirects from their ids, all in one transaction.
+	MergeArtists(ctx context.Context, artistId int, duplicateIds []int) (types.ArtistMerge, types.Error)
+	// GetArtistRedirect returns the id an artist was merged into.
+	GetArtistRedirect(ctx context.Context, id string) (int, types.Error)
+	// GetArtistNames returns every artist's name and aliases, for matching names in Go.
+	GetArtistNames(ctx context.Context) ([]types.ArtistName, types.Error)
+	GetArtistAliases(ctx context.Context, artistId int) ([]types.ArtistAlias, types.Error)
+	CreateArtistAlias(ctx context.Context, alias types.ArtistAlias) (int, types.Error)
+	DeleteArtistAlias(ctx context.Context, artistId int, aliasId int) types.Error
+}
+
+type LocationStore interface {
+	IsLocationUnique(ctx context.Context, location types.Location) (bool, types.Error)
+	IsLocationUpdateUnique(ctx context.Context, location types.Location) (bool, types.Error)
+	CreateLocation(ctx context.Context, location types.Location) (int, types.Error)
+	GetLocation(ctx context.Context, id string) (types.Location, types.Error)
+	GetAllLocations(ctx context.Context, page types.PageRequest) ([]types.Location, types.PageInfo, types.Error)
+	UpdateLocation(ctx context.Context, location types.Location) types.Error
+	// DeleteLocation deletes a location and returns how many sets were deleted with it. Unless
+	// cascade is set, locations with sets are not deleted.
+	DeleteLocation(ctx context.Context, id int, cascade bool) (int, types.Error)
+	// MergeLocations moves the sets of duplicateIds to locationId and deletes the duplicates, all
+	// or nothing. Festival editions and other locations are not merged with each other.
+	MergeLocations(ctx context.Context, locationId int, duplicateIds []int) (types.LocationMerge, types.Error)
+	IsFestival(ctx context.Context, locationId int) (bool, types.Error)
+}
+
+// FestivalStore keeps festival series, their editions are festival locations with a festival_id.
+type FestivalStore interface {
+	IsFestivalUnique(ctx context.Context, name string) (bool, types.Error)
+	IsFestivalUpdateUnique(ctx context.Context, festival types.Festival) (bool, types.Error)
+	CreateFestival(ctx context.Context, festival types.Festival) (int, types.Error)
+	GetFestival(ctx context.Context, id string) (types.Festival, types.Error)
+	// GetFestivalByName returns a 404 if there is no series with the name, compared without case.
+	GetFestivalByName(ctx context.Context, name string) (types.Festival, types.Error)
+	GetAllFestivals(ctx context.Context, page types.PageRequest) ([]types.Festival, types.PageInfo, types.Error)
+	UpdateFestival(ctx context.Context, festival types.Festival) types.Error
+	// GetFestivalEditions returns the festival's editions, oldest first.
+	GetFestivalEditions(ctx context.Context, festivalId int) ([]types.Location, types.Error)
+}
+
+type StageStore interface {
+	// GetStages returns the stages of a location, by name.
+	GetStages(ctx context.Context, locationId int) ([]types.Stage, types.Error)
+	GetStage(ctx context.Context, id string) (types.Stage, types.Error)
+	// IsStageUnique reports whether no other stage at the stage's location has its name.
+	IsStageUnique(ctx context.Context, stage types.Stage) (bool, types.Error)
+	CreateStage(ctx context.Context, stage types.Stage) (int, types.Error)
+	UpdateStage(ctx context.Context, stage types.Stage) types.Error
+	// DeleteStage deletes a stage of a location and returns how many sets no longer have a stage.
+	DeleteStage(ctx context.Context, locationId int, stageId int) (int, types.Error)
+}
+
+type SetStore interface {
+	IsSetUnique(ctx context.Context, set types.Set) (bool, types.Error)
+	IsSetUpdateUnique(ctx context.Context, set types.Set) (bool, types.Error)
+	CreateSet(ctx context.Context, set types.Set) (int, types.Error)
+	GetSet(ctx context.Context, id string) (types.Set, types.Error)
+	// ListSets returns one page of the sets matching every filter that is set.
+	ListSets(ctx context.Context, filter types.SetFilter, page types.PageRequest) ([]types.Set, types.PageInfo, types.Error)
+	UpdateSet(ctx context.Context, set types.Set) types.Error
+	DeleteSet(ctx context.Context, id int) types.Error
+}
+
+type SessionStore interface {
+	CreateSession(ctx context.Context, session types.Session) (int, types.Error)
+	GetSession(ctx context.Context, id string) (types.Session, types.Error)
+	GetSessionByTokenHash(ctx context.Context, tokenHash string) (types.Session, types.Error)
+	// GetActiveSessionsForUser returns the sessions that can still be refreshed, most recently used first.
+	GetActiveSessionsForUser(ctx context.Context, userId int) ([]types.Session, types.Error)
+	// RotateSession revokes the session with oldId along with its access token and creates next in
+	// its place, failing with a 409 if oldId was already revoked so a refresh token can only ever
+	// be used once.
+	RotateSession(ctx context.Context, oldId int, next types.Session) (int, types.Error)
+	// RevokeSessionFamily and RevokeUserSessions revoke the sessions along with every unexpired
+	// access token issued with them.
+	RevokeSessionFamily(ctx context.Context, familyId string) types.Error
+	RevokeUserSessions(ctx context.Context, userId int) types.Error
+	// RevokeToken adds an access token to the revocation list until it expires.
+	RevokeToken(ctx context.Context, tokenId string, expiresAt int64) types.Error
+	IsTokenRevoked(ctx context.Context, tokenId string) (bool, types.Error)
+}
+
+type ApiTokenStore interface {
+	CreateApiToken(ctx context.Context, token types.ApiToken) (int, types.Error)
+	GetApiToken(ctx context.Context, id string) (types.ApiToken, types.Error)
+	GetApiTokenByHash(ctx context.Context, tokenHash string) (types.ApiToken, types.Error)
+	// GetApiTokensForUser returns the user's tokens that have not been revoked, expired ones included.
+	GetApiTokensForUser(ctx context.Context, userId int) ([]types.ApiToken, types.Error)
+	RevokeApiToken(ctx context.Context, id int) types.Error
+	TouchApiToken(ctx context.Context, id int, usedAt int64) types.Error
+}
+
+type PasswordResetStore interface {
+	CreatePasswordReset(ctx context.Context, reset types.PasswordReset) (int, types.Error)
+	GetPasswordResetByHash(ctx context.Context, tokenHash string) (types.PasswordReset, types.Error)
+	// ResetPassword uses reset to set the user's password, along with every other outstanding reset
+	// for the user, and revokes all of their sessions and personal access tokens. It returns a 409 if
+	// reset was already used.
+	ResetPassword(ctx context.Context, reset types.PasswordReset, passwordHash string) types.Error
+}
+
+type EmailVerificationStore interface {
+	CreateEmailVerification(ctx context.Context, verification types.EmailVerification) (int, types.Error)
+	GetEmailVerificationByHash(ctx context.Context, tokenHash string) (types.EmailVerification, types.Error)
+	// VerifyEmail uses verification to mark the user's email verified. It returns a 409 if
+	// verification was already used or the user's email has changed since.
+	VerifyEmail(ctx context.Context, verification types.EmailVerification) types.Error
+}
+
+type IdentityStore interface {
+	GetUserIdentity(ctx context.Context, issuer string, subject string) (types.UserIdentity, types.Error)
+	// LinkUserIdentity links an existing user, it returns a 409 if the identity is already linked.
+	LinkUserIdentity(ctx context.Context, identity types.UserIdentity) (int, types.Error)
+	// CreateUserWithIdentity creates a user with a verified email and links identity to them.
+	CreateUserWithIdentity(ctx context.Context, user types.User, identity types.UserIdentity) (int, types.Error)
+	TouchUserIdentity(ctx context.Context, id int, email string, loginAt int64) types.Error
+}
+
+type TwoFactorStore interface {
+	GetTwoFactor(ctx context.Context, userId int) (types.TwoFactor, types.Error)
+	// StartTwoFactorEnrollment replaces any enrollment the user has not confirmed. It returns a
+	// 409 if the user already has two-factor authentication enabled.
+	StartTwoFactorEnrollment(ctx context.Context, twoFactor types.TwoFactor) types.Error
+	// EnableTwoFactor confirms the enrollment, recording step as used, and replaces the user's
+	// recovery codes, all or nothing.
+	EnableTwoFactor(ctx context.Context, userId int, step int64, recoveryCodeHashes []string) types.Error
+	DisableTwoFactor(ctx context.Context, userId int) types.Error
+	// UseTotpStep records step as used, returning a 409 if it or a later one already was.
+	UseTotpStep(ctx context.Context, userId int, step int64) types.Error
+	ReplaceRecoveryCodes(ctx context.Context, userId int, recoveryCodeHashes []string) types.Error
+	// UseRecoveryCode returns a 404 unless the user has an unused code with codeHash.
+	UseRecoveryCode(ctx context.Context, userId int, codeHash string) types.Error
+	CountRecoveryCodes(ctx context.Context, userId int) (int, types.Error)
+}
+
+type SettingsStore interface {
+	// GetSetting returns "" for settings that were never set.
+	GetSetting(ctx context.Context, name string) (string, types.Error)
+	SetSetting(ctx context.Context, name string, value string) types.Error
+}
+
+type LoginThrottleStore interface {
+	// GetLoginThrottle returns a throttle with no failures for keys that never failed.
+	GetLoginThrottle(ctx context.Context, key string) (types.LoginThrottle, types.Error)
+	// GetActiveLoginThrottles returns throttles locked after now or that failed after since.
+	GetActiveLoginThrottles(ctx context.Context, now int64, since int64) ([]types.LoginThrottle, types.Error)
+	// AddLoginFailure counts a failure at now on top of previous, the throttle as the caller
+	// last read it, starting the count over when the last failure was at or before forgetBefore.
+	// It returns the updated throttle, or a 409 without counting anything if the throttle has
+	// changed since it was read.
+	AddLoginFailure(ctx context.Context, previous types.LoginThrottle, now int64, forgetBefore int64) (types.LoginThrottle, types.Error)
+	// RemoveLoginFailure takes one failure back off the count.
+	RemoveLoginFailure(ctx context.Context, key string) types.Error
+	LockLogin(ctx context.Context, key string, until int64) types.Error
+	ClearLoginThrottle(ctx context.Context, key string) types.Error
+}
@@ -0,0 +1,40 @@
+// Package env reads settings from environment variables, stopping the server when one is set
+// to something it can not use rather than carrying on with a surprising value.
+package env
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Int returns the integer in name, fallback when it is unset.
+func Int(name string, fallback int, min int, max int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		log.Fatalf("%s must be a number between %d and %d, got %q. Not Starting.", name, min, max, raw)
+	}
+
+	return value
+}
+
+// Duration returns the duration in name, such as 30s or 15m, fallback when it is unset.
+func Duration(name string, fallback time.Duration, min time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < min {
+		log.Fatalf("%s must be a duration of at least %s such as 30s or 15m, got %q. Not Starting.", name, min, raw)
+	}
+
+	return value
+}
@@ -1,12 +1,13 @@
 module github.com/AnthonyNixon/setsisaw
 
-go 1.12
+go 1.16
 
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gin-contrib/cors v1.3.0
-	github.com/gin-gonic/gin v1.4.0
+	github.com/gin-gonic/gin v1.7.7
 	github.com/go-sql-driver/mysql v1.4.1
-	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
+	github.com/mattn/go-sqlite3 v1.14.17
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	google.golang.org/appengine v1.5.0 // indirect
 )
@@ -1,41 +1,38 @@
 package handlers
 
 import (
-	"database/sql"
 	"fmt"
-	"github.com/AnthonyNixon/setsisaw/auth"
 	"github.com/AnthonyNixon/setsisaw/database"
 	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/AnthonyNixon/setsisaw/utils"
 	"github.com/gin-gonic/gin"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func NewArtist(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
-	if customErr != nil {
-		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
-		return
-	}
-
-	// TODO: This shouldn't be an editor only function. Anyone should be able to add an artist. This is just for testing claims.
-	if !auth.IsEntitled(claims, "EDITOR") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to add an artist.", claims.Username)})
-		return
-	}
+const DEFAULT_ARTIST_SEARCH_LIMIT = 10
+const MAX_ARTIST_SEARCH_LIMIT = 50
+const MAX_SIMILAR_ARTISTS = 5
 
+func NewArtist(c *gin.Context) {
 	// If we're here, the user is authorized to add an artist.
 
 	var newArtist types.Artist
 	err := c.BindJSON(&newArtist)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Bad JSON Input, could not bind."})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad JSON Input, could not bind."})
 		return
 	}
 
-	unique, err := isNewArtistUnique(newArtist)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	unique, customErr := store.IsArtistUnique(ctx, newArtist.Name)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
@@ -44,132 +41,275 @@ func NewArtist(c *gin.Context) {
 		return
 	}
 
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	id, customErr := store.CreateArtist(ctx, newArtist)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
-	defer db.Close()
 
-	stmt, err := db.Prepare(database.INSERT_NEW_ARTIST)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
+	// Names this close are usually the same artist spelled differently, let the client ask.
+	similar := make([]types.ArtistMatch, 0)
+	matches, customErr := utils.SearchArtists(ctx, store, newArtist.Name, MAX_SIMILAR_ARTISTS+1, utils.NEAR_DUPLICATE_SCORE)
+	if customErr != nil {
+		log.Printf("could not look for artists similar to %s: %s", newArtist.Name, customErr.Description())
 	}
-
-	_, err = stmt.Exec(newArtist.Name, newArtist.DefaultGenre)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
+	for _, match := range matches {
+		if match.Id != id && len(similar) < MAX_SIMILAR_ARTISTS {
+			similar = append(similar, match)
+		}
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"name": newArtist.Name})
+	c.JSON(http.StatusCreated, gin.H{"id": id, "name": newArtist.Name, "similar_artists": similar})
 }
 
-func GetAllArtists(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
+// SearchArtists ranks artists by how closely their names or aliases match q, for autocomplete.
+func SearchArtists(c *gin.Context) {
+	limit, customErr := getPositiveIntQuery(c, "limit")
 	if customErr != nil {
 		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
+	if limit == 0 {
+		limit = DEFAULT_ARTIST_SEARCH_LIMIT
+	}
+	if limit > MAX_ARTIST_SEARCH_LIMIT {
+		limit = MAX_ARTIST_SEARCH_LIMIT
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
 
-	if !auth.IsEntitled(claims, "USER") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("User %s is not entitled to get all artists.", claims.Username)})
+	matches, customErr := utils.SearchArtists(ctx, store, c.Query("q"), limit, utils.MIN_ARTIST_MATCH_SCORE)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	// If we're here, the user is authorized to get all artists.
-
-	artist := types.Artist{}
-	artists := make([]types.Artist, 0)
+	c.JSON(http.StatusOK, gin.H{"artists": matches, "count": len(matches)})
+}
 
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+func GetAllArtists(c *gin.Context) {
+	// If we're here, the user is authorized to get all artists.
+	page, customErr := getPageRequest(c)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
-	defer db.Close()
 
-	rows, err := db.Query(database.GET_ALL_ARTISTS)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	artists, info, customErr := store.GetAllArtists(ctx, page)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	for rows.Next() {
-		err := rows.Scan(&artist.Id, &artist.Name, &artist.DefaultGenre)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	c.JSON(http.StatusOK, gin.H{"artists": artists, "count": len(artists), "total": info.Total, "next_cursor": info.NextCursor})
+
+}
+
+func GetArtist(c *gin.Context) {
+	id := c.Param("id")
+
+	// if we made it here, we're good to go.
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	artist, customErr := store.GetArtist(ctx, id)
+	if customErr != nil && customErr.StatusCode() == http.StatusNotFound {
+		// Artists that were merged away point at the one they were merged into.
+		mergedInto, redirectErr := store.GetArtistRedirect(ctx, id)
+		if redirectErr == nil {
+			c.Redirect(http.StatusMovedPermanently, fmt.Sprintf("/artists/%d", mergedInto))
 			return
 		}
-		artists = append(artists, artist)
 	}
-	defer rows.Close()
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"artists": artists, "count": len(artists)})
+	c.JSON(http.StatusOK, artist)
 
 }
 
-func GetArtist(c *gin.Context) {
+func UpdateArtist(c *gin.Context) {
 	id := c.Param("id")
 
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	artist, customErr := store.GetArtist(ctx, id)
 	if customErr != nil {
 		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	if !auth.IsEntitled(claims, "USER") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to get artist info.", claims.Username)})
+	existingId := artist.Id
+	err := c.BindJSON(&artist)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind artist JSON", "details": err.Error()})
 		return
 	}
+	artist.Id = existingId
 
-	// if we made it here, we're good to go.
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if artist.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include name"})
 		return
 	}
-	defer db.Close()
 
-	result := db.QueryRow(database.GET_SPECIFIC_ARTIST, id)
+	unique, customErr := store.IsArtistUpdateUnique(ctx, artist)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	if !unique {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("artist %s already exists, merge them instead", artist.Name)})
+		return
+	}
+
+	customErr = store.UpdateArtist(ctx, artist)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, artist)
+}
+
+// DeleteArtist refuses to delete an artist that sets reference unless cascade=true is passed,
+// in which case every user's sets of the artist are deleted with it.
+func DeleteArtist(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed getting artist - " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "artist id must be a number"})
 		return
 	}
 
-	var artist types.Artist
+	cascade := c.Query("cascade") == "true"
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	deleted, customErr := store.DeleteArtist(ctx, id, cascade)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "sets_deleted": deleted})
+}
 
-	err = result.Scan(&artist.Id, &artist.Name, &artist.DefaultGenre)
+// MergeArtists folds duplicate artists into the one in the path.
+func MergeArtists(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		// If an entry with the username does not exist, send an "Unauthorized"(401) status
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "artist id must be a number"})
+		return
+	}
+
+	var request struct {
+		ArtistIds []int `json:"artist_ids"`
+	}
+	err = c.BindJSON(&request)
+	if err != nil || len(request.ArtistIds) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include artist_ids to merge"})
+		return
+	}
+
+	seen := map[int]bool{id: true}
+	for _, duplicateId := range request.ArtistIds {
+		if seen[duplicateId] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("artist %d is listed twice or is the artist being merged into", duplicateId)})
 			return
 		}
+		seen[duplicateId] = true
+	}
 
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	merge, customErr := store.MergeArtists(ctx, id, request.ArtistIds)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	c.JSON(http.StatusOK, artist)
+	c.JSON(http.StatusOK, merge)
+}
+
+func GetArtistAliases(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
 
+	artist, customErr := store.GetArtist(ctx, c.Param("id"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	aliases, customErr := store.GetArtistAliases(ctx, artist.Id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"aliases": aliases, "count": len(aliases)})
+}
+
+// NewArtistAlias adds another name the artist can be found by. Names already used by an artist
+// or alias, compared normalized, are refused.
+func NewArtistAlias(c *gin.Context) {
+	var alias types.ArtistAlias
+	err := c.BindJSON(&alias)
+	if err != nil || strings.TrimSpace(alias.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include name"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	artist, customErr := store.GetArtist(ctx, c.Param("id"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	alias.ArtistId = artist.Id
+	alias.CreatedAt = time.Now().Unix()
+	alias.Id, customErr = store.CreateArtistAlias(ctx, alias)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, alias)
 }
 
-func isNewArtistUnique(newArtist types.Artist) (bool, error) {
-	db, err := database.GetConnection()
+func DeleteArtistAlias(c *gin.Context) {
+	artistId, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		return false, err
+		c.JSON(http.StatusBadRequest, gin.H{"error": "artist id must be a number"})
+		return
 	}
-	defer db.Close()
 
-	var count int
-	err = db.QueryRow(database.IS_ARTIST_UNIQUE_QUERY, newArtist.Name).Scan(&count)
+	aliasId, err := strconv.Atoi(c.Param("alias_id"))
 	if err != nil {
-		return false, err
+		c.JSON(http.StatusBadRequest, gin.H{"error": "alias id must be a number"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	customErr := store.DeleteArtistAlias(ctx, artistId, aliasId)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
 	}
 
-	return count == 0, nil
+	c.Status(http.StatusNoContent)
 }
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestArtistCreateAndUpdate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		user := api.user("user", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		runSteps(t, api, []testStep{
+			{name: "create an artist", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "The Chemical Brothers"}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(1), "name": "The Chemical Brothers"}},
+			{name: "the same name again", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "The Chemical Brothers"}, status: http.StatusBadRequest},
+			{name: "create another", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "Odesza"}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(2)}},
+			{name: "bad JSON", method: http.MethodPost, path: "/artists", token: user, body: "not an artist", status: http.StatusBadRequest},
+			{name: "read it", method: http.MethodGet, path: "/artists/2", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"name": "Odesza", "default_genre": ""}},
+			{name: "users can not update artists", method: http.MethodPut, path: "/artists/2", token: user, body: map[string]interface{}{"name": "ODESZA"}, status: http.StatusForbidden},
+			{name: "editors can", method: http.MethodPut, path: "/artists/2", token: editor, body: map[string]interface{}{"name": "ODESZA", "default_genre": "electronic"}, status: http.StatusOK,
+				fields: map[string]interface{}{"id": float64(2), "name": "ODESZA", "default_genre": "electronic"}},
+			{name: "the update is stored", method: http.MethodGet, path: "/artists/2", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"name": "ODESZA", "default_genre": "electronic"}},
+			{name: "the id in the body is ignored", method: http.MethodPut, path: "/artists/2", token: editor, body: map[string]interface{}{"id": 1, "name": "ODESZA"}, status: http.StatusOK,
+				fields: map[string]interface{}{"id": float64(2)}},
+			{name: "the other artist is untouched", method: http.MethodGet, path: "/artists/1", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"name": "The Chemical Brothers"}},
+			{name: "renaming onto another artist", method: http.MethodPut, path: "/artists/2", token: editor, body: map[string]interface{}{"name": "The Chemical Brothers"}, status: http.StatusConflict},
+			{name: "renaming to nothing", method: http.MethodPut, path: "/artists/2", token: editor, body: map[string]interface{}{"name": ""}, status: http.StatusBadRequest},
+			{name: "updating a missing artist", method: http.MethodPut, path: "/artists/99", token: editor, body: map[string]interface{}{"name": "Nobody"}, status: http.StatusNotFound},
+			{name: "reading a missing artist", method: http.MethodGet, path: "/artists/99", token: user, status: http.StatusNotFound},
+		})
+	})
+}
+
+func TestArtistDeleteAndMerge(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		ida := api.user("ida", "USER")
+		eve := api.user("eve", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		for _, name := range []string{"ODESZA", "ODESZA (DJ Set)", "Odesza Live", "Bonobo", "Caribou"} {
+			api.mustRequest(http.MethodPost, "/artists", editor, map[string]interface{}{"name": name}, http.StatusCreated)
+		}
+		api.mustRequest(http.MethodPost, "/locations", editor, map[string]interface{}{"name": "Red Rocks", "city": "Morrison"}, http.StatusCreated)
+
+		// Ida saved the same show under two spellings, Eve under a third.
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 1, "location_id": 1, "date": "2019-06-14"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 2, "location_id": 1, "date": "2019-06-14"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", eve, map[string]interface{}{"artist_id": 3, "location_id": 1, "date": "2019-06-14"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 4, "location_id": 1, "date": "2020-08-01"}, http.StatusCreated)
+
+		runSteps(t, api, []testStep{
+			{name: "users can not merge artists", method: http.MethodPost, path: "/artists/1/merge", token: ida, body: map[string]interface{}{"artist_ids": []int{2, 3}}, status: http.StatusForbidden},
+			{name: "nothing to merge", method: http.MethodPost, path: "/artists/1/merge", token: editor, body: map[string]interface{}{"artist_ids": []int{}}, status: http.StatusBadRequest},
+			{name: "an artist into itself", method: http.MethodPost, path: "/artists/1/merge", token: editor, body: map[string]interface{}{"artist_ids": []int{1}}, status: http.StatusBadRequest},
+			{name: "an artist twice", method: http.MethodPost, path: "/artists/1/merge", token: editor, body: map[string]interface{}{"artist_ids": []int{2, 2}}, status: http.StatusBadRequest},
+			{name: "a missing artist", method: http.MethodPost, path: "/artists/1/merge", token: editor, body: map[string]interface{}{"artist_ids": []int{2, 99}}, status: http.StatusNotFound},
+			{name: "which merged nothing", method: http.MethodGet, path: "/artists/2", token: ida, status: http.StatusOK},
+			{name: "merge", method: http.MethodPost, path: "/artists/1/merge", token: editor, body: map[string]interface{}{"artist_ids": []int{2, 3}}, status: http.StatusOK,
+				fields: map[string]interface{}{"artist_id": float64(1), "sets_moved": float64(1), "duplicate_sets_removed": float64(1)}},
+			{name: "merged artists redirect", method: http.MethodGet, path: "/artists/3", token: ida, status: http.StatusMovedPermanently},
+			{name: "ida has one set of the show", method: http.MethodGet, path: "/sets?artist_id=1", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(1)}},
+			{name: "eve's set moved", method: http.MethodGet, path: "/sets/3", token: eve, status: http.StatusOK,
+				fields: map[string]interface{}{"artist_id": float64(1)}},
+
+			{name: "users can not delete artists", method: http.MethodDelete, path: "/artists/5", token: ida, status: http.StatusForbidden},
+			{name: "an artist without sets", method: http.MethodDelete, path: "/artists/5", token: editor, status: http.StatusOK,
+				fields: map[string]interface{}{"sets_deleted": float64(0)}},
+			{name: "is gone", method: http.MethodGet, path: "/artists/5", token: ida, status: http.StatusNotFound},
+			{name: "an artist with sets", method: http.MethodDelete, path: "/artists/4", token: editor, status: http.StatusConflict},
+			{name: "keeps them", method: http.MethodGet, path: "/sets/4", token: ida, status: http.StatusOK},
+			{name: "unless they go too", method: http.MethodDelete, path: "/artists/4?cascade=true", token: editor, status: http.StatusOK,
+				fields: map[string]interface{}{"sets_deleted": float64(1)}},
+			{name: "the set is gone", method: http.MethodGet, path: "/sets/4", token: ida, status: http.StatusNotFound},
+			{name: "a missing artist", method: http.MethodDelete, path: "/artists/99", token: editor, status: http.StatusNotFound},
+		})
+	})
+}
+
+func TestArtistNames(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		user := api.user("user", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		api.mustRequest(http.MethodPost, "/artists", user, map[string]interface{}{"name": "The Chemical Brothers"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/artists", user, map[string]interface{}{"name": "Simon & Garfunkel"}, http.StatusCreated)
+
+		runSteps(t, api, []testStep{
+			{name: "without The, in lower case", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "chemical brothers"}, status: http.StatusBadRequest},
+			{name: "with diacritics", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "Chemical Bröthers"}, status: http.StatusBadRequest},
+			{name: "with and for &", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "Simon and Garfunkel"}, status: http.StatusBadRequest},
+			{name: "with punctuation", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "Simon & Garfunkel!"}, status: http.StatusBadRequest},
+			{name: "The alone is a name", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "The"}, status: http.StatusCreated},
+			{name: "renaming onto a spelling of another artist", method: http.MethodPut, path: "/artists/2", token: editor, body: map[string]interface{}{"name": "chemical brothers"}, status: http.StatusConflict},
+			{name: "respelling an artist's own name", method: http.MethodPut, path: "/artists/2", token: editor, body: map[string]interface{}{"name": "Simon and Garfunkel"}, status: http.StatusOK,
+				fields: map[string]interface{}{"name": "Simon and Garfunkel"}},
+		})
+
+		// A new name close to an existing one is created, but the client is told about it.
+		created := api.mustRequest(http.MethodPost, "/artists", user, map[string]interface{}{"name": "Chemical Brother"}, http.StatusCreated)
+		similar := created["similar_artists"].([]interface{})
+		if len(similar) != 1 || similar[0].(map[string]interface{})["name"] != "The Chemical Brothers" {
+			t.Fatalf("similar artists are %v, expected The Chemical Brothers", similar)
+		}
+
+		created = api.mustRequest(http.MethodPost, "/artists", user, map[string]interface{}{"name": "Bonobo"}, http.StatusCreated)
+		if similar := created["similar_artists"].([]interface{}); len(similar) != 0 {
+			t.Fatalf("similar artists are %v, expected none", similar)
+		}
+	})
+}
+
+func TestArtistAliases(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		user := api.user("user", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		api.mustRequest(http.MethodPost, "/artists", user, map[string]interface{}{"name": "Kanye West"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/artists", user, map[string]interface{}{"name": "Prince"}, http.StatusCreated)
+
+		runSteps(t, api, []testStep{
+			{name: "users can not add aliases", method: http.MethodPost, path: "/artists/1/aliases", token: user, body: map[string]interface{}{"name": "Ye"}, status: http.StatusForbidden},
+			{name: "editors can", method: http.MethodPost, path: "/artists/1/aliases", token: editor, body: map[string]interface{}{"name": "Ye"}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(1), "artist_id": float64(1), "name": "Ye"}},
+			{name: "an alias without a name", method: http.MethodPost, path: "/artists/1/aliases", token: editor, body: map[string]interface{}{"name": " "}, status: http.StatusBadRequest},
+			{name: "an alias of a missing artist", method: http.MethodPost, path: "/artists/99/aliases", token: editor, body: map[string]interface{}{"name": "Yeezy"}, status: http.StatusNotFound},
+			{name: "an alias another artist has", method: http.MethodPost, path: "/artists/2/aliases", token: editor, body: map[string]interface{}{"name": "ye"}, status: http.StatusConflict},
+			{name: "an alias that is another artist's name", method: http.MethodPost, path: "/artists/1/aliases", token: editor, body: map[string]interface{}{"name": "PRINCE"}, status: http.StatusConflict},
+			{name: "an artist named like an alias", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "Ye"}, status: http.StatusBadRequest},
+			{name: "renaming onto an alias", method: http.MethodPut, path: "/artists/2", token: editor, body: map[string]interface{}{"name": "Ye"}, status: http.StatusConflict},
+			{name: "another alias", method: http.MethodPost, path: "/artists/2/aliases", token: editor, body: map[string]interface{}{"name": "The Artist Formerly Known as Prince"}, status: http.StatusCreated},
+			{name: "list them", method: http.MethodGet, path: "/artists/1/aliases", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(1)}},
+			{name: "from another artist", method: http.MethodDelete, path: "/artists/1/aliases/2", token: editor, status: http.StatusNotFound},
+			{name: "users can not delete them", method: http.MethodDelete, path: "/artists/1/aliases/1", token: user, status: http.StatusForbidden},
+			{name: "delete it", method: http.MethodDelete, path: "/artists/1/aliases/1", token: editor, status: http.StatusNoContent},
+			{name: "it is gone", method: http.MethodGet, path: "/artists/1/aliases", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(0)}},
+			{name: "so is the name", method: http.MethodPost, path: "/artists", token: user, body: map[string]interface{}{"name": "Ye"}, status: http.StatusCreated},
+			{name: "the other artist's is not", method: http.MethodGet, path: "/artists/2/aliases", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(1)}},
+		})
+	})
+}
+
+func TestArtistSearch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		user := api.user("user", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		for _, name := range []string{"ODESZA", "Odette", "Bonobo", "Sigur Rós", "Kanye West"} {
+			api.mustRequest(http.MethodPost, "/artists", user, map[string]interface{}{"name": name}, http.StatusCreated)
+		}
+		api.mustRequest(http.MethodPost, "/artists/5/aliases", editor, map[string]interface{}{"name": "Ye"}, http.StatusCreated)
+
+		tests := []struct {
+			query   string
+			matches []string
+			// matched is the name the first match was found by.
+			matched string
+		}{
+			{query: "ode", matches: []string{"ODESZA", "Odette"}, matched: "ODESZA"},
+			{query: "odesza", matches: []string{"ODESZA"}, matched: "ODESZA"},
+			{query: "Odeza", matches: []string{"ODESZA"}, matched: "ODESZA"},
+			{query: "sigur ros", matches: []string{"Sigur Rós"}, matched: "Sigur Rós"},
+			{query: "ros", matches: []string{"Sigur Rós"}, matched: "Sigur Rós"},
+			{query: "ye", matches: []string{"Kanye West"}, matched: "Ye"},
+			{query: "radiohead", matches: []string{}},
+		}
+
+		for _, test := range tests {
+			response := api.mustRequest(http.MethodGet, "/artists/search?q="+url.QueryEscape(test.query), user, nil, http.StatusOK)
+			found := response["artists"].([]interface{})
+			names := make([]string, 0, len(found))
+			for _, artist := range found {
+				names = append(names, artist.(map[string]interface{})["name"].(string))
+			}
+			if strings.Join(names, ",") != strings.Join(test.matches, ",") {
+				t.Fatalf("searching %q found %v, expected %v", test.query, names, test.matches)
+			}
+			if len(found) > 0 && found[0].(map[string]interface{})["matched_name"] != test.matched {
+				t.Fatalf("searching %q matched %v, expected %s", test.query, found[0].(map[string]interface{})["matched_name"], test.matched)
+			}
+		}
+
+		runSteps(t, api, []testStep{
+			{name: "limited", method: http.MethodGet, path: "/artists/search?q=ode&limit=1", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(1)}},
+			{name: "without a query", method: http.MethodGet, path: "/artists/search", token: user, status: http.StatusBadRequest},
+			{name: "with only punctuation", method: http.MethodGet, path: "/artists/search?q=%20", token: user, status: http.StatusBadRequest},
+			{name: "with a bad limit", method: http.MethodGet, path: "/artists/search?q=ode&limit=-1", token: user, status: http.StatusBadRequest},
+		})
+	})
+}
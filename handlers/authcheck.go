@@ -7,11 +7,5 @@ import (
 )
 
 func AuthCheck(c *gin.Context) {
-	claims, err := auth.GetUserInfo(c)
-	if err != nil {
-		c.JSON(err.StatusCode(), gin.H{"error": err.Description()})
-		return
-	}
-
-	c.JSON(http.StatusOK, claims)
+	c.JSON(http.StatusOK, auth.GetClaims(c))
 }
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/AnthonyNixon/setsisaw/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+func NewFestival(c *gin.Context) {
+	var newFestival types.Festival
+	err := c.BindJSON(&newFestival)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad JSON Input, could not bind."})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	newFestival, customErr := utils.NewFestival(ctx, store, newFestival)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, newFestival)
+}
+
+func GetAllFestivals(c *gin.Context) {
+	page, customErr := getPageRequest(c)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	festivals, info, customErr := store.GetAllFestivals(ctx, page)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"festivals": festivals, "count": len(festivals), "total": info.Total, "next_cursor": info.NextCursor})
+}
+
+func GetFestival(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	festival, customErr := store.GetFestival(ctx, c.Param("id"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, festival)
+}
+
+func UpdateFestival(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	festival, customErr := store.GetFestival(ctx, c.Param("id"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	existingId := festival.Id
+	err := c.BindJSON(&festival)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind festival JSON", "details": err.Error()})
+		return
+	}
+	festival.Id = existingId
+
+	customErr = utils.UpdateFestival(ctx, store, festival)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, festival)
+}
+
+// GetFestivalEditions lists every year of the festival, oldest first.
+func GetFestivalEditions(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	festival, customErr := store.GetFestival(ctx, c.Param("id"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	editions, customErr := store.GetFestivalEditions(ctx, festival.Id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"festival": festival, "editions": editions, "count": len(editions)})
+}
+
+func NewFestivalEdition(c *gin.Context) {
+	var edition types.Location
+	err := c.BindJSON(&edition)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad JSON Input, could not bind."})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	festival, customErr := store.GetFestival(ctx, c.Param("id"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	edition, customErr = utils.NewEdition(ctx, store, store, festival, edition)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, edition)
+}
+
+// GetFestivalSets lists the sets the caller saw across every edition of the festival, or with
+// user_id and sets:read:any, the sets someone else saw. The usual set filters apply.
+func GetFestivalSets(c *gin.Context) {
+	claims := auth.GetClaims(c)
+
+	filter, customErr := getSetFilter(c)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	if filter.UserId == 0 || strconv.Itoa(filter.UserId) == claims.Id {
+		userId, err := strconv.Atoi(claims.Id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not convert user_id to int, " + err.Error()})
+			return
+		}
+		filter.UserId = userId
+	} else if !auth.HasPermission(claims, auth.PERMISSION_SETS_READ_ANY) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("UserId %s is not entitled to view the sets of user %d.", claims.Username, filter.UserId)})
+		return
+	}
+
+	page, customErr := getPageRequest(c)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	festival, customErr := store.GetFestival(ctx, c.Param("id"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+	filter.FestivalId = festival.Id
+
+	sets, info, customErr := store.ListSets(ctx, filter, page)
+	sendSets(sets, info, customErr, c)
+}
@@ -0,0 +1,11 @@
+package handlers
+
+import (
+	"github.com/AnthonyNixon/setsisaw/database"
+)
+
+var store database.Store
+
+func Initialize(s database.Store) {
+	store = s
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/mail"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/AnthonyNixon/setsisaw/users"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPassword is the password of every user created by testApi.user.
+const testPassword = "correct horse battery staple"
+
+func TestMain(m *testing.M) {
+	os.Setenv("JWT_SIGNING_KEY", "test-signing-key")
+	os.Setenv("SETSISAW_BCRYPT_COST", "4")
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// testBackends are the stores every handler test runs against, so the memory store is held to
+// the behaviour of the SQL store.
+var testBackends = []struct {
+	name string
+	open func(t *testing.T) database.Store
+}{
+	{"memory", func(t *testing.T) database.Store { return database.NewMemoryStore() }},
+	{"sqlite", openTestSQLite},
+}
+
+func openTestSQLite(t *testing.T) database.Store {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "setsisaw.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store := database.NewSQLStore(db, "sqlite")
+	_, err = database.Migrate(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// testMailer hands every message sent to the test instead of delivering it.
+type testMailer chan mail.Message
+
+func (m testMailer) Send(ctx context.Context, message mail.Message) error {
+	m <- message
+	return nil
+}
+
+// testApi serves the routes from main on a fresh store.
+type testApi struct {
+	t      *testing.T
+	router *gin.Engine
+	store  database.Store
+	mail   testMailer
+}
+
+// forEachBackend runs test once for every store in testBackends.
+func forEachBackend(t *testing.T, test func(t *testing.T, api *testApi)) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, newTestApi(t, backend.open(t)))
+		})
+	}
+}
+
+func newTestApi(t *testing.T, store database.Store) *testApi {
+	api := &testApi{t: t, store: store, mail: make(testMailer, 100)}
+	auth.Initialize(store, api.mail)
+	users.Initialize(store)
+	Initialize(store)
+
+	router := gin.New()
+	public := router.Group("/")
+	authed := router.Group("/", auth.RequireAuth())
+
+	public.POST("/signup", users.SignUp)
+	public.POST("/signin", users.SignIn)
+	public.POST("/signin/2fa", users.SignInTwoFactor)
+	public.POST("/refresh", RefreshToken)
+	public.POST("/password/forgot", users.ForgotPassword)
+	public.POST("/password/reset", users.ResetPassword)
+	public.GET("/verify-email", users.VerifyEmail)
+	public.POST("/verify-email/resend", users.ResendEmailVerification)
+
+	authed.POST("/signout", users.SignOut)
+	authed.GET("/authcheck", AuthCheck)
+
+	authed.GET("/user/current", GetCurrentUser)
+	authed.PUT("/users", auth.RequireInteractiveSession(), UpdateUser)
+	authed.PUT("/users/:id/role", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), SetUserRole)
+
+	authed.PUT("/user/current/password", auth.RequireInteractiveSession(), users.ChangePassword)
+	authed.POST("/user/current/2fa", auth.RequireInteractiveSession(), EnrollTwoFactor)
+	authed.POST("/user/current/2fa/confirm", auth.RequireInteractiveSession(), ConfirmTwoFactor)
+	authed.POST("/user/current/2fa/recovery-codes", auth.RequireInteractiveSession(), RegenerateRecoveryCodes)
+	authed.DELETE("/user/current/2fa", auth.RequireInteractiveSession(), DisableTwoFactor)
+	authed.POST("/user/current/tokens", auth.RequireInteractiveSession(), CreateApiToken)
+	authed.GET("/user/current/tokens", GetCurrentUserApiTokens)
+	authed.DELETE("/user/current/tokens/:id", RevokeCurrentUserApiToken)
+	authed.GET("/settings/2fa", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), GetTwoFactorSettings)
+	authed.PUT("/settings/2fa", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), SetTwoFactorSettings)
+	authed.GET("/lockouts", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), GetLockouts)
+	authed.DELETE("/lockouts/:kind/:value", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), ClearLockout)
+
+	authed.GET("/user/current/sessions", auth.RequireInteractiveSession(), GetCurrentUserSessions)
+	authed.DELETE("/user/current/sessions/:id", auth.RequireInteractiveSession(), RevokeCurrentUserSession)
+	authed.DELETE("/users/:id/sessions", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), RevokeUserSessions)
+
+	authed.POST("/artists", auth.RequirePermission(auth.PERMISSION_ARTISTS_CREATE), auth.RequireVerifiedEmail(), NewArtist)
+	authed.GET("/artists", auth.RequirePermission(auth.PERMISSION_ARTISTS_READ), GetAllArtists)
+	authed.GET("/artists/search", auth.RequirePermission(auth.PERMISSION_ARTISTS_READ), SearchArtists)
+	authed.GET("/artists/:id", auth.RequirePermission(auth.PERMISSION_ARTISTS_READ), GetArtist)
+	authed.PUT("/artists/:id", auth.RequirePermission(auth.PERMISSION_ARTISTS_UPDATE), UpdateArtist)
+	authed.DELETE("/artists/:id", auth.RequirePermission(auth.PERMISSION_ARTISTS_DELETE), DeleteArtist)
+	authed.POST("/artists/:id/merge", auth.RequirePermission(auth.PERMISSION_ARTISTS_MERGE), MergeArtists)
+	authed.GET("/artists/:id/aliases", auth.RequirePermission(auth.PERMISSION_ARTISTS_READ), GetArtistAliases)
+	authed.POST("/artists/:id/aliases", auth.RequirePermission(auth.PERMISSION_ARTISTS_UPDATE), NewArtistAlias)
+	authed.DELETE("/artists/:id/aliases/:alias_id", auth.RequirePermission(auth.PERMISSION_ARTISTS_UPDATE), DeleteArtistAlias)
+
+	authed.POST("/locations", auth.RequirePermission(auth.PERMISSION_LOCATIONS_CREATE), auth.RequireVerifiedEmail(), NewLocation)
+	authed.GET("/locations", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), GetAllLocations)
+	authed.GET("/locations/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), GetLocation)
+	authed.PUT("/locations/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), UpdateLocation)
+	authed.DELETE("/locations/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_DELETE), DeleteLocation)
+	authed.POST("/locations/:id/merge", auth.RequirePermission(auth.PERMISSION_LOCATIONS_MERGE), MergeLocations)
+	authed.GET("/locations/:id/stages", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), GetStages)
+	authed.POST("/locations/:id/stages", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), NewStage)
+	authed.PUT("/locations/:id/stages/:stage_id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), UpdateStage)
+	authed.DELETE("/locations/:id/stages/:stage_id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), DeleteStage)
+
+	authed.POST("/festivals", auth.RequirePermission(auth.PERMISSION_LOCATIONS_CREATE), auth.RequireVerifiedEmail(), NewFestival)
+	authed.GET("/festivals", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), GetAllFestivals)
+	authed.GET("/festivals/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), GetFestival)
+	authed.PUT("/festivals/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), UpdateFestival)
+	authed.GET("/festivals/:id/editions", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), GetFestivalEditions)
+	authed.POST("/festivals/:id/editions", auth.RequirePermission(auth.PERMISSION_LOCATIONS_CREATE), auth.RequireVerifiedEmail(), NewFestivalEdition)
+	authed.GET("/festivals/:id/sets", auth.RequirePermission(auth.PERMISSION_SETS_READ), GetFestivalSets)
+
+	authed.POST("/sets", auth.RequirePermission(auth.PERMISSION_SETS_WRITE), NewSet)
+	authed.GET("/sets", auth.RequirePermission(auth.PERMISSION_SETS_READ), GetSetsForCurrentUser)
+	authed.GET("/sets/all", auth.RequirePermission(auth.PERMISSION_SETS_READ_ANY), GetAllSets)
+	authed.GET("/sets/:id", GetSet)
+	authed.PUT("/sets/:id", UpdateSet)
+	authed.DELETE("/sets/:id", DeleteSet)
+
+	api.router = router
+	return api
+}
+
+// user creates a verified user with role and testPassword, signs them in and returns their
+// access token.
+func (api *testApi) user(username string, role string) string {
+	ctx := context.Background()
+	hash, err := auth.HashPassword(testPassword)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+
+	id, customErr := api.store.CreateUser(ctx, types.User{Username: username, Email: username + "@example.com", Password: hash})
+	if customErr != nil {
+		api.t.Fatalf("could not create user %s: %s", username, customErr.Description())
+	}
+
+	customErr = api.store.UpdateUser(ctx, types.User{Id: strconv.Itoa(id), Username: username, Email: username + "@example.com", EmailVerified: true})
+	if customErr != nil {
+		api.t.Fatalf("could not verify the email of %s: %s", username, customErr.Description())
+	}
+
+	if role != auth.ROLE_USER {
+		customErr = api.store.ChangeUserRole(ctx, types.RoleChange{UserId: id, ChangedBy: id, OldRole: auth.ROLE_USER, NewRole: role})
+		if customErr != nil {
+			api.t.Fatalf("could not make %s an %s: %s", username, role, customErr.Description())
+		}
+	}
+
+	user, customErr := api.store.GetUser(ctx, strconv.Itoa(id))
+	if customErr != nil {
+		api.t.Fatalf("could not read user %s: %s", username, customErr.Description())
+	}
+
+	token, _, customErr := auth.StartSession(ctx, user, auth.Device{})
+	if customErr != nil {
+		api.t.Fatalf("could not sign in %s: %s", username, customErr.Description())
+	}
+
+	return token
+}
+
+// serve sends body as JSON with token as the bearer token, and returns the recorded response.
+func (api *testApi) serve(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+	}
+
+	request := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	api.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// request is serve for JSON APIs, returning the status and the decoded response.
+func (api *testApi) request(method string, path string, token string, body interface{}) (int, map[string]interface{}) {
+	recorder := api.serve(method, path, token, body)
+
+	response := map[string]interface{}{}
+	if strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		if err != nil {
+			api.t.Fatalf("%s %s returned invalid JSON %q", method, path, recorder.Body.String())
+		}
+	}
+
+	return recorder.Code, response
+}
+
+// mustRequest is request for setup steps, failing the test unless the status is status.
+func (api *testApi) mustRequest(method string, path string, token string, body interface{}, status int) map[string]interface{} {
+	api.t.Helper()
+	got, response := api.request(method, path, token, body)
+	if got != status {
+		api.t.Fatalf("%s %s returned %d %v, expected %d", method, path, got, response, status)
+	}
+
+	return response
+}
+
+// mailedToken waits for the next email and returns the token in the paragraph after the
+// instructions for using it, failing unless it was sent to to.
+func (api *testApi) mailedToken(to string) string {
+	api.t.Helper()
+	select {
+	case message := <-api.mail:
+		if message.To != to {
+			api.t.Fatalf("%q was mailed to %s, expected %s", message.Subject, message.To, to)
+		}
+
+		paragraphs := strings.Split(message.Body, "\n\n")
+		for i := 0; i < len(paragraphs)-1; i++ {
+			if strings.HasSuffix(paragraphs[i], ":") {
+				return strings.TrimSpace(paragraphs[i+1])
+			}
+		}
+
+		api.t.Fatalf("%q has no token", message.Subject)
+		return ""
+	case <-time.After(5 * time.Second):
+		api.t.Fatalf("no email was sent to %s", to)
+		return ""
+	}
+}
+
+// testStep is one request of a table driven test, checked against the expected status and,
+// when set, the expected values of top level response fields.
+type testStep struct {
+	name   string
+	method string
+	path   string
+	token  string
+	body   interface{}
+	status int
+	fields map[string]interface{}
+}
+
+func runSteps(t *testing.T, api *testApi, steps []testStep) {
+	t.Helper()
+	for _, step := range steps {
+		status, response := api.request(step.method, step.path, step.token, step.body)
+		if status != step.status {
+			t.Fatalf("%s: %s %s returned %d %v, expected %d", step.name, step.method, step.path, status, response, step.status)
+		}
+
+		for field, expected := range step.fields {
+			if response[field] != expected {
+				t.Fatalf("%s: %s was %v, expected %v", step.name, field, response[field], expected)
+			}
+		}
+	}
+}
+
+// signIn signs username in through /signin with testPassword and returns the access and
+// refresh tokens.
+func (api *testApi) signIn(username string) (string, string) {
+	api.t.Helper()
+	response := api.mustRequest(http.MethodPost, "/signin", "", map[string]interface{}{"username": username, "password": testPassword}, http.StatusOK)
+	return response["token"].(string), response["refresh_token"].(string)
+}
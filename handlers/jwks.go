@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": auth.JWKS()})
+}
@@ -1,39 +1,30 @@
 package handlers
 
 import (
-	"fmt"
-	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/database"
 	"github.com/AnthonyNixon/setsisaw/types"
 	"github.com/AnthonyNixon/setsisaw/utils"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
 )
 
 func NewLocation(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
-	if customErr != nil {
-		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
-		return
-	}
-
-	if !auth.IsEntitled(claims, "EDITOR") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to add a location.", claims.Username)})
-		return
-	}
-
 	// If we're here, the user is authorized to add an artist.
 
 	var newLocation types.Location
 	err := c.BindJSON(&newLocation)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Bad JSON Input, could not bind."})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad JSON Input, could not bind."})
 		return
 	}
 
-	customErr = utils.NewLocation(newLocation)
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	newLocation, customErr := utils.NewLocation(ctx, store, store, newLocation)
 	if customErr != nil {
-		c.JSON(customErr.StatusCode(), customErr.Description())
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
@@ -41,47 +32,34 @@ func NewLocation(c *gin.Context) {
 }
 
 func GetAllLocations(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
+	// If we're here, the user is authorized to get all artists.
+	page, customErr := getPageRequest(c)
 	if customErr != nil {
 		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	if !auth.IsEntitled(claims, "USER") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("User %s is not entitled to get all locations.", claims.Username)})
-		return
-	}
-
-	// If we're here, the user is authorized to get all artists.
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
 
-	locations, customErr := utils.GetAllLocations()
+	locations, info, customErr := utils.GetAllLocations(ctx, store, page)
 	if customErr != nil {
-		c.JSON(customErr.StatusCode(), customErr.Description())
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"locations": locations, "count": len(locations)})
+	c.JSON(http.StatusOK, gin.H{"locations": locations, "count": len(locations), "total": info.Total, "next_cursor": info.NextCursor})
 
 }
 
 func GetLocation(c *gin.Context) {
 	id := c.Param("id")
 
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
-	if customErr != nil {
-		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
-		return
-	}
-
-	if !auth.IsEntitled(claims, "USER") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to get location info.", claims.Username)})
-		return
-	}
-
 	// if we made it here, we're good to go.
-	location, err := utils.GetLocation(id)
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	location, err := utils.GetLocation(ctx, store, id)
 	if err != nil {
 		c.JSON(err.StatusCode(), gin.H{"error": err.Description()})
 		return
@@ -94,36 +72,79 @@ func GetLocation(c *gin.Context) {
 func UpdateLocation(c *gin.Context) {
 	id := c.Param("id")
 
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	location, customErr := utils.GetLocation(ctx, store, id)
 	if customErr != nil {
 		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	if !auth.IsEntitled(claims, "EDITOR") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("User %s is not entitled to edit location info.", claims.Username)})
+	err := c.BindJSON(&location)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind location JSON", "details": err.Error()})
 		return
 	}
 
-	location, customErr := utils.GetLocation(id)
+	// If we're here, the user is authorized to edit location information
+	location, customErr = utils.UpdateLocation(ctx, store, store, id, location)
 	if customErr != nil {
 		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	err := c.BindJSON(&location)
+	c.JSON(http.StatusOK, location)
+}
+
+// DeleteLocation refuses to delete a location that sets reference unless cascade=true is passed,
+// in which case every user's sets there are deleted with it.
+func DeleteLocation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind location JSON", "details": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "location id must be a number"})
 		return
 	}
 
-	// If we're here, the user is authorized to edit location information
-	customErr = utils.UpdateLocation(id, location)
+	cascade := c.Query("cascade") == "true"
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	deleted, customErr := utils.DeleteLocation(ctx, store, id, cascade)
 	if customErr != nil {
-		c.JSON(customErr.StatusCode(), customErr.Description())
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	c.JSON(http.StatusOK, location)
+	c.JSON(http.StatusOK, gin.H{"id": id, "sets_deleted": deleted})
+}
+
+// MergeLocations folds duplicate locations into the one in the path.
+func MergeLocations(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "location id must be a number"})
+		return
+	}
+
+	var request struct {
+		LocationIds []int `json:"location_ids"`
+	}
+	err = c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include location_ids to merge"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	merge, customErr := utils.MergeLocations(ctx, store, id, request.LocationIds)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, merge)
 }
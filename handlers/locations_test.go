@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestLocationCreateAndUpdate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		user := api.user("user", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		runSteps(t, api, []testStep{
+			{name: "users can not create locations", method: http.MethodPost, path: "/locations", token: user, body: map[string]interface{}{"name": "Red Rocks"}, status: http.StatusForbidden},
+			{name: "create a venue", method: http.MethodPost, path: "/locations", token: editor, body: map[string]interface{}{"name": "Red Rocks", "city": "Morrison", "state": "CO"}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(1), "name": "Red Rocks", "city": "Morrison"}},
+			{name: "the same venue again", method: http.MethodPost, path: "/locations", token: editor, body: map[string]interface{}{"name": "Red Rocks", "city": "Morrison", "state": "CO"}, status: http.StatusConflict},
+			{name: "create a festival edition", method: http.MethodPost, path: "/locations", token: editor,
+				body:   map[string]interface{}{"name": "Bonnaroo 2019", "is_festival": true, "year": 2019, "start_date": "2019-06-13", "end_date": "2019-06-16"},
+				status: http.StatusCreated, fields: map[string]interface{}{"id": float64(2), "festival_id": float64(1)}},
+			{name: "dates outside its year", method: http.MethodPost, path: "/locations", token: editor,
+				body: map[string]interface{}{"name": "Bonnaroo 2020", "is_festival": true, "year": 2020, "start_date": "2019-06-13"}, status: http.StatusBadRequest},
+			{name: "read it", method: http.MethodGet, path: "/locations/2", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"name": "Bonnaroo 2019", "year": float64(2019), "start_date": "2019-06-13"}},
+			{name: "users can not update locations", method: http.MethodPut, path: "/locations/1", token: user, body: map[string]interface{}{"city": "Denver"}, status: http.StatusForbidden},
+			{name: "editors can", method: http.MethodPut, path: "/locations/1", token: editor, body: map[string]interface{}{"city": "Denver"}, status: http.StatusOK,
+				fields: map[string]interface{}{"id": float64(1), "name": "Red Rocks", "city": "Denver"}},
+			{name: "the update is stored", method: http.MethodGet, path: "/locations/1", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"city": "Denver", "state": "CO"}},
+			{name: "another location's id in the body", method: http.MethodPut, path: "/locations/1", token: editor, body: map[string]interface{}{"id": 2, "name": "Hijacked"}, status: http.StatusBadRequest},
+			{name: "the other location is untouched", method: http.MethodGet, path: "/locations/2", token: user, status: http.StatusOK,
+				fields: map[string]interface{}{"name": "Bonnaroo 2019"}},
+			{name: "renaming onto another location", method: http.MethodPut, path: "/locations/1", token: editor,
+				body: map[string]interface{}{"name": "Bonnaroo 2019", "is_festival": true, "year": 2019, "city": "", "state": ""}, status: http.StatusConflict},
+			{name: "updating a missing location", method: http.MethodPut, path: "/locations/99", token: editor, body: map[string]interface{}{"name": "Nowhere"}, status: http.StatusNotFound},
+		})
+	})
+}
+
+func TestLocationDeleteAndMerge(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		ida := api.user("ida", "USER")
+		eve := api.user("eve", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		api.mustRequest(http.MethodPost, "/artists", editor, map[string]interface{}{"name": "ODESZA"}, http.StatusCreated)
+		for _, location := range []map[string]interface{}{
+			{"name": "Red Rocks", "city": "Morrison"},
+			{"name": "Red Rocks Amphitheatre", "city": "Morrison"},
+			{"name": "Red Rocks Park", "city": "Morrison"},
+			{"name": "The Gorge", "city": "George"},
+			{"name": "Bonnaroo 2019", "is_festival": true, "year": 2019},
+			{"name": "The Fillmore", "city": "Denver"},
+		} {
+			api.mustRequest(http.MethodPost, "/locations", editor, location, http.StatusCreated)
+		}
+
+		// Ida saved the same show at two spellings of the venue, Eve at a third.
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 1, "location_id": 1, "date": "2019-06-14"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 1, "location_id": 2, "date": "2019-06-14"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", eve, map[string]interface{}{"artist_id": 1, "location_id": 3, "date": "2019-06-14"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 1, "location_id": 4, "date": "2020-08-01"}, http.StatusCreated)
+
+		runSteps(t, api, []testStep{
+			{name: "users can not merge locations", method: http.MethodPost, path: "/locations/1/merge", token: ida, body: map[string]interface{}{"location_ids": []int{2, 3}}, status: http.StatusForbidden},
+			{name: "nothing to merge", method: http.MethodPost, path: "/locations/1/merge", token: editor, body: map[string]interface{}{"location_ids": []int{}}, status: http.StatusBadRequest},
+			{name: "a location into itself", method: http.MethodPost, path: "/locations/1/merge", token: editor, body: map[string]interface{}{"location_ids": []int{1}}, status: http.StatusBadRequest},
+			{name: "a location twice", method: http.MethodPost, path: "/locations/1/merge", token: editor, body: map[string]interface{}{"location_ids": []int{2, 2}}, status: http.StatusBadRequest},
+			{name: "a missing location", method: http.MethodPost, path: "/locations/1/merge", token: editor, body: map[string]interface{}{"location_ids": []int{2, 99}}, status: http.StatusNotFound},
+			{name: "a festival into a venue", method: http.MethodPost, path: "/locations/1/merge", token: editor, body: map[string]interface{}{"location_ids": []int{2, 5}}, status: http.StatusBadRequest,
+				fields: map[string]interface{}{"error": "location 1 and location 5 are not both festivals, they can not be merged"}},
+			{name: "a venue into a festival", method: http.MethodPost, path: "/locations/5/merge", token: editor, body: map[string]interface{}{"location_ids": []int{1}}, status: http.StatusBadRequest},
+			{name: "which merged nothing", method: http.MethodGet, path: "/locations/2", token: ida, status: http.StatusOK},
+			{name: "merge", method: http.MethodPost, path: "/locations/1/merge", token: editor, body: map[string]interface{}{"location_ids": []int{2, 3}}, status: http.StatusOK,
+				fields: map[string]interface{}{"location_id": float64(1), "sets_moved": float64(1), "duplicate_sets_removed": float64(1)}},
+			{name: "merged locations are gone", method: http.MethodGet, path: "/locations/3", token: ida, status: http.StatusNotFound},
+			{name: "ida has one set of the show", method: http.MethodGet, path: "/sets?location_id=1", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(1)}},
+			{name: "eve's set moved", method: http.MethodGet, path: "/sets/3", token: eve, status: http.StatusOK,
+				fields: map[string]interface{}{"location_id": float64(1), "location_name": "Red Rocks"}},
+
+			{name: "users can not delete locations", method: http.MethodDelete, path: "/locations/6", token: ida, status: http.StatusForbidden},
+			{name: "a location without sets", method: http.MethodDelete, path: "/locations/6", token: editor, status: http.StatusOK,
+				fields: map[string]interface{}{"id": float64(6), "sets_deleted": float64(0)}},
+			{name: "is gone", method: http.MethodGet, path: "/locations/6", token: ida, status: http.StatusNotFound},
+			{name: "a location with sets", method: http.MethodDelete, path: "/locations/4", token: editor, status: http.StatusConflict},
+			{name: "keeps them", method: http.MethodGet, path: "/sets/4", token: ida, status: http.StatusOK},
+			{name: "unless they go too", method: http.MethodDelete, path: "/locations/4?cascade=true", token: editor, status: http.StatusOK,
+				fields: map[string]interface{}{"sets_deleted": float64(1)}},
+			{name: "the set is gone", method: http.MethodGet, path: "/sets/4", token: ida, status: http.StatusNotFound},
+			{name: "a missing location", method: http.MethodDelete, path: "/locations/99", token: editor, status: http.StatusNotFound},
+		})
+	})
+}
+
+func TestFestivalEditions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		ida := api.user("ida", "USER")
+		eve := api.user("eve", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		api.mustRequest(http.MethodPost, "/artists", editor, map[string]interface{}{"name": "ODESZA"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/artists", editor, map[string]interface{}{"name": "Bonobo"}, http.StatusCreated)
+
+		runSteps(t, api, []testStep{
+			{name: "users can not create festivals", method: http.MethodPost, path: "/festivals", token: ida, body: map[string]interface{}{"name": "Bonnaroo"}, status: http.StatusForbidden},
+			{name: "create a festival", method: http.MethodPost, path: "/festivals", token: editor, body: map[string]interface{}{"name": "Bonnaroo"}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(1), "name": "Bonnaroo"}},
+			{name: "without a name", method: http.MethodPost, path: "/festivals", token: editor, body: map[string]interface{}{"name": " "}, status: http.StatusBadRequest},
+			{name: "the same festival again", method: http.MethodPost, path: "/festivals", token: editor, body: map[string]interface{}{"name": "Bonnaroo"}, status: http.StatusConflict},
+			{name: "an edition by year", method: http.MethodPost, path: "/festivals/1/editions", token: editor, body: map[string]interface{}{"year": 2018}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(1), "name": "Bonnaroo", "is_festival": true, "festival_id": float64(1), "year": float64(2018)}},
+			{name: "an edition by its dates", method: http.MethodPost, path: "/festivals/1/editions", token: editor,
+				body: map[string]interface{}{"name": "Bonnaroo 2019", "start_date": "2019-06-13", "end_date": "2019-06-16", "venue": "Great Stage Park"}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(2), "year": float64(2019), "venue": "Great Stage Park"}},
+			{name: "the same year again", method: http.MethodPost, path: "/festivals/1/editions", token: editor, body: map[string]interface{}{"name": "Bonnaroo Again", "year": 2019}, status: http.StatusConflict},
+			{name: "without a year", method: http.MethodPost, path: "/festivals/1/editions", token: editor, body: map[string]interface{}{"name": "Bonnaroo Someday"}, status: http.StatusBadRequest},
+			{name: "ending before it starts", method: http.MethodPost, path: "/festivals/1/editions", token: editor,
+				body: map[string]interface{}{"start_date": "2020-06-13", "end_date": "2020-06-10"}, status: http.StatusBadRequest},
+			{name: "of a missing festival", method: http.MethodPost, path: "/festivals/99/editions", token: editor, body: map[string]interface{}{"year": 2019}, status: http.StatusNotFound},
+			{name: "a festival location joins the series named like it", method: http.MethodPost, path: "/locations", token: editor,
+				body: map[string]interface{}{"name": "Bonnaroo 2021", "is_festival": true, "year": 2021}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(3), "festival_id": float64(1)}},
+			{name: "or the one it names", method: http.MethodPost, path: "/locations", token: editor,
+				body: map[string]interface{}{"name": "The Farm 2022", "is_festival": true, "year": 2022, "festival_id": 1}, status: http.StatusCreated,
+				fields: map[string]interface{}{"festival_id": float64(1)}},
+			{name: "which must exist", method: http.MethodPost, path: "/locations", token: editor,
+				body: map[string]interface{}{"name": "Nowhere 2022", "is_festival": true, "year": 2022, "festival_id": 99}, status: http.StatusBadRequest},
+			{name: "a venue is in no series", method: http.MethodPost, path: "/locations", token: editor,
+				body: map[string]interface{}{"name": "Red Rocks", "city": "Morrison", "festival_id": 1}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(5), "festival_id": nil}},
+			{name: "list the editions", method: http.MethodGet, path: "/festivals/1/editions", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(4)}},
+			{name: "a new series was not made", method: http.MethodGet, path: "/festivals", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(1)}},
+			{name: "users can not rename festivals", method: http.MethodPut, path: "/festivals/1", token: ida, body: map[string]interface{}{"name": "Bonnaroo Music Festival"}, status: http.StatusForbidden},
+			{name: "editors can", method: http.MethodPut, path: "/festivals/1", token: editor, body: map[string]interface{}{"name": "Bonnaroo Music Festival", "id": 2}, status: http.StatusOK,
+				fields: map[string]interface{}{"id": float64(1), "name": "Bonnaroo Music Festival"}},
+			{name: "the rename is stored", method: http.MethodGet, path: "/festivals/1", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"name": "Bonnaroo Music Festival"}},
+			{name: "a missing festival", method: http.MethodGet, path: "/festivals/99", token: ida, status: http.StatusNotFound},
+		})
+
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 1, "location_id": 1, "date": "2018-06-08"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 2, "location_id": 2, "date": "2019-06-14"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 1, "location_id": 5, "date": "2019-08-01"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", eve, map[string]interface{}{"artist_id": 1, "location_id": 2, "date": "2019-06-14"}, http.StatusCreated)
+
+		runSteps(t, api, []testStep{
+			{name: "ida's sets across every edition", method: http.MethodGet, path: "/festivals/1/sets", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(2)}},
+			{name: "with the usual filters", method: http.MethodGet, path: "/festivals/1/sets?artist_id=2", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(1)}},
+			{name: "eve's", method: http.MethodGet, path: "/festivals/1/sets", token: eve, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(1)}},
+			{name: "eve can not read ida's", method: http.MethodGet, path: "/festivals/1/sets?user_id=1", token: eve, status: http.StatusForbidden},
+			{name: "editors can", method: http.MethodGet, path: "/festivals/1/sets?user_id=1", token: editor, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(2)}},
+			{name: "of a missing festival", method: http.MethodGet, path: "/festivals/99/sets", token: ida, status: http.StatusNotFound},
+		})
+	})
+}
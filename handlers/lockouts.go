@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func GetLockouts(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	lockouts, customErr := auth.GetLockouts(ctx)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lockouts": lockouts, "count": len(lockouts)})
+}
+
+func ClearLockout(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	customErr := auth.ClearLockout(ctx, c.Param("kind"), c.Param("value"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const DATE_FORMAT = "2006-01-02"
+
+// getPageRequest reads the limit, cursor and sort query parameters shared by every list endpoint.
+func getPageRequest(c *gin.Context) (types.PageRequest, types.Error) {
+	page := types.PageRequest{
+		Cursor: c.Query("cursor"),
+		Sort:   c.Query("sort"),
+	}
+
+	var customErr types.Error
+	page.Limit, customErr = getPositiveIntQuery(c, "limit")
+	if customErr != nil {
+		return page, customErr
+	}
+
+	return page, nil
+}
+
+// getSetFilter reads the optional set filters: user_id, artist_id, location_id, festival_id, stage_id, stage (a
+// stage name, for stages that are named the same at every location), genre, min_rating, from and to.
+func getSetFilter(c *gin.Context) (types.SetFilter, types.Error) {
+	filter := types.SetFilter{
+		Stage: c.Query("stage"),
+		Genre: c.Query("genre"),
+		From:  c.Query("from"),
+		To:    c.Query("to"),
+	}
+
+	var customErr types.Error
+	filter.UserId, customErr = getPositiveIntQuery(c, "user_id")
+	if customErr != nil {
+		return filter, customErr
+	}
+
+	filter.ArtistId, customErr = getPositiveIntQuery(c, "artist_id")
+	if customErr != nil {
+		return filter, customErr
+	}
+
+	filter.LocationId, customErr = getPositiveIntQuery(c, "location_id")
+	if customErr != nil {
+		return filter, customErr
+	}
+
+	filter.FestivalId, customErr = getPositiveIntQuery(c, "festival_id")
+	if customErr != nil {
+		return filter, customErr
+	}
+
+	filter.StageId, customErr = getPositiveIntQuery(c, "stage_id")
+	if customErr != nil {
+		return filter, customErr
+	}
+
+	filter.MinRating, customErr = getPositiveIntQuery(c, "min_rating")
+	if customErr != nil {
+		return filter, customErr
+	}
+
+	for name, value := range map[string]string{"from": filter.From, "to": filter.To} {
+		if value == "" {
+			continue
+		}
+
+		_, err := time.Parse(DATE_FORMAT, value)
+		if err != nil {
+			return filter, customerrors.New(http.StatusBadRequest, fmt.Sprintf("%s must be a date formatted as YYYY-MM-DD", name))
+		}
+	}
+
+	return filter, nil
+}
+
+// getPositiveIntQuery returns the named query parameter as an int, or 0 when it is not set.
+func getPositiveIntQuery(c *gin.Context, name string) (int, types.Error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		return 0, customerrors.New(http.StatusBadRequest, fmt.Sprintf("%s must be a positive integer", name))
+	}
+
+	return value, nil
+}
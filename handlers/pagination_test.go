@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// walkPages follows next_cursor from the first page of path until the last, checking every
+// page's count and total, and returns field of every item in the order they were listed.
+func walkPages(t *testing.T, api *testApi, token string, path string, list string, field string, total int) []interface{} {
+	values := make([]interface{}, 0)
+	cursor := ""
+	for pages := 0; pages <= total; pages++ {
+		page := path
+		if cursor != "" {
+			page += "&cursor=" + url.QueryEscape(cursor)
+		}
+
+		response := api.mustRequest(http.MethodGet, page, token, nil, http.StatusOK)
+		items, _ := response[list].([]interface{})
+		if response["count"] != float64(len(items)) || response["total"] != float64(total) {
+			t.Fatalf("%s listed %d items with count %v and total %v, expected a total of %d", page, len(items), response["count"], response["total"], total)
+		}
+
+		for _, item := range items {
+			values = append(values, item.(map[string]interface{})[field])
+		}
+
+		cursor, _ = response["next_cursor"].(string)
+		if cursor == "" {
+			return values
+		}
+	}
+
+	t.Fatalf("%s kept returning a next_cursor", path)
+	return nil
+}
+
+func TestPaginationCursors(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		user := api.user("user", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		for _, name := range []string{"Delta", "alpha", "Echo", "bravo", "Charlie"} {
+			api.mustRequest(http.MethodPost, "/artists", user, map[string]interface{}{"name": name}, http.StatusCreated)
+		}
+
+		for i, year := range []int{2019, 2017, 2019, 2018} {
+			api.mustRequest(http.MethodPost, "/locations", editor, map[string]interface{}{"name": fmt.Sprintf("Venue %d", i+1), "year": year}, http.StatusCreated)
+		}
+
+		// Three sets share a date, so pages have to break ties by id.
+		for i, date := range []string{"2019-06-14", "2018-05-01", "2019-06-14", "2019-06-14", "2017-01-01"} {
+			api.mustRequest(http.MethodPost, "/sets", user, map[string]interface{}{"artist_id": i + 1, "location_id": 1, "date": date}, http.StatusCreated)
+		}
+
+		tests := []struct {
+			name   string
+			path   string
+			list   string
+			field  string
+			total  int
+			values []interface{}
+		}{
+			{"artists by id", "/artists?limit=2", "artists", "name", 5, []interface{}{"Delta", "alpha", "Echo", "bravo", "Charlie"}},
+			{"artists by name", "/artists?limit=2&sort=name", "artists", "name", 5, []interface{}{"alpha", "bravo", "Charlie", "Delta", "Echo"}},
+			{"artists by name descending", "/artists?limit=3&sort=-name", "artists", "name", 5, []interface{}{"Echo", "Delta", "Charlie", "bravo", "alpha"}},
+			{"locations by year", "/locations?limit=1&sort=year", "locations", "id", 4, []interface{}{float64(2), float64(4), float64(1), float64(3)}},
+			{"locations by year descending", "/locations?limit=3&sort=-year", "locations", "id", 4, []interface{}{float64(3), float64(1), float64(4), float64(2)}},
+			{"sets by date", "/sets?limit=2&sort=date", "sets", "id", 5, []interface{}{float64(5), float64(2), float64(1), float64(3), float64(4)}},
+			{"sets by date descending", "/sets?limit=2&sort=-date", "sets", "id", 5, []interface{}{float64(4), float64(3), float64(1), float64(2), float64(5)}},
+			{"filtered sets", "/sets?limit=1&sort=-date&from=2018-01-01", "sets", "id", 4, []interface{}{float64(4), float64(3), float64(1), float64(2)}},
+			{"one page", "/artists?limit=200", "artists", "id", 5, []interface{}{float64(1), float64(2), float64(3), float64(4), float64(5)}},
+		}
+
+		for _, test := range tests {
+			values := walkPages(t, api, user, test.path, test.list, test.field, test.total)
+			if !reflect.DeepEqual(values, test.values) {
+				t.Fatalf("%s: listed %v, expected %v", test.name, values, test.values)
+			}
+		}
+
+		runSteps(t, api, []testStep{
+			{name: "an unknown sort", method: http.MethodGet, path: "/artists?sort=genre", token: user, status: http.StatusBadRequest},
+			{name: "a corrupt cursor", method: http.MethodGet, path: "/artists?cursor=not-a-cursor", token: user, status: http.StatusBadRequest},
+			{name: "a negative limit", method: http.MethodGet, path: "/artists?limit=-1", token: user, status: http.StatusBadRequest},
+		})
+
+		// Cursors come back from clients, so their values are checked against the sort field.
+		cursors := []struct {
+			path   string
+			cursor string
+			status int
+		}{
+			{"/artists?sort=name", `{"v":"bravo","id":4}`, http.StatusOK},
+			{"/artists", `{"v":2,"id":2}`, http.StatusOK},
+			{"/artists", `{"v":{"a":1},"id":2}`, http.StatusBadRequest},
+			{"/artists", `{"v":[1],"id":2}`, http.StatusBadRequest},
+			{"/artists", `{"v":null,"id":2}`, http.StatusBadRequest},
+			{"/artists", `{"v":"2","id":2}`, http.StatusBadRequest},
+			{"/artists", `{"v":2.5,"id":2}`, http.StatusBadRequest},
+			{"/artists?sort=name", `{"v":2,"id":2}`, http.StatusBadRequest},
+			{"/artists?sort=name", `{"v":"bravo","id":"4"}`, http.StatusBadRequest},
+			{"/locations?sort=year", `{"v":{},"id":1}`, http.StatusBadRequest},
+			{"/sets?sort=date", `{"v":["2019-06-14"],"id":1}`, http.StatusBadRequest},
+		}
+
+		for _, test := range cursors {
+			separator := "?"
+			if strings.Contains(test.path, "?") {
+				separator = "&"
+			}
+			path := test.path + separator + "cursor=" + base64.RawURLEncoding.EncodeToString([]byte(test.cursor))
+
+			status, response := api.request(http.MethodGet, path, user, nil)
+			if status != test.status {
+				t.Fatalf("cursor %s on %s returned %d %v, expected %d", test.cursor, test.path, status, response, test.status)
+			}
+		}
+	})
+}
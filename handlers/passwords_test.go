@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPasswordReset(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		session := api.user("ida", "USER")
+		pat := api.mustRequest(http.MethodPost, "/user/current/tokens", session, map[string]interface{}{"name": "script", "scopes": []string{"sets:read"}}, http.StatusCreated)["token"].(string)
+
+		api.mustRequest(http.MethodPost, "/password/forgot", "", map[string]interface{}{"email": "ida@example.com"}, http.StatusAccepted)
+		token := api.mailedToken("ida@example.com")
+
+		// An expired token, as if it had been mailed long ago.
+		now := time.Now()
+		_, customErr := api.store.CreatePasswordReset(context.Background(), types.PasswordReset{
+			UserId:    1,
+			TokenHash: auth.HashToken("expired-token"),
+			CreatedAt: now.Add(-2 * time.Hour).Unix(),
+			ExpiresAt: now.Add(-time.Hour).Unix(),
+		})
+		if customErr != nil {
+			t.Fatal(customErr.Description())
+		}
+
+		reset := map[string]interface{}{"token": token, "password": "a brand new passphrase"}
+		runSteps(t, api, []testStep{
+			{name: "an unknown email gets the same answer", method: http.MethodPost, path: "/password/forgot", body: map[string]interface{}{"email": "nobody@example.com"}, status: http.StatusAccepted},
+			{name: "an expired token", method: http.MethodPost, path: "/password/reset", body: map[string]interface{}{"token": "expired-token", "password": "a brand new passphrase"}, status: http.StatusBadRequest},
+			{name: "a weak password", method: http.MethodPost, path: "/password/reset", body: map[string]interface{}{"token": token, "password": "password"}, status: http.StatusBadRequest},
+			{name: "reset", method: http.MethodPost, path: "/password/reset", body: reset, status: http.StatusNoContent},
+			{name: "the token works once", method: http.MethodPost, path: "/password/reset", body: reset, status: http.StatusBadRequest},
+			{name: "the session is revoked", method: http.MethodGet, path: "/authcheck", token: session, status: http.StatusUnauthorized},
+			{name: "so is the personal access token", method: http.MethodGet, path: "/sets", token: pat, status: http.StatusUnauthorized},
+			{name: "the old password is gone", method: http.MethodPost, path: "/signin", body: map[string]interface{}{"username": "ida", "password": testPassword}, status: http.StatusUnauthorized},
+			{name: "the new one works", method: http.MethodPost, path: "/signin", body: map[string]interface{}{"username": "ida", "password": "a brand new passphrase"}, status: http.StatusOK},
+		})
+	})
+}
+
+func TestPasswordResetThrottle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		admin := api.user("admin", "ADMIN")
+		api.user("ida", "USER")
+
+		// The first three requests are free, the fourth starts backing off.
+		for i := 0; i < 4; i++ {
+			api.mustRequest(http.MethodPost, "/password/forgot", "", map[string]interface{}{"email": "ida@example.com"}, http.StatusAccepted)
+			api.mailedToken("ida@example.com")
+		}
+
+		recorder := api.serve(http.MethodPost, "/password/forgot", "", map[string]interface{}{"email": "IDA@example.com"})
+		if recorder.Code != http.StatusTooManyRequests {
+			t.Fatalf("a fifth reset request returned %d", recorder.Code)
+		}
+		if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "1" && retryAfter != "2" {
+			t.Fatalf("Retry-After is %q for the first backoff", retryAfter)
+		}
+
+		for i := 0; i < 4; i++ {
+			api.mustRequest(http.MethodPost, "/password/forgot", "", map[string]interface{}{"email": "nobody@example.com"}, http.StatusAccepted)
+		}
+
+		runSteps(t, api, []testStep{
+			{name: "emails without an account are throttled the same", method: http.MethodPost, path: "/password/forgot", body: map[string]interface{}{"email": "nobody@example.com"}, status: http.StatusTooManyRequests},
+			{name: "other emails are not", method: http.MethodPost, path: "/password/forgot", body: map[string]interface{}{"email": "admin@example.com"}, status: http.StatusAccepted},
+			{name: "admins clear the email", method: http.MethodDelete, path: "/lockouts/email/ida@example.com", token: admin, status: http.StatusNoContent},
+			{name: "which can ask again", method: http.MethodPost, path: "/password/forgot", body: map[string]interface{}{"email": "ida@example.com"}, status: http.StatusAccepted},
+		})
+
+		// Every request counts against the address as well, ten so far. It backs off once it is
+		// past its free attempts.
+		for i := 10; i <= auth.LOGIN_FREE_IP_ATTEMPTS; i++ {
+			api.mustRequest(http.MethodPost, "/password/forgot", "", map[string]interface{}{"email": fmt.Sprintf("user%d@example.com", i)}, http.StatusAccepted)
+		}
+		api.mustRequest(http.MethodPost, "/password/forgot", "", map[string]interface{}{"email": "last@example.com"}, http.StatusTooManyRequests)
+	})
+}
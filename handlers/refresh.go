@@ -2,17 +2,29 @@ package handlers
 
 import (
 	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/database"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
 
 func RefreshToken(c *gin.Context) {
+	var refresh struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := c.BindJSON(&refresh)
+	if err != nil || refresh.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "must include refresh_token"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
 
-	tokenString, err := auth.RefreshToken(c)
-	if err != nil {
-		c.JSON(err.StatusCode(), gin.H{"error": err.Description()})
+	tokenString, refreshToken, customErr := auth.RefreshSession(ctx, refresh.RefreshToken, auth.GetDevice(c))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	c.JSON(http.StatusOK, gin.H{"token": tokenString, "refresh_token": refreshToken})
 }
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+func GetCurrentUserSessions(c *gin.Context) {
+	claims := auth.GetClaims(c)
+
+	userId, err := strconv.Atoi(claims.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not convert user id to an int"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	sessions, customErr := store.GetActiveSessionsForUser(ctx, userId)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].FamilyId == claims.SessionId
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions, "count": len(sessions)})
+}
+
+func RevokeCurrentUserSession(c *gin.Context) {
+	id := c.Param("id")
+	claims := auth.GetClaims(c)
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	session, customErr := store.GetSession(ctx, id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	// Someone else's session is reported the same as one that doesn't exist.
+	if strconv.Itoa(session.UserId) != claims.Id {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	customErr = store.RevokeSessionFamily(ctx, session.FamilyId)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func RevokeUserSessions(c *gin.Context) {
+	id := c.Param("id")
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	user, customErr := store.GetUser(ctx, id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	userId, _ := strconv.Atoi(user.Id)
+	customErr = store.RevokeUserSessions(ctx, userId)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAccessTokenRevocation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		admin := api.user("admin", "ADMIN")
+		first := api.user("ida", "USER")
+		second, secondRefresh := api.signIn("ida")
+
+		response := api.mustRequest(http.MethodPost, "/refresh", "", map[string]interface{}{"refresh_token": secondRefresh}, http.StatusOK)
+		rotated := response["token"].(string)
+
+		runSteps(t, api, []testStep{
+			{name: "the first sign in works", method: http.MethodGet, path: "/authcheck", token: first, status: http.StatusOK},
+			{name: "a refreshed access token is revoked", method: http.MethodGet, path: "/authcheck", token: second, status: http.StatusUnauthorized},
+			{name: "the one replacing it works", method: http.MethodGet, path: "/authcheck", token: rotated, status: http.StatusOK},
+			{name: "both sessions are listed", method: http.MethodGet, path: "/user/current/sessions", token: rotated, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(2)}},
+			{name: "sign out", method: http.MethodPost, path: "/signout", token: rotated, status: http.StatusNoContent},
+			{name: "the signed out token is revoked", method: http.MethodGet, path: "/authcheck", token: rotated, status: http.StatusUnauthorized},
+			{name: "so is its session", method: http.MethodPost, path: "/refresh", body: map[string]interface{}{"refresh_token": response["refresh_token"]}, status: http.StatusUnauthorized},
+			{name: "the other sign in is not", method: http.MethodGet, path: "/authcheck", token: first, status: http.StatusOK},
+			{name: "one session is left", method: http.MethodGet, path: "/user/current/sessions", token: first, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(1)}},
+		})
+
+		// Ending a session from another one revokes its access token too.
+		third, _ := api.signIn("ida")
+		sessions := api.mustRequest(http.MethodGet, "/user/current/sessions", third, nil, http.StatusOK)["sessions"].([]interface{})
+		var firstSession string
+		for _, session := range sessions {
+			if !session.(map[string]interface{})["current"].(bool) {
+				firstSession = fmt.Sprint(session.(map[string]interface{})["id"])
+			}
+		}
+
+		runSteps(t, api, []testStep{
+			{name: "another user's session", method: http.MethodDelete, path: "/user/current/sessions/" + firstSession, token: admin, status: http.StatusNotFound},
+			{name: "end the first session", method: http.MethodDelete, path: "/user/current/sessions/" + firstSession, token: third, status: http.StatusNoContent},
+			{name: "its token is revoked", method: http.MethodGet, path: "/authcheck", token: first, status: http.StatusUnauthorized},
+			{name: "users can not end everyone's sessions", method: http.MethodDelete, path: "/users/2/sessions", token: third, status: http.StatusForbidden},
+			{name: "admins can", method: http.MethodDelete, path: "/users/2/sessions", token: admin, status: http.StatusNoContent},
+			{name: "which revokes the last token", method: http.MethodGet, path: "/authcheck", token: third, status: http.StatusUnauthorized},
+			{name: "but not the admin's", method: http.MethodGet, path: "/authcheck", token: admin, status: http.StatusOK},
+		})
+	})
+}
+
+func TestAccessTokenWithoutId(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		api.user("ida", "USER")
+
+		// Signed with the right key, but without a jti it could never be revoked.
+		claims := types.Claims{Username: "ida", Role: "USER", Id: "1", Scopes: auth.PermissionsForRole("USER")}
+		claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-signing-key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		runSteps(t, api, []testStep{
+			{name: "a token without a jti", method: http.MethodGet, path: "/authcheck", token: token, status: http.StatusUnauthorized,
+				fields: map[string]interface{}{"error": "token has no id, sign in again"}},
+			{name: "or a signature", method: http.MethodGet, path: "/authcheck", token: token[:len(token)-4] + "AAAA", status: http.StatusUnauthorized},
+		})
+	})
+}
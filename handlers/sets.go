@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
 	"github.com/AnthonyNixon/setsisaw/database"
 	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/AnthonyNixon/setsisaw/utils"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
@@ -12,24 +15,14 @@ import (
 )
 
 func NewSet(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
-	if customErr != nil {
-		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
-		return
-	}
-
-	if !auth.IsEntitled(claims, "USER") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to add a set.", claims.Username)})
-		return
-	}
+	claims := auth.GetClaims(c)
 
 	// If we're here, the user is authorized to add a set.
 
 	var newSet types.Set
 	err := c.BindJSON(&newSet)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Bad JSON Input, could not bind."})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad JSON Input, could not bind."})
 		return
 	}
 
@@ -40,9 +33,12 @@ func NewSet(c *gin.Context) {
 	}
 	newSet.UserId = userId
 
-	unique, err := isNewSetUnique(newSet)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	unique, customErr := store.IsSetUnique(ctx, newSet)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
@@ -51,156 +47,263 @@ func NewSet(c *gin.Context) {
 		return
 	}
 
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	customErr = checkSetDate(ctx, newSet)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
-	defer db.Close()
 
-	var isFestival bool
-	err = db.QueryRow(database.GET_LOCATION_TYPE, newSet.LocationId).Scan(&isFestival)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not get location type: " + err.Error()})
+	customErr = utils.CheckSetStage(ctx, store, newSet)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	if !isFestival {
-		if newSet.Date == "" || newSet.Date == "0000-00-00" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "non-festival location must have a date"})
-			return
-		}
-	}
-
 	if newSet.Metadata.Genre == "" {
-		defaultGenre, _ := getArtistDefaultGenre(newSet)
+		defaultGenre, _ := getArtistDefaultGenre(ctx, newSet)
 		newSet.Metadata.Genre = defaultGenre
 		log.Printf("Default Genre: %s", defaultGenre)
 	}
 
-	stmt, err := db.Prepare(database.INSERT_NEW_SET)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+	newSet.Id, customErr = store.CreateSet(ctx, newSet)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	_, err = stmt.Exec(newSet.UserId, newSet.ArtistId, newSet.LocationId, newSet.Date, newSet.Metadata.Rating, newSet.Metadata.Genre, newSet.Metadata.Length, newSet.Metadata.Notes)
+	c.JSON(http.StatusCreated, newSet)
+}
+
+func GetSetsForCurrentUser(c *gin.Context) {
+	claims := auth.GetClaims(c)
+
+	// If we're here, the user is authorized to get sets for themselves.
+	filter, customErr := getSetFilter(c)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	// Whatever user_id was asked for, this endpoint only ever lists the caller's own sets.
+	userId, err := strconv.Atoi(claims.Id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not convert user_id to int, " + err.Error()})
 		return
 	}
+	filter.UserId = userId
 
-	c.JSON(http.StatusCreated, newSet)
+	page, customErr := getPageRequest(c)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	sets, info, customErr := store.ListSets(ctx, filter, page)
+	sendSets(sets, info, customErr, c)
 }
 
-func GetSetsForCurrentUser(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
+func GetAllSets(c *gin.Context) {
+	// If we're here, the user is authorized to get all sets.
+	filter, customErr := getSetFilter(c)
 	if customErr != nil {
 		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	if !auth.IsEntitled(claims, "USER") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to get sets.", claims.Username)})
+	page, customErr := getPageRequest(c)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	// If we're here, the user is authorized to get sets for themselves.
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	sets, info, customErr := store.ListSets(ctx, filter, page)
+	sendSets(sets, info, customErr, c)
+}
+
+func GetSet(c *gin.Context) {
+	id := c.Param("id")
+
+	claims := auth.GetClaims(c)
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	set, customErr := store.GetSet(ctx, id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
-	defer db.Close()
 
-	user_id, err := strconv.ParseInt(claims.Id, 10, 64)
+	if !canAccessSet(claims, set, false) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("UserId %s is not entitled to get set %s.", claims.Username, id)})
+		return
+	}
+
+	c.JSON(http.StatusOK, set)
+}
+
+func UpdateSet(c *gin.Context) {
+	id := c.Param("id")
+
+	claims := auth.GetClaims(c)
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	set, customErr := store.GetSet(ctx, id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	if !canAccessSet(claims, set, true) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("UserId %s is not entitled to edit set %s.", claims.Username, id)})
+		return
+	}
+
+	// The id and owner of a set can never be changed through an update.
+	setId, userId := set.Id, set.UserId
+	err := c.BindJSON(&set)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not convert user_id to int, " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind set JSON", "details": err.Error()})
 		return
 	}
+	set.Id, set.UserId = setId, userId
 
-	query := fmt.Sprintf(database.GET_ALL_SETS_FOR_USER_FORMAT, user_id)
+	unique, customErr := store.IsSetUpdateUnique(ctx, set)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
 
-	sendSets(query, c)
-}
+	if !unique {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set already created"})
+		return
+	}
 
-func GetAllSets(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
+	customErr = checkSetDate(ctx, set)
 	if customErr != nil {
 		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	if !auth.IsEntitled(claims, "EDITOR") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to get all sets.", claims.Username)})
+	customErr = utils.CheckSetStage(ctx, store, set)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	// If we're here, the user is authorized to get all sets.
+	if set.Metadata.Genre == "" {
+		defaultGenre, _ := getArtistDefaultGenre(ctx, set)
+		set.Metadata.Genre = defaultGenre
+	}
+
+	customErr = store.UpdateSet(ctx, set)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	// Re-read the set so the artist and location names reflect the update.
+	set, customErr = store.GetSet(ctx, id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
 
-	sendSets(database.GET_ALL_SETS, c)
+	c.JSON(http.StatusOK, set)
 }
 
-func sendSets(query string, c *gin.Context) {
-	set := types.Set{}
-	sets := make([]types.Set, 0)
+func DeleteSet(c *gin.Context) {
+	id := c.Param("id")
 
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	claims := auth.GetClaims(c)
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	set, customErr := store.GetSet(ctx, id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
-	defer db.Close()
 
-	rows, err := db.Query(query)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+	if !canAccessSet(claims, set, true) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("UserId %s is not entitled to delete set %s.", claims.Username, id)})
 		return
 	}
 
-	for rows.Next() {
-		err := rows.Scan(&set.Id, &set.UserId, &set.ArtistId, &set.ArtistName, &set.LocationId, &set.LocationName, &set.Date, &set.Metadata.Rating, &set.Metadata.Genre, &set.Metadata.Length, &set.Metadata.Notes)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		sets = append(sets, set)
+	customErr = store.DeleteSet(ctx, set.Id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
 	}
-	defer rows.Close()
 
-	c.JSON(http.StatusOK, gin.H{"sets": sets, "count": len(sets)})
+	c.Status(http.StatusNoContent)
 }
 
-func isNewSetUnique(newSet types.Set) (bool, error) {
-	db, err := database.GetConnection()
-	if err != nil {
-		return false, err
+func sendSets(sets []types.Set, info types.PageInfo, customErr types.Error, c *gin.Context) {
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
 	}
-	defer db.Close()
 
-	var count int
-	err = db.QueryRow(database.IS_SET_UNIQUE_QUERY, newSet.UserId, newSet.ArtistId, newSet.LocationId, newSet.Date).Scan(&count)
-	if err != nil {
-		return false, err
+	c.JSON(http.StatusOK, gin.H{"sets": sets, "count": len(sets), "total": info.Total, "next_cursor": info.NextCursor})
+}
+
+func getArtistDefaultGenre(ctx context.Context, set types.Set) (string, types.Error) {
+	return store.GetArtistDefaultGenre(ctx, set.ArtistName, set.ArtistId)
+}
+
+// canAccessSet reports whether the user in claims may view (or with write, modify) the set.
+// Their own sets need sets:read or sets:write, anyone else's need the ":any" variant.
+func canAccessSet(claims types.Claims, set types.Set, write bool) bool {
+	own, any := auth.PERMISSION_SETS_READ, auth.PERMISSION_SETS_READ_ANY
+	if write {
+		own, any = auth.PERMISSION_SETS_WRITE, auth.PERMISSION_SETS_WRITE_ANY
+	}
+
+	if strconv.Itoa(set.UserId) == claims.Id {
+		return auth.HasPermission(claims, own)
 	}
 
-	return count == 0, nil
+	return auth.HasPermission(claims, any)
 }
 
-func getArtistDefaultGenre(newSet types.Set) (string, error) {
-	db, err := database.GetConnection()
-	if err != nil {
-		return "", err
+// checkSetDate enforces that sets at non-festival locations always have a date, and that dated
+// sets at a festival fall within the edition's dates when those are known.
+func checkSetDate(ctx context.Context, set types.Set) types.Error {
+	isFestival, customErr := store.IsFestival(ctx, set.LocationId)
+	if customErr != nil {
+		return customerrors.New(customErr.StatusCode(), "could not get location type: "+customErr.Description())
 	}
-	defer db.Close()
 
-	var genre string
-	err = db.QueryRow(database.GET_ARTIST_DEFAULT_GENRE, newSet.ArtistName, newSet.ArtistId).Scan(&genre)
-	if err != nil {
-		return "", err
+	if !isFestival {
+		if set.Date == "" || set.Date == "0000-00-00" {
+			return customerrors.New(http.StatusBadRequest, "non-festival location must have a date")
+		}
+		return nil
+	}
+
+	if set.Date == "" {
+		return nil
+	}
+
+	edition, customErr := store.GetLocation(ctx, strconv.Itoa(set.LocationId))
+	if customErr != nil {
+		return customErr
+	}
+
+	// Dates are YYYY-MM-DD, so they compare as strings.
+	if (edition.StartDate != "" && set.Date < edition.StartDate) || (edition.EndDate != "" && set.Date > edition.EndDate) {
+		return customerrors.New(http.StatusBadRequest, fmt.Sprintf("the set's date is outside the dates of %s %d", edition.Name, edition.Year))
 	}
 
-	return genre, nil
+	return nil
 }
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSetOwnership(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		owner := api.user("owner", "USER")
+		other := api.user("other", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		api.mustRequest(http.MethodPost, "/artists", owner, map[string]interface{}{"name": "ODESZA", "default_genre": "electronic"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/locations", editor, map[string]interface{}{"name": "Red Rocks", "city": "Morrison"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/locations", editor, map[string]interface{}{"name": "The Gorge", "city": "George"}, http.StatusCreated)
+
+		set := map[string]interface{}{"artist_id": 1, "location_id": 1, "date": "2019-06-14", "metadata": map[string]interface{}{"rating": 5}}
+
+		runSteps(t, api, []testStep{
+			{name: "owner creates a set", method: http.MethodPost, path: "/sets", token: owner, body: set, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(1), "user_id": float64(1)}},
+			{name: "the same set again", method: http.MethodPost, path: "/sets", token: owner, body: set, status: http.StatusBadRequest},
+			{name: "another user can save the same set", method: http.MethodPost, path: "/sets", token: other, body: set, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(2), "user_id": float64(2)}},
+			{name: "owner reads it", method: http.MethodGet, path: "/sets/1", token: owner, status: http.StatusOK,
+				fields: map[string]interface{}{"artist_name": "ODESZA", "location_name": "Red Rocks"}},
+			{name: "other user reads it", method: http.MethodGet, path: "/sets/1", token: other, status: http.StatusForbidden},
+			{name: "editor reads it", method: http.MethodGet, path: "/sets/1", token: editor, status: http.StatusOK},
+			{name: "other user updates it", method: http.MethodPut, path: "/sets/1", token: other, body: map[string]interface{}{"date": "2019-06-15"}, status: http.StatusForbidden},
+			{name: "other user deletes it", method: http.MethodDelete, path: "/sets/1", token: other, status: http.StatusForbidden},
+			{name: "owner can not give it away", method: http.MethodPut, path: "/sets/1", token: owner, body: map[string]interface{}{"id": 2, "user_id": 2, "location_id": 2}, status: http.StatusOK,
+				fields: map[string]interface{}{"id": float64(1), "user_id": float64(1), "location_name": "The Gorge"}},
+			{name: "editor updates it", method: http.MethodPut, path: "/sets/1", token: editor, body: map[string]interface{}{"date": "2019-06-15"}, status: http.StatusOK,
+				fields: map[string]interface{}{"user_id": float64(1), "date": "2019-06-15"}},
+			{name: "a set missing from the store", method: http.MethodGet, path: "/sets/99", token: owner, status: http.StatusNotFound},
+			{name: "owner lists their own sets", method: http.MethodGet, path: "/sets", token: owner, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(1), "total": float64(1)}},
+			{name: "user_id does not list someone else's", method: http.MethodGet, path: "/sets?user_id=2", token: owner, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(1), "total": float64(1)}},
+			{name: "users can not list every set", method: http.MethodGet, path: "/sets/all", token: owner, status: http.StatusForbidden},
+			{name: "editors can", method: http.MethodGet, path: "/sets/all", token: editor, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(2)}},
+			{name: "owner deletes it", method: http.MethodDelete, path: "/sets/1", token: owner, status: http.StatusNoContent},
+			{name: "it is gone", method: http.MethodGet, path: "/sets/1", token: owner, status: http.StatusNotFound},
+			{name: "the other user's copy is not", method: http.MethodGet, path: "/sets/2", token: other, status: http.StatusOK},
+		})
+	})
+}
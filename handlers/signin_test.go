@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSignInThrottle(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		admin := api.user("admin", "ADMIN")
+		api.user("ida", "USER")
+		api.user("eve", "USER")
+
+		wrong := map[string]interface{}{"username": "ida", "password": "wrong password"}
+		right := map[string]interface{}{"username": "ida", "password": testPassword}
+
+		// The first three failures are free, the fourth starts backing off.
+		for i := 0; i < 4; i++ {
+			api.mustRequest(http.MethodPost, "/signin", "", wrong, http.StatusUnauthorized)
+		}
+
+		recorder := api.serve(http.MethodPost, "/signin", "", wrong)
+		if recorder.Code != http.StatusTooManyRequests {
+			t.Fatalf("signing in after 4 failures returned %d", recorder.Code)
+		}
+		if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "1" && retryAfter != "2" {
+			t.Fatalf("Retry-After is %q for the first backoff", retryAfter)
+		}
+
+		runSteps(t, api, []testStep{
+			{name: "even with the right password", method: http.MethodPost, path: "/signin", body: right, status: http.StatusTooManyRequests},
+			{name: "another user from the same address", method: http.MethodPost, path: "/signin",
+				body: map[string]interface{}{"username": "eve", "password": testPassword}, status: http.StatusOK},
+			{name: "admins clear the username", method: http.MethodDelete, path: "/lockouts/username/ida", token: admin, status: http.StatusNoContent},
+			{name: "signing in again", method: http.MethodPost, path: "/signin", body: right, status: http.StatusOK},
+		})
+	})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"github.com/AnthonyNixon/setsisaw/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+func GetStages(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	location, customErr := utils.GetLocation(ctx, store, c.Param("id"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	stages, customErr := store.GetStages(ctx, location.Id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stages": stages, "count": len(stages)})
+}
+
+func NewStage(c *gin.Context) {
+	var stage types.Stage
+	err := c.BindJSON(&stage)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad JSON Input, could not bind."})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	location, customErr := utils.GetLocation(ctx, store, c.Param("id"))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	stage.Id = 0
+	stage.LocationId = location.Id
+	stage, customErr = utils.NewStage(ctx, store, stage)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, stage)
+}
+
+func UpdateStage(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	stage, customErr := getLocationStage(ctx, c)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	// Only the name can change, a stage never moves to another location.
+	existingId, locationId := stage.Id, stage.LocationId
+	err := c.BindJSON(&stage)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind stage JSON", "details": err.Error()})
+		return
+	}
+	stage.Id, stage.LocationId = existingId, locationId
+
+	customErr = utils.UpdateStage(ctx, store, stage)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, stage)
+}
+
+// DeleteStage deletes a stage, the sets that were on it keep their location but lose the stage.
+func DeleteStage(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	stage, customErr := getLocationStage(ctx, c)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	cleared, customErr := store.DeleteStage(ctx, stage.LocationId, stage.Id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": stage.Id, "sets_updated": cleared})
+}
+
+// getLocationStage returns the stage in the path, a 404 unless it is a stage of the location in the path.
+func getLocationStage(ctx context.Context, c *gin.Context) (types.Stage, types.Error) {
+	stage, customErr := store.GetStage(ctx, c.Param("stage_id"))
+	if customErr != nil {
+		return stage, customErr
+	}
+
+	if strconv.Itoa(stage.LocationId) != c.Param("id") {
+		return stage, customerrors.New(http.StatusNotFound, "stage not found")
+	}
+
+	return stage, nil
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		owner := api.user("owner", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		api.mustRequest(http.MethodPost, "/artists", owner, map[string]interface{}{"name": "ODESZA"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/locations", editor, map[string]interface{}{"name": "Red Rocks", "city": "Morrison"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/locations", editor, map[string]interface{}{"name": "Bonnaroo 2019", "is_festival": true, "year": 2019}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", owner, map[string]interface{}{"artist_id": 1, "location_id": 2, "date": "2019-06-14"}, http.StatusCreated)
+
+		runSteps(t, api, []testStep{
+			{name: "users can not add stages", method: http.MethodPost, path: "/locations/2/stages", token: owner, body: map[string]interface{}{"name": "Main"}, status: http.StatusForbidden},
+			{name: "editors can", method: http.MethodPost, path: "/locations/2/stages", token: editor, body: map[string]interface{}{"name": "Main"}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(1), "location_id": float64(2), "name": "Main"}},
+			{name: "the same name in another case", method: http.MethodPost, path: "/locations/2/stages", token: editor, body: map[string]interface{}{"name": "MAIN"}, status: http.StatusConflict},
+			{name: "the same name at another location", method: http.MethodPost, path: "/locations/1/stages", token: editor, body: map[string]interface{}{"name": "Main"}, status: http.StatusCreated,
+				fields: map[string]interface{}{"id": float64(2), "location_id": float64(1)}},
+			{name: "without a name", method: http.MethodPost, path: "/locations/2/stages", token: editor, body: map[string]interface{}{"name": ""}, status: http.StatusBadRequest},
+			{name: "at a missing location", method: http.MethodPost, path: "/locations/99/stages", token: editor, body: map[string]interface{}{"name": "Main"}, status: http.StatusNotFound},
+			{name: "another stage", method: http.MethodPost, path: "/locations/2/stages", token: editor, body: map[string]interface{}{"name": "This Tent"}, status: http.StatusCreated},
+			{name: "list them", method: http.MethodGet, path: "/locations/2/stages", token: owner, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(2)}},
+
+			{name: "a stage of another location", method: http.MethodPut, path: "/sets/1", token: owner, body: map[string]interface{}{"stage_id": 2}, status: http.StatusBadRequest},
+			{name: "a missing stage", method: http.MethodPut, path: "/sets/1", token: owner, body: map[string]interface{}{"stage_id": 99}, status: http.StatusBadRequest},
+			{name: "a stage of the set's location", method: http.MethodPut, path: "/sets/1", token: editor, body: map[string]interface{}{"stage_id": 1}, status: http.StatusOK,
+				fields: map[string]interface{}{"user_id": float64(1), "stage_id": float64(1), "stage_name": "Main"}},
+			{name: "a new set on a stage of another location", method: http.MethodPost, path: "/sets", token: owner,
+				body: map[string]interface{}{"artist_id": 1, "location_id": 1, "date": "2019-06-15", "stage_id": 1}, status: http.StatusBadRequest},
+			{name: "filtered by stage", method: http.MethodGet, path: "/sets?stage_id=1", token: owner, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(1)}},
+			{name: "or its name", method: http.MethodGet, path: "/sets?stage=This%20Tent", token: owner, status: http.StatusOK,
+				fields: map[string]interface{}{"total": float64(0)}},
+
+			{name: "rename it", method: http.MethodPut, path: "/locations/2/stages/1", token: editor, body: map[string]interface{}{"name": "What Stage", "location_id": 1}, status: http.StatusOK,
+				fields: map[string]interface{}{"name": "What Stage", "location_id": float64(2)}},
+			{name: "onto another of its stages", method: http.MethodPut, path: "/locations/2/stages/1", token: editor, body: map[string]interface{}{"name": "this tent"}, status: http.StatusConflict},
+			{name: "through another location", method: http.MethodPut, path: "/locations/1/stages/1", token: editor, body: map[string]interface{}{"name": "Main"}, status: http.StatusNotFound},
+			{name: "the set shows the new name", method: http.MethodGet, path: "/sets/1", token: owner, status: http.StatusOK,
+				fields: map[string]interface{}{"stage_name": "What Stage"}},
+			{name: "users can not delete stages", method: http.MethodDelete, path: "/locations/2/stages/1", token: owner, status: http.StatusForbidden},
+			{name: "delete it", method: http.MethodDelete, path: "/locations/2/stages/1", token: editor, status: http.StatusOK,
+				fields: map[string]interface{}{"sets_updated": float64(1)}},
+			{name: "the set keeps its location", method: http.MethodGet, path: "/sets/1", token: owner, status: http.StatusOK,
+				fields: map[string]interface{}{"location_id": float64(2), "stage_id": nil}},
+		})
+	})
+}
+
+func TestMergeLocationsWithStages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		ida := api.user("ida", "USER")
+		editor := api.user("editor", "EDITOR")
+
+		api.mustRequest(http.MethodPost, "/artists", editor, map[string]interface{}{"name": "ODESZA"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/artists", editor, map[string]interface{}{"name": "Bonobo"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/locations", editor, map[string]interface{}{"name": "Bonnaroo 2019", "is_festival": true, "year": 2019}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/locations", editor, map[string]interface{}{"name": "Bonnaroo Music Festival 2019", "is_festival": true, "year": 2019}, http.StatusCreated)
+
+		// Stages 1 and 2 are at the location kept, 3 and 4 at its duplicate.
+		api.mustRequest(http.MethodPost, "/locations/1/stages", editor, map[string]interface{}{"name": "What Stage"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/locations/1/stages", editor, map[string]interface{}{"name": "This Tent"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/locations/2/stages", editor, map[string]interface{}{"name": "WHAT STAGE"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/locations/2/stages", editor, map[string]interface{}{"name": "Which Stage"}, http.StatusCreated)
+
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 1, "location_id": 2, "stage_id": 3, "date": "2019-06-14"}, http.StatusCreated)
+		api.mustRequest(http.MethodPost, "/sets", ida, map[string]interface{}{"artist_id": 2, "location_id": 2, "stage_id": 4, "date": "2019-06-15"}, http.StatusCreated)
+
+		runSteps(t, api, []testStep{
+			{name: "merge", method: http.MethodPost, path: "/locations/1/merge", token: editor, body: map[string]interface{}{"location_ids": []int{2}}, status: http.StatusOK,
+				fields: map[string]interface{}{"sets_moved": float64(2), "duplicate_sets_removed": float64(0)}},
+			{name: "a stage named like one of the location's is folded into it", method: http.MethodGet, path: "/sets/1", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"location_id": float64(1), "stage_id": float64(1), "stage_name": "What Stage"}},
+			{name: "the others move along", method: http.MethodGet, path: "/sets/2", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"location_id": float64(1), "stage_id": float64(4), "stage_name": "Which Stage"}},
+			{name: "the location has each stage once", method: http.MethodGet, path: "/locations/1/stages", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(3)}},
+			{name: "the folded stage is gone", method: http.MethodPut, path: "/locations/1/stages/3", token: editor, body: map[string]interface{}{"name": "Other Stage"}, status: http.StatusNotFound},
+		})
+	})
+}
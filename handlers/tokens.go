@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const DEFAULT_API_TOKEN_DAYS = 90
+const MAX_API_TOKEN_DAYS = 365
+
+type apiTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+func CreateApiToken(c *gin.Context) {
+	claims := auth.GetClaims(c)
+
+	var request apiTokenRequest
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token request, " + err.Error()})
+		return
+	}
+
+	if request.Name == "" || len(request.Name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required and must be at most 255 characters"})
+		return
+	}
+
+	if len(request.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one scope is required"})
+		return
+	}
+
+	for _, scope := range request.Scopes {
+		if !auth.HasPermission(claims, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("scope %s is not one of your permissions", scope)})
+			return
+		}
+	}
+
+	if request.ExpiresInDays == 0 {
+		request.ExpiresInDays = DEFAULT_API_TOKEN_DAYS
+	}
+
+	if request.ExpiresInDays < 0 || request.ExpiresInDays > MAX_API_TOKEN_DAYS {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in_days must be between 1 and %d", MAX_API_TOKEN_DAYS)})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	expiresAt := time.Now().Add(time.Duration(request.ExpiresInDays) * 24 * time.Hour)
+	tokenString, token, customErr := auth.NewApiToken(ctx, claims, request.Name, request.Scopes, expiresAt)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	// The token itself is only ever returned here.
+	c.JSON(http.StatusCreated, gin.H{"token": tokenString, "api_token": token})
+}
+
+func GetCurrentUserApiTokens(c *gin.Context) {
+	claims := auth.GetClaims(c)
+
+	userId, err := strconv.Atoi(claims.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not convert user id to an int"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	tokens, customErr := store.GetApiTokensForUser(ctx, userId)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens, "count": len(tokens)})
+}
+
+func RevokeCurrentUserApiToken(c *gin.Context) {
+	id := c.Param("id")
+	claims := auth.GetClaims(c)
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	token, customErr := store.GetApiToken(ctx, id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	// Someone else's token is reported the same as one that doesn't exist.
+	if strconv.Itoa(token.UserId) != claims.Id {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+
+	customErr = store.RevokeApiToken(ctx, token.Id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
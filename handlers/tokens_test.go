@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestApiTokens(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		admin := api.user("admin", "ADMIN")
+		editor := api.user("editor", "EDITOR")
+		api.mustRequest(http.MethodPost, "/artists", editor, map[string]interface{}{"name": "ODESZA"}, http.StatusCreated)
+
+		scopes := []string{"artists:read", "artists:update"}
+		created := api.mustRequest(http.MethodPost, "/user/current/tokens", editor, map[string]interface{}{"name": "script", "scopes": scopes}, http.StatusCreated)
+		pat := created["token"].(string)
+
+		runSteps(t, api, []testStep{
+			{name: "a scope the user does not have", method: http.MethodPost, path: "/user/current/tokens", token: editor,
+				body: map[string]interface{}{"name": "admin", "scopes": []string{"users:manage"}}, status: http.StatusBadRequest},
+			{name: "the token reads", method: http.MethodGet, path: "/artists/1", token: pat, status: http.StatusOK},
+			{name: "and updates", method: http.MethodPut, path: "/artists/1", token: pat, body: map[string]interface{}{"name": "ODESZA", "default_genre": "electronic"}, status: http.StatusOK},
+			{name: "but only within its scopes", method: http.MethodDelete, path: "/artists/1", token: pat, status: http.StatusForbidden},
+			{name: "it can not mint another token", method: http.MethodPost, path: "/user/current/tokens", token: pat,
+				body: map[string]interface{}{"name": "copy", "scopes": scopes}, status: http.StatusForbidden},
+			{name: "or change the profile", method: http.MethodPut, path: "/users", token: pat, body: map[string]interface{}{"first_name": "Ed"}, status: http.StatusForbidden},
+			{name: "or the password", method: http.MethodPut, path: "/user/current/password", token: pat,
+				body: map[string]interface{}{"current_password": testPassword, "new_password": "another long passphrase"}, status: http.StatusForbidden},
+			{name: "or two-factor", method: http.MethodPost, path: "/user/current/2fa", token: pat, status: http.StatusForbidden},
+			{name: "or list sessions", method: http.MethodGet, path: "/user/current/sessions", token: pat, status: http.StatusForbidden},
+			{name: "or sign out", method: http.MethodPost, path: "/signout", token: pat, status: http.StatusBadRequest},
+			{name: "the owner lists it", method: http.MethodGet, path: "/user/current/tokens", token: editor, status: http.StatusOK,
+				fields: map[string]interface{}{"count": float64(1)}},
+
+			// Requiring two-factor for editors narrows the token to a USER's permissions until it is enabled.
+			{name: "two-factor becomes required", method: http.MethodPut, path: "/settings/2fa", token: admin, body: map[string]interface{}{"required_for_role": "EDITOR"}, status: http.StatusOK},
+			{name: "the token still reads", method: http.MethodGet, path: "/artists/1", token: pat, status: http.StatusOK},
+			{name: "but its update scope is gone", method: http.MethodPut, path: "/artists/1", token: pat, body: map[string]interface{}{"name": "ODESZA"}, status: http.StatusForbidden},
+			{name: "two-factor is optional again", method: http.MethodPut, path: "/settings/2fa", token: admin, body: map[string]interface{}{"required_for_role": ""}, status: http.StatusOK},
+			{name: "and the scope is back", method: http.MethodPut, path: "/artists/1", token: pat, body: map[string]interface{}{"name": "ODESZA"}, status: http.StatusOK},
+
+			{name: "someone else's token", method: http.MethodDelete, path: "/user/current/tokens/1", token: admin, status: http.StatusNotFound},
+			{name: "the owner revokes it", method: http.MethodDelete, path: "/user/current/tokens/1", token: editor, status: http.StatusNoContent},
+			{name: "it no longer works", method: http.MethodGet, path: "/artists/1", token: pat, status: http.StatusUnauthorized},
+		})
+	})
+}
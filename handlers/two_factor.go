@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func GetTwoFactorStatus(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	status, customErr := auth.GetTwoFactorStatus(ctx, auth.GetClaims(c))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func EnrollTwoFactor(c *gin.Context) {
+	claims := auth.GetClaims(c)
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	secret, uri, customErr := auth.EnrollTwoFactor(ctx, claims)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"secret": secret, "otpauth_uri": uri})
+}
+
+func ConfirmTwoFactor(c *gin.Context) {
+	claims := auth.GetClaims(c)
+	request, ok := bindTwoFactorCode(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	codes, customErr := auth.ConfirmTwoFactor(ctx, claims, request.Code)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	// Refreshing picks up any permissions that were held back until two-factor was enabled.
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func DisableTwoFactor(c *gin.Context) {
+	claims := auth.GetClaims(c)
+	request, ok := bindTwoFactorCode(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	customErr := auth.DisableTwoFactor(ctx, claims, request.Code)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func RegenerateRecoveryCodes(c *gin.Context) {
+	claims := auth.GetClaims(c)
+	request, ok := bindTwoFactorCode(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	codes, customErr := auth.RegenerateRecoveryCodes(ctx, claims, request.Code)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func GetTwoFactorSettings(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	role, customErr := auth.GetTwoFactorRequiredRole(ctx)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"required_for_role": role})
+}
+
+func SetTwoFactorSettings(c *gin.Context) {
+	var request struct {
+		RequiredForRole string `json:"required_for_role"`
+	}
+	err := c.BindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind settings JSON"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	customErr := auth.SetTwoFactorRequiredRole(ctx, request.RequiredForRole)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	GetTwoFactorSettings(c)
+}
+
+func bindTwoFactorCode(c *gin.Context) (twoFactorCodeRequest, bool) {
+	var request twoFactorCodeRequest
+	err := c.BindJSON(&request)
+	if err != nil || request.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include code"})
+		return request, false
+	}
+
+	return request, true
+}
@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"database/sql"
 	"fmt"
 	"github.com/AnthonyNixon/setsisaw/auth"
 	"github.com/AnthonyNixon/setsisaw/database"
@@ -10,52 +9,19 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 func GetCurrentUser(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
-	if customErr != nil {
-		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
-		return
-	}
+	claims := auth.GetClaims(c)
 
-	if !auth.IsEntitled(claims, "USER") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to get user info.", claims.Username)})
-		return
-	}
+	// If we're here, the user is authorized to get their own info.
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
 
-	// If we're here, the user is authorized to get sets for themselves.
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer db.Close()
-
-	user_id, err := strconv.ParseInt(claims.Id, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not convert user_id to int, " + err.Error()})
-		return
-	}
-
-	result := db.QueryRow(database.GET_SPECIFIC_USER, user_id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not get user info from database, " + err.Error()})
-		return
-	}
-
-	var user types.User
-
-	err = result.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Role)
-	if err != nil {
-		// If an entry with the username does not exist, send an "Unauthorized"(401) status
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			return
-		}
-
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user, customErr := store.GetUser(ctx, claims.Id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
@@ -65,51 +31,23 @@ func GetCurrentUser(c *gin.Context) {
 func GetSpecificUser(c *gin.Context) {
 	id := c.Param("id")
 
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
-	if customErr != nil {
-		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
-		return
-	}
+	claims := auth.GetClaims(c)
 
 	if id != claims.Id {
-		// The user is trying to access another person, check if they're an editor or above.
-		if !auth.IsEntitled(claims, "EDITOR") {
-			c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to get user info for user ID %s", claims.Username, id)})
-			return
-		}
-	} else {
-		if !auth.IsEntitled(claims, "USER") {
-			c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to get user info.", claims.Username)})
+		// The user is trying to access another person.
+		if !auth.HasPermission(claims, auth.PERMISSION_USERS_READ_ANY) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("UserId %s is not entitled to get user info for user ID %s", claims.Username, id)})
 			return
 		}
 	}
 
 	// if we made it here, we're good to go.
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer db.Close()
-
-	result := db.QueryRow(database.GET_SPECIFIC_USER, id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not get user info from database, " + err.Error()})
-		return
-	}
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
 
-	var user types.User
-
-	err = result.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Role)
-	if err != nil {
-		// If an entry with the username does not exist, send an "Unauthorized"(401) status
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			return
-		}
-
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user, customErr := store.GetUser(ctx, id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
@@ -117,119 +55,146 @@ func GetSpecificUser(c *gin.Context) {
 }
 
 func GetAllUsers(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
+	// If we're here, the user is authorized to get all users.
+	page, customErr := getPageRequest(c)
 	if customErr != nil {
 		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	if !auth.IsEntitled(claims, "EDITOR") {
-		c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("User %s is not entitled to get all users.", claims.Username)})
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	users, info, customErr := store.GetAllUsers(ctx, page)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	// If we're here, the user is authorized to get all users.
+	c.JSON(http.StatusOK, gin.H{"users": users, "count": len(users), "total": info.Total, "next_cursor": info.NextCursor})
+}
 
-	user := types.User{}
-	users := make([]types.User, 0)
+func UpdateUser(c *gin.Context) {
+	claims := auth.GetClaims(c)
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
 
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	user, customErr := store.GetUser(ctx, claims.Id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
-	defer db.Close()
 
-	rows, err := db.Query(database.GET_ALL_USERS)
+	// Users can only edit their own profile, and never their id, role or whether their email is verified.
+	id, role, email, emailVerified := user.Id, user.Role, user.Email, user.EmailVerified
+	update := struct {
+		types.User
+		CurrentPassword string `json:"current_password"`
+	}{User: user}
+	err := c.BindJSON(&update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind user JSON"})
 		return
 	}
+	user = update.User
 
-	for rows.Next() {
-		err := rows.Scan(&user.Id, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Role)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if user.Id != id {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("UserId %s is not entitled to edit another user's info.", claims.Username)})
+		return
+	}
+	user.Role = role
+	user.Password = ""
+	emailChanged := !strings.EqualFold(user.Email, email)
+	user.EmailVerified = emailVerified && !emailChanged
+
+	// Password resets are mailed to the email, so changing it takes the password as well as a token.
+	if emailChanged {
+		if update.CurrentPassword == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Must include current_password to change email"})
 			return
 		}
-		users = append(users, user)
-	}
-	defer rows.Close()
 
-	c.JSON(http.StatusOK, gin.H{"users": users, "count": len(users)})
-}
+		_, customErr = auth.CheckCurrentPassword(ctx, claims, update.CurrentPassword)
+		if customErr != nil {
+			c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+			return
+		}
+	}
 
-func UpdateUser(c *gin.Context) {
-	// Check Auth info
-	claims, customErr := auth.GetUserInfo(c)
+	unique, customErr := store.IsUserUpdateUnique(ctx, user)
 	if customErr != nil {
-		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		c.JSON(customErr.StatusCode(), gin.H{"error": "could not determine if update is unique"})
 		return
 	}
 
-	var userUpdate types.User
-	err := c.BindJSON(&userUpdate)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind user JSON"})
+	if !unique {
+		c.JSON(http.StatusConflict, gin.H{"error": "username or email is already taken"})
 		return
 	}
 
-	if userUpdate.Id != claims.Username {
-		// They're trying to edit someone else.
-		if !auth.IsEntitled(claims, "EDITOR") {
-			c.JSON(http.StatusForbidden, gin.H{"Error": fmt.Sprintf("UserId %s is not entitled to edit another user's info.", claims.Username)})
-			return
-		}
+	customErr = store.UpdateUser(ctx, user)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
 	}
 
-	// If we're here, the user is authorized to edit their information (the should always be)
-	db, err := database.GetConnection()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if emailChanged {
+		customErr = auth.SendEmailVerification(ctx, user)
+		if customErr != nil {
+			log.Printf("could not send verification email to user %s: %s", user.Id, customErr.Description())
+		}
 	}
-	defer db.Close()
 
-	unique, err := isUserUpdateInfoUnique(userUpdate)
+	c.JSON(http.StatusOK, user)
+}
+
+func SetUserRole(c *gin.Context) {
+	id := c.Param("id")
+	claims := auth.GetClaims(c)
+
+	var roleUpdate struct {
+		Role string `json:"role"`
+	}
+	err := c.BindJSON(&roleUpdate)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not determine if update is unique"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not bind role JSON"})
 		return
 	}
 
-	if !unique {
-		c.JSON(http.StatusConflict, gin.H{"error": "username or email is already taken"})
+	role := strings.ToUpper(roleUpdate.Role)
+	if !auth.IsRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is not a role", roleUpdate.Role)})
 		return
 	}
 
-	stmt, err := db.Prepare(database.UPDATE_USER)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	user, customErr := store.GetUser(ctx, id)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	_, err = stmt.Exec(userUpdate.Username, userUpdate.Email, userUpdate.FirstName, userUpdate.LastName, userUpdate.Role, userUpdate.Id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+	if user.Role == role {
+		c.JSON(http.StatusOK, user)
 		return
 	}
 
-	c.JSON(http.StatusOK, userUpdate)
-}
-
-func isUserUpdateInfoUnique(user types.User) (bool, error) {
-	db, err := database.GetConnection()
+	userId, _ := strconv.Atoi(user.Id)
+	changedBy, err := strconv.Atoi(claims.Id)
 	if err != nil {
-		return false, err
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not convert user id to an int"})
+		return
 	}
-	defer db.Close()
 
-	var count int
-	err = db.QueryRow(database.IS_USER_UPDATE_UNIQUE, user.Id, user.Username, user.Email).Scan(&count)
-	if err != nil {
-		return false, err
+	customErr = store.ChangeUserRole(ctx, types.RoleChange{UserId: userId, ChangedBy: changedBy, OldRole: user.Role, NewRole: role})
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
 	}
 
-	log.Printf("Unique count: %d", count)
-	return count == 0, nil
+	log.Printf("User %s changed the role of user %s from %s to %s", claims.Username, user.Username, user.Role, role)
+	user.Role = role
+	c.JSON(http.StatusOK, user)
 }
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRoleChangeRevokesTokens(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		admin := api.user("admin", "ADMIN")
+		editor := api.user("editor", "EDITOR")
+		_, refreshToken := api.signIn("editor")
+
+		api.mustRequest(http.MethodPost, "/artists", editor, map[string]interface{}{"name": "ODESZA"}, http.StatusCreated)
+		response := api.mustRequest(http.MethodPost, "/user/current/tokens", editor, map[string]interface{}{"name": "script", "scopes": []string{"artists:update"}}, http.StatusCreated)
+		pat := response["token"].(string)
+
+		update := map[string]interface{}{"name": "ODESZA", "default_genre": "electronic"}
+		runSteps(t, api, []testStep{
+			{name: "editors can update artists", method: http.MethodPut, path: "/artists/1", token: editor, body: update, status: http.StatusOK},
+			{name: "so can their personal access tokens", method: http.MethodPut, path: "/artists/1", token: pat, body: update, status: http.StatusOK},
+			{name: "editors can not change roles", method: http.MethodPut, path: "/users/2/role", token: editor, body: map[string]interface{}{"role": "ADMIN"}, status: http.StatusForbidden},
+			{name: "or edit their own", method: http.MethodPut, path: "/users", token: editor, body: map[string]interface{}{"role": "ADMIN"}, status: http.StatusOK,
+				fields: map[string]interface{}{"role": "EDITOR"}},
+			{name: "an unknown role", method: http.MethodPut, path: "/users/2/role", token: admin, body: map[string]interface{}{"role": "OWNER"}, status: http.StatusBadRequest},
+			{name: "admin demotes the editor", method: http.MethodPut, path: "/users/2/role", token: admin, body: map[string]interface{}{"role": "user"}, status: http.StatusOK,
+				fields: map[string]interface{}{"role": "USER"}},
+			{name: "the old access token is revoked", method: http.MethodPut, path: "/artists/1", token: editor, body: update, status: http.StatusUnauthorized},
+			{name: "so is the personal access token", method: http.MethodPut, path: "/artists/1", token: pat, body: update, status: http.StatusUnauthorized},
+			{name: "and every session", method: http.MethodPost, path: "/refresh", body: map[string]interface{}{"refresh_token": refreshToken}, status: http.StatusUnauthorized},
+			{name: "the admin's token still works", method: http.MethodPut, path: "/artists/1", token: admin, body: update, status: http.StatusOK},
+		})
+
+		// Signing in again only grants a USER's scopes.
+		token, _ := api.signIn("editor")
+		runSteps(t, api, []testStep{
+			{name: "a new sign in is a user", method: http.MethodPut, path: "/artists/1", token: token, body: update, status: http.StatusForbidden},
+			{name: "who can still read artists", method: http.MethodGet, path: "/artists/1", token: token, status: http.StatusOK},
+		})
+	})
+}
+
+func TestChangeEmail(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, api *testApi) {
+		ida := api.user("ida", "USER")
+		api.user("eve", "USER")
+
+		runSteps(t, api, []testStep{
+			{name: "other fields need no password", method: http.MethodPut, path: "/users", token: ida, body: map[string]interface{}{"first_name": "Ida"}, status: http.StatusOK,
+				fields: map[string]interface{}{"first_name": "Ida", "email_verified": true}},
+			{name: "nor does changing the case of the email", method: http.MethodPut, path: "/users", token: ida, body: map[string]interface{}{"email": "IDA@example.com"}, status: http.StatusOK,
+				fields: map[string]interface{}{"email_verified": true}},
+			{name: "a new email needs the current password", method: http.MethodPut, path: "/users", token: ida, body: map[string]interface{}{"email": "new@example.com"}, status: http.StatusBadRequest},
+			{name: "the right one", method: http.MethodPut, path: "/users", token: ida, body: map[string]interface{}{"email": "new@example.com", "current_password": "wrong password"}, status: http.StatusForbidden},
+			{name: "someone else's email", method: http.MethodPut, path: "/users", token: ida, body: map[string]interface{}{"email": "eve@example.com", "current_password": testPassword}, status: http.StatusConflict},
+			{name: "change it", method: http.MethodPut, path: "/users", token: ida, body: map[string]interface{}{"email": "new@example.com", "current_password": testPassword}, status: http.StatusOK,
+				fields: map[string]interface{}{"email": "new@example.com", "email_verified": false}},
+			{name: "which is no longer verified", method: http.MethodGet, path: "/user/current", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"email_verified": false}},
+		})
+		first := api.mailedToken("new@example.com")
+
+		api.mustRequest(http.MethodPut, "/users", ida, map[string]interface{}{"email": "newer@example.com", "current_password": testPassword}, http.StatusOK)
+		second := api.mailedToken("newer@example.com")
+
+		runSteps(t, api, []testStep{
+			{name: "a token for an earlier email", method: http.MethodGet, path: "/verify-email?token=" + first, status: http.StatusBadRequest},
+			{name: "does not verify the current one", method: http.MethodGet, path: "/user/current", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"email": "newer@example.com", "email_verified": false}},
+			{name: "the token for it", method: http.MethodGet, path: "/verify-email?token=" + second, status: http.StatusOK},
+			{name: "does", method: http.MethodGet, path: "/user/current", token: ida, status: http.StatusOK,
+				fields: map[string]interface{}{"email_verified": true}},
+			{name: "and works once", method: http.MethodGet, path: "/verify-email?token=" + second, status: http.StatusBadRequest},
+			{name: "an unknown token", method: http.MethodGet, path: "/verify-email?token=unknown", status: http.StatusBadRequest},
+		})
+	})
+}
@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users, e.g. password reset links.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Initialize sets up the mailer chosen by SETSISAW_MAILER, one of "log" (the default), "file"
+// or "smtp".
+func Initialize() Mailer {
+	log.Print("Initializing Mailer...")
+	kind := os.Getenv("SETSISAW_MAILER")
+
+	switch kind {
+	case "", "log":
+		log.Print("Using the log mailer, messages are written to the log instead of being sent")
+		return LogMailer{}
+	case "file":
+		path := os.Getenv("SETSISAW_MAIL_FILE")
+		if path == "" {
+			path = DEFAULT_MAIL_FILE
+		}
+		log.Printf("Using the file mailer, messages are appended to %s", path)
+		return FileMailer{Path: path}
+	case "smtp":
+		return newSMTPMailer()
+	default:
+		log.Fatalf("Unknown SETSISAW_MAILER %q. Not Starting.", kind)
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const DEFAULT_MAIL_FILE = "mail.log"
+
+// LogMailer writes messages to the log, for local development.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, message Message) error {
+	log.Printf("Mail to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FileMailer appends messages to a file, for local development and tests that need to read
+// what was sent.
+type FileMailer struct {
+	Path string
+}
+
+var fileMu sync.Mutex
+
+func (m FileMailer) Send(ctx context.Context, message Message) error {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), message.To, message.Subject, message.Body)
+	return err
+}
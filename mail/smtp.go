@@ -0,0 +1,147 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+const DEFAULT_SMTP_PORT = "587"
+
+// SMTPMailer sends messages through an SMTP server, authenticating with PLAIN auth when a
+// username is set. Connections are upgraded to TLS when the server offers STARTTLS.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func newSMTPMailer() SMTPMailer {
+	mailer := SMTPMailer{
+		Host:     os.Getenv("SETSISAW_SMTP_HOST"),
+		Port:     os.Getenv("SETSISAW_SMTP_PORT"),
+		Username: os.Getenv("SETSISAW_SMTP_USER"),
+		Password: os.Getenv("SETSISAW_SMTP_PASS"),
+		From:     os.Getenv("SETSISAW_MAIL_FROM"),
+	}
+
+	if mailer.Port == "" {
+		mailer.Port = DEFAULT_SMTP_PORT
+	}
+
+	if mailer.Host == "" || mailer.From == "" {
+		log.Fatal("SETSISAW_SMTP_HOST and SETSISAW_MAIL_FROM are required for the smtp mailer. Not Starting.")
+	}
+
+	log.Printf("Sending mail through %s:%s", mailer.Host, mailer.Port)
+	return mailer
+}
+
+func (m SMTPMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// Header values come from our own templates and user emails, strip newlines so neither can
+	// add headers.
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		clean.Replace(m.From), clean.Replace(message.To), clean.Replace(message.Subject), time.Now().Format(time.RFC1123Z), message.Body)
+
+	err := m.sendMail(ctx, auth, message.To, []byte(body))
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// sendMail does what smtp.SendMail does, but gives up once ctx is done so a hung server can not
+// hold on to the sending goroutine.
+func (m SMTPMailer) sendMail(ctx context.Context, auth smtp.Auth, to string, body []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.Host, m.Port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Closing the connection unblocks whatever the client is waiting on when ctx is cancelled.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: m.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+
+		err = client.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(m.From)
+	if err != nil {
+		return err
+	}
+
+	err = client.Rcpt(to)
+	if err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(body)
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
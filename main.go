@@ -5,27 +5,47 @@ import (
 	"github.com/AnthonyNixon/setsisaw/auth"
 	"github.com/AnthonyNixon/setsisaw/database"
 	"github.com/AnthonyNixon/setsisaw/handlers"
+	"github.com/AnthonyNixon/setsisaw/mail"
 	"github.com/AnthonyNixon/setsisaw/users"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
 var PORT = ""
 
-func init() {
-	database.Initialize()
-	auth.Initialize()
+func main() {
+	store := database.Initialize()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(store, os.Args[2:])
+		return
+	}
+
+	// SQLite databases are usually created on the spot, so they are always brought up to date.
+	if os.Getenv("SETSISAW_AUTO_MIGRATE") == "true" || os.Getenv("SETSISAW_DB_DRIVER") == "sqlite" {
+		autoMigrate(store)
+	}
+
+	auth.Initialize(store, mail.Initialize())
+	users.Initialize(store)
+	handlers.Initialize(store)
 	PORT = os.Getenv("PORT")
 	if PORT == "" {
 		PORT = "8080"
 	}
-}
 
-func main() {
 	r := gin.Default()
+
+	// Sign in throttling counts failures per client IP, so X-Forwarded-For is only believed when
+	// it was set by one of the configured proxies.
+	err := r.SetTrustedProxies(trustedProxies())
+	if err != nil {
+		log.Fatalf("invalid SETSISAW_TRUSTED_PROXIES: %s", err.Error())
+	}
 	r.Use(cors.New(cors.Config{
 		AllowMethods:     []string{"POST", "GET", "PUT", "DELETE", "HEAD", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
@@ -34,38 +54,112 @@ func main() {
 		MaxAge:           12 * time.Hour,
 	}))
 
-	r.POST("/signup", users.SignUp)
-	r.POST("/signin", users.SignIn)
-	r.GET("/refresh", handlers.RefreshToken)
+	// Routes on the authed group need a valid bearer token, most also declare the permission
+	// they need so handlers only run for callers that were granted it. Routes that manage the
+	// account itself also refuse personal access tokens.
+	public := r.Group("/")
+	authed := r.Group("/", auth.RequireAuth())
+
+	public.POST("/signup", users.SignUp)
+	public.POST("/signin", users.SignIn)
+	public.POST("/signin/2fa", users.SignInTwoFactor)
+	public.GET("/auth/oidc/login", users.OidcLogin)
+	public.GET("/auth/oidc/callback", users.OidcCallback)
+	public.POST("/refresh", handlers.RefreshToken)
+	public.POST("/password/forgot", users.ForgotPassword)
+	public.POST("/password/reset", users.ResetPassword)
+	public.GET("/verify-email", users.VerifyEmail)
+	public.POST("/verify-email/resend", users.ResendEmailVerification)
+	public.GET("/.well-known/jwks.json", handlers.GetJWKS)
 
-	r.GET("/authcheck", handlers.AuthCheck)
+	authed.POST("/signout", users.SignOut)
+	authed.GET("/authcheck", handlers.AuthCheck)
 
 	// Users
-	r.GET("/users", handlers.GetAllUsers)
-	r.GET("/user/current", handlers.GetCurrentUser)
-	r.GET("/users/:id", handlers.GetSpecificUser)
-	r.PUT("/users", handlers.UpdateUser)
+	authed.GET("/users", auth.RequirePermission(auth.PERMISSION_USERS_READ_ANY), handlers.GetAllUsers)
+	authed.GET("/user/current", handlers.GetCurrentUser)
+	authed.GET("/users/:id", handlers.GetSpecificUser)
+	authed.PUT("/users", auth.RequireInteractiveSession(), handlers.UpdateUser)
+	authed.PUT("/users/:id/role", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), handlers.SetUserRole)
+
+	// Account security
+	authed.PUT("/user/current/password", auth.RequireInteractiveSession(), users.ChangePassword)
+	authed.GET("/user/current/2fa", handlers.GetTwoFactorStatus)
+	authed.POST("/user/current/2fa", auth.RequireInteractiveSession(), handlers.EnrollTwoFactor)
+	authed.POST("/user/current/2fa/confirm", auth.RequireInteractiveSession(), handlers.ConfirmTwoFactor)
+	authed.POST("/user/current/2fa/recovery-codes", auth.RequireInteractiveSession(), handlers.RegenerateRecoveryCodes)
+	authed.DELETE("/user/current/2fa", auth.RequireInteractiveSession(), handlers.DisableTwoFactor)
+	authed.POST("/user/current/tokens", auth.RequireInteractiveSession(), handlers.CreateApiToken)
+	authed.GET("/user/current/tokens", handlers.GetCurrentUserApiTokens)
+	authed.DELETE("/user/current/tokens/:id", handlers.RevokeCurrentUserApiToken)
+	authed.GET("/settings/2fa", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), handlers.GetTwoFactorSettings)
+	authed.PUT("/settings/2fa", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), handlers.SetTwoFactorSettings)
+	authed.GET("/lockouts", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), handlers.GetLockouts)
+	authed.DELETE("/lockouts/:kind/:value", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), handlers.ClearLockout)
+
+	// Sessions
+	authed.GET("/user/current/sessions", auth.RequireInteractiveSession(), handlers.GetCurrentUserSessions)
+	authed.DELETE("/user/current/sessions/:id", auth.RequireInteractiveSession(), handlers.RevokeCurrentUserSession)
+	authed.DELETE("/users/:id/sessions", auth.RequirePermission(auth.PERMISSION_USERS_MANAGE), handlers.RevokeUserSessions)
 
 	// Artists
-	r.POST("/artists", handlers.NewArtist)
-	r.GET("/artists", handlers.GetAllArtists)
-	r.GET("/artists/:id", handlers.GetArtist)
+	authed.POST("/artists", auth.RequirePermission(auth.PERMISSION_ARTISTS_CREATE), auth.RequireVerifiedEmail(), handlers.NewArtist)
+	authed.GET("/artists", auth.RequirePermission(auth.PERMISSION_ARTISTS_READ), handlers.GetAllArtists)
+	authed.GET("/artists/search", auth.RequirePermission(auth.PERMISSION_ARTISTS_READ), handlers.SearchArtists)
+	authed.GET("/artists/:id", auth.RequirePermission(auth.PERMISSION_ARTISTS_READ), handlers.GetArtist)
+	authed.PUT("/artists/:id", auth.RequirePermission(auth.PERMISSION_ARTISTS_UPDATE), handlers.UpdateArtist)
+	authed.DELETE("/artists/:id", auth.RequirePermission(auth.PERMISSION_ARTISTS_DELETE), handlers.DeleteArtist)
+	authed.POST("/artists/:id/merge", auth.RequirePermission(auth.PERMISSION_ARTISTS_MERGE), handlers.MergeArtists)
+	authed.GET("/artists/:id/aliases", auth.RequirePermission(auth.PERMISSION_ARTISTS_READ), handlers.GetArtistAliases)
+	authed.POST("/artists/:id/aliases", auth.RequirePermission(auth.PERMISSION_ARTISTS_UPDATE), handlers.NewArtistAlias)
+	authed.DELETE("/artists/:id/aliases/:alias_id", auth.RequirePermission(auth.PERMISSION_ARTISTS_UPDATE), handlers.DeleteArtistAlias)
 
 	// Locations
-	r.POST("/locations", handlers.NewLocation)
-	r.GET("/locations", handlers.GetAllLocations)
-	r.GET("/locations/:id", handlers.GetLocation)
-	r.PUT("/locations/:id", handlers.UpdateLocation)
+	authed.POST("/locations", auth.RequirePermission(auth.PERMISSION_LOCATIONS_CREATE), auth.RequireVerifiedEmail(), handlers.NewLocation)
+	authed.GET("/locations", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), handlers.GetAllLocations)
+	authed.GET("/locations/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), handlers.GetLocation)
+	authed.PUT("/locations/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), handlers.UpdateLocation)
+	authed.DELETE("/locations/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_DELETE), handlers.DeleteLocation)
+	authed.POST("/locations/:id/merge", auth.RequirePermission(auth.PERMISSION_LOCATIONS_MERGE), handlers.MergeLocations)
+	authed.GET("/locations/:id/stages", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), handlers.GetStages)
+	authed.POST("/locations/:id/stages", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), handlers.NewStage)
+	authed.PUT("/locations/:id/stages/:stage_id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), handlers.UpdateStage)
+	authed.DELETE("/locations/:id/stages/:stage_id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), handlers.DeleteStage)
 
-	// Sets
-	r.POST("/sets", handlers.NewSet)
-	r.GET("/sets", handlers.GetSetsForCurrentUser)
-	r.GET("/sets/all", handlers.GetAllSets)
+	// Festival series, each edition is a festival location.
+	authed.POST("/festivals", auth.RequirePermission(auth.PERMISSION_LOCATIONS_CREATE), auth.RequireVerifiedEmail(), handlers.NewFestival)
+	authed.GET("/festivals", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), handlers.GetAllFestivals)
+	authed.GET("/festivals/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), handlers.GetFestival)
+	authed.PUT("/festivals/:id", auth.RequirePermission(auth.PERMISSION_LOCATIONS_UPDATE), handlers.UpdateFestival)
+	authed.GET("/festivals/:id/editions", auth.RequirePermission(auth.PERMISSION_LOCATIONS_READ), handlers.GetFestivalEditions)
+	authed.POST("/festivals/:id/editions", auth.RequirePermission(auth.PERMISSION_LOCATIONS_CREATE), auth.RequireVerifiedEmail(), handlers.NewFestivalEdition)
+	authed.GET("/festivals/:id/sets", auth.RequirePermission(auth.PERMISSION_SETS_READ), handlers.GetFestivalSets)
+
+	// Sets, handlers check whether the caller may touch a specific set.
+	authed.POST("/sets", auth.RequirePermission(auth.PERMISSION_SETS_WRITE), handlers.NewSet)
+	authed.GET("/sets", auth.RequirePermission(auth.PERMISSION_SETS_READ), handlers.GetSetsForCurrentUser)
+	authed.GET("/sets/all", auth.RequirePermission(auth.PERMISSION_SETS_READ_ANY), handlers.GetAllSets)
+	authed.GET("/sets/:id", handlers.GetSet)
+	authed.PUT("/sets/:id", handlers.UpdateSet)
+	authed.DELETE("/sets/:id", handlers.DeleteSet)
 
 	log.Printf("Running SetsISaw API on :%s...", PORT)
 
-	err := r.Run(fmt.Sprintf(":%s", PORT)) // listen and serve on 0.0.0.0:8080
+	err = r.Run(fmt.Sprintf(":%s", PORT)) // listen and serve on 0.0.0.0:8080
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 }
+
+// trustedProxies returns the addresses or CIDR ranges in SETSISAW_TRUSTED_PROXIES, or nil to
+// trust none and always use the connection's remote address.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("SETSISAW_TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"log"
+	"strconv"
+)
+
+const MIGRATE_USAGE = "usage: setsisaw-api migrate [up | down [steps] | status | force <version> applied|pending]"
+
+func runMigrateCommand(store database.Store, args []string) {
+	sqlStore := migrationStore(store)
+	if sqlStore == nil {
+		log.Fatal("Migrations only apply to SQL database drivers.")
+	}
+
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		count, err := database.Migrate(ctx, sqlStore)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		log.Printf("Applied %d migration(s)", count)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatal(MIGRATE_USAGE)
+			}
+		}
+
+		count, err := database.Rollback(ctx, sqlStore, steps)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		log.Printf("Reverted %d migration(s)", count)
+	case "status":
+		states, err := database.MigrationStatus(ctx, sqlStore)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		for _, state := range states {
+			status := "pending"
+			if state.Dirty {
+				status = "dirty"
+			} else if state.Applied {
+				status = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", state.Version, state.Name, status)
+		}
+	case "force":
+		if len(args) != 3 || (args[2] != "applied" && args[2] != "pending") {
+			log.Fatal(MIGRATE_USAGE)
+		}
+
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatal(MIGRATE_USAGE)
+		}
+
+		err = database.Force(ctx, sqlStore, version, args[2] == "applied")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		log.Printf("Marked migration %04d %s", version, args[2])
+	default:
+		log.Fatal(MIGRATE_USAGE)
+	}
+}
+
+func autoMigrate(store database.Store) {
+	sqlStore := migrationStore(store)
+	if sqlStore == nil {
+		return
+	}
+
+	log.Print("Running database migrations...")
+	count, err := database.Migrate(context.Background(), sqlStore)
+	if err != nil {
+		log.Fatal("Failed to migrate database. Not Starting. " + err.Error())
+	}
+	log.Printf("done, applied %d migration(s)", count)
+}
+
+// migrationStore returns store as a SQL store, or nil when the store is not SQL backed.
+func migrationStore(store database.Store) *database.SQLStore {
+	sqlStore, ok := store.(*database.SQLStore)
+	if !ok {
+		return nil
+	}
+
+	return sqlStore
+}
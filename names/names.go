@@ -0,0 +1,155 @@
+// Package names compares artist names the way people write them, so "The Chemical Brothers",
+// "chemical brothers" and "Chemical Bröthers" are the same artist and "Odeza" is close to "ODESZA".
+// It also finds the festival series in the name of one of its editions.
+package names
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// foldedRunes spells letters with diacritics, and a few ligatures, with plain ASCII letters.
+var foldedRunes = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'ç': "c", 'ć': "c", 'ĉ': "c", 'ċ': "c", 'č': "c",
+	'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ĕ': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ĝ': "g", 'ğ': "g", 'ġ': "g", 'ģ': "g",
+	'ĥ': "h", 'ħ': "h",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ĩ': "i", 'ī': "i", 'ĭ': "i", 'į': "i", 'ı': "i",
+	'ĵ': "j", 'ķ': "k",
+	'ĺ': "l", 'ļ': "l", 'ľ': "l", 'ŀ': "l", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ņ': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ŏ': "o", 'ő': "o",
+	'ŕ': "r", 'ŗ': "r", 'ř': "r",
+	'ś': "s", 'ŝ': "s", 'ş': "s", 'š': "s", 'ș': "s",
+	'ţ': "t", 'ť': "t", 'ŧ': "t", 'ț': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ũ': "u", 'ū': "u", 'ŭ': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ŵ': "w", 'ý': "y", 'ÿ': "y", 'ŷ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'þ': "th",
+	'&': " and ", '+': " and ",
+}
+
+// Normalize returns the form of name that is compared: lower case, without diacritics or
+// punctuation, "&" spelled "and", and without a leading "The". Names that are only "The" keep it.
+func Normalize(name string) string {
+	var folded strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if replacement, ok := foldedRunes[r]; ok {
+			folded.WriteString(replacement)
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			folded.WriteRune(r)
+		} else if unicode.IsSpace(r) || r == '-' || r == '_' || r == '/' {
+			folded.WriteRune(' ')
+		}
+		// Anything else, like apostrophes and dots, is dropped so "Guns N' Roses" matches "Guns N Roses".
+	}
+
+	words := strings.Fields(folded.String())
+	if len(words) > 1 && words[0] == "the" {
+		words = words[1:]
+	}
+
+	normalized := strings.Join(words, " ")
+	if normalized == "" {
+		// Names that are all punctuation, like "!!!", are compared as they are.
+		return strings.ToLower(strings.TrimSpace(name))
+	}
+
+	return normalized
+}
+
+// Similarity scores how alike two normalized names are, from 0 to 1 for the same name. A
+// query that starts the name, or one of its words, scores high so it works for autocomplete;
+// otherwise the score falls with the edit distance.
+func Similarity(query string, name string) float64 {
+	if query == "" || name == "" {
+		return 0
+	}
+
+	if query == name {
+		return 1
+	}
+
+	coverage := float64(len(query)) / float64(len(name))
+	if strings.HasPrefix(name, query) {
+		return 0.8 + 0.19*coverage
+	}
+
+	if strings.Contains(" "+name, " "+query) {
+		return 0.7 + 0.19*coverage
+	}
+
+	a, b := []rune(query), []rune(name)
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+
+	return 0.95 * (1 - float64(distance(a, b))/float64(longest))
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	smallest := values[0]
+	for _, value := range values[1:] {
+		if value < smallest {
+			smallest = value
+		}
+	}
+
+	return smallest
+}
+
+// FestivalSeries returns the name of the festival series an edition called name belongs to, which
+// is name without the edition's year: "Bonnaroo 2019" and "2019 Bonnaroo" are both "Bonnaroo".
+// When year is 0 any year from 1900 on is removed.
+func FestivalSeries(name string, year int) string {
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return strings.TrimSpace(name)
+	}
+
+	isYear := func(word string) bool {
+		value, err := strconv.Atoi(strings.Trim(word, "'(),-"))
+		if err != nil {
+			return false
+		}
+		if year != 0 {
+			return value == year
+		}
+		return value >= 1900 && value <= 2999
+	}
+
+	if isYear(words[len(words)-1]) {
+		words = words[:len(words)-1]
+	} else if isYear(words[0]) {
+		words = words[1:]
+	}
+
+	return strings.TrimRight(strings.Join(words, " "), " -,:")
+}
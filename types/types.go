@@ -12,6 +12,8 @@ type User struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
 	Role      string `json:"role"`
+	// EmailVerified is set once the user follows the link mailed to Email.
+	EmailVerified bool `json:"email_verified"`
 }
 
 type Artist struct {
@@ -27,6 +29,49 @@ type SetMetadata struct {
 	Notes  string `json:"notes"`
 }
 
+// ArtistAlias is another name an artist is known by.
+type ArtistAlias struct {
+	Id        int    `json:"id"`
+	ArtistId  int    `json:"artist_id"`
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// ArtistName is one of the names an artist can be found by, their own or an alias.
+type ArtistName struct {
+	Artist         Artist
+	Name           string
+	NormalizedName string
+}
+
+// ArtistMatch is an artist found by name, Score is how close MatchedName is to what was asked
+// for, from 0 to 1.
+type ArtistMatch struct {
+	Artist
+	MatchedName string  `json:"matched_name"`
+	Score       float64 `json:"score"`
+}
+
+// ArtistMerge reports what merging duplicate artists into ArtistId did. A set of a duplicate is
+// removed rather than moved when its user already has the same set for ArtistId.
+type ArtistMerge struct {
+	ArtistId             int   `json:"artist_id"`
+	MergedArtistIds      []int `json:"merged_artist_ids"`
+	SetsMoved            int   `json:"sets_moved"`
+	DuplicateSetsRemoved int   `json:"duplicate_sets_removed"`
+}
+
+// LocationMerge reports what merging duplicate locations into LocationId did. A set of a
+// duplicate is removed rather than moved when its user already has the same set at LocationId.
+type LocationMerge struct {
+	LocationId           int   `json:"location_id"`
+	MergedLocationIds    []int `json:"merged_location_ids"`
+	SetsMoved            int   `json:"sets_moved"`
+	DuplicateSetsRemoved int   `json:"duplicate_sets_removed"`
+}
+
+// Set is an artist a user saw. StageId is optional, it must be a stage of LocationId and an
+// update with 0 clears it.
 type Set struct {
 	Id           int         `json:"id"`
 	UserId       int         `json:"user_id"`
@@ -34,10 +79,19 @@ type Set struct {
 	ArtistName   string      `json:"artist_name"`
 	LocationId   int         `json:"location_id"`
 	LocationName string      `json:"location_name"`
+	StageId      int         `json:"stage_id,omitempty"`
+	StageName    string      `json:"stage_name,omitempty"`
 	Date         string      `json:"date"`
 	Metadata     SetMetadata `json:"metadata"`
 }
 
+// Stage is one of the stages of a venue or festival edition.
+type Stage struct {
+	Id         int    `json:"id"`
+	LocationId int    `json:"location_id"`
+	Name       string `json:"name"`
+}
+
 type Location struct {
 	Id          int    `json:"id"`
 	Name        string `json:"name"`
@@ -47,12 +101,158 @@ type Location struct {
 	Country     string `json:"country"`
 	IsFestival  bool   `json:"is_festival"`
 	Year        int    `json:"year"`
+	// A festival location is one edition of the festival series FestivalId.
+	FestivalId int    `json:"festival_id,omitempty"`
+	StartDate  string `json:"start_date,omitempty"`
+	EndDate    string `json:"end_date,omitempty"`
+	Venue      string `json:"venue,omitempty"`
+}
+
+// Festival is a festival series, like Bonnaroo. Each year's edition is a festival Location.
+type Festival struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// RoleChange records an admin changing a user's role.
+type RoleChange struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"user_id"`
+	ChangedBy int    `json:"changed_by"`
+	OldRole   string `json:"old_role"`
+	NewRole   string `json:"new_role"`
+	ChangedAt string `json:"changed_at"`
+}
+
+// Session is one refresh token issued to a user's device. Times are unix seconds and
+// RevokedAt is 0 while the session is active.
+type Session struct {
+	Id         int    `json:"id"`
+	UserId     int    `json:"user_id"`
+	FamilyId   string `json:"-"`
+	TokenHash  string `json:"-"`
+	UserAgent  string `json:"user_agent"`
+	IpAddress  string `json:"ip_address"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+	RevokedAt  int64  `json:"-"`
+	// The access token issued along with this session's refresh token.
+	AccessTokenId   string `json:"-"`
+	AccessExpiresAt int64  `json:"-"`
+	// Current is set when listing sessions for the session the request was made from.
+	Current bool `json:"current"`
+}
+
+// ApiToken is a personal access token a user created for scripts. Times are unix seconds,
+// LastUsedAt is 0 until the token is first used.
+type ApiToken struct {
+	Id         int      `json:"id"`
+	UserId     int      `json:"user_id"`
+	Name       string   `json:"name"`
+	TokenHash  string   `json:"-"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	LastUsedAt int64    `json:"last_used_at"`
+	RevokedAt  int64    `json:"-"`
+}
+
+// PasswordReset is a one time token mailed to a user who forgot their password. Times are unix
+// seconds, UsedAt is 0 until the token is used.
+type PasswordReset struct {
+	Id        int
+	UserId    int
+	TokenHash string
+	CreatedAt int64
+	ExpiresAt int64
+	UsedAt    int64
+}
+
+// EmailVerification is a one time token mailed to confirm a user owns Email. Times are unix
+// seconds, UsedAt is 0 until the token is used.
+type EmailVerification struct {
+	Id        int
+	UserId    int
+	Email     string
+	TokenHash string
+	CreatedAt int64
+	ExpiresAt int64
+	UsedAt    int64
+}
+
+// UserIdentity links a user to the account Subject at the OpenID Connect provider Issuer.
+// Email is what the provider last said the account's email was. Times are unix seconds.
+type UserIdentity struct {
+	Id          int
+	UserId      int
+	Issuer      string
+	Subject     string
+	Email       string
+	CreatedAt   int64
+	LastLoginAt int64
+}
+
+// TwoFactor is a user's TOTP secret. EnabledAt is 0 until the user confirms enrollment with a
+// code, LastUsedStep is the last time step a code was accepted for.
+type TwoFactor struct {
+	UserId       int
+	Secret       string
+	CreatedAt    int64
+	EnabledAt    int64
+	LastUsedStep int64
+}
+
+// LoginThrottle counts recent failed sign ins for a username or an IP address, including ones
+// still being checked. Times are unix seconds, LockedUntil is 0 unless the key is locked out.
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt int64
+	LockedUntil   int64
+}
+
+// PageRequest asks a list endpoint for one page of results. Cursor is the next_cursor of the
+// previous page and Sort is a field name, prefixed with "-" for descending order.
+type PageRequest struct {
+	Limit  int
+	Cursor string
+	Sort   string
+}
+
+type PageInfo struct {
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// SetFilter narrows a set listing, zero values mean "don't filter on this".
+type SetFilter struct {
+	UserId     int
+	ArtistId   int
+	LocationId int
+	FestivalId int
+	StageId    int
+	Stage      string
+	Genre      string
+	MinRating  int
+	From       string
+	To         string
 }
 
 type Claims struct {
-	Username string `json:"username"`
-	Role     string `json:"role"`
-	Id       string `json:"id"`
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Id       string   `json:"id"`
+	Scopes   []string `json:"scopes"`
+	// SessionId is the family id of the session the token was issued for, if any. The token's
+	// own id is the standard jti claim, StandardClaims.Id.
+	SessionId string `json:"sid,omitempty"`
+	// ApiTokenId is set instead when the request was authenticated with a personal access token.
+	ApiTokenId int `json:"api_token_id,omitempty"`
+	// EnrollTwoFactor is set when the user's role requires two-factor authentication and they
+	// have not enabled it, Scopes are limited to a USER's until they do.
+	EnrollTwoFactor bool `json:"enroll_2fa,omitempty"`
 	jwt.StandardClaims
 }
 
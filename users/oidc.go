@@ -0,0 +1,51 @@
+package users
+
+import (
+	"github.com/AnthonyNixon/setsisaw/auth"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OidcLogin sends the browser to the identity provider to log in.
+func OidcLogin(c *gin.Context) {
+	location, cookie, customErr := auth.StartOidcLogin(c.Request.Context())
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	http.SetCookie(c.Writer, cookie)
+	c.Redirect(http.StatusFound, location)
+}
+
+// OidcCallback is where the identity provider sends the browser back to, it responds like SignIn.
+func OidcCallback(c *gin.Context) {
+	loginCookie, _ := c.Cookie(auth.OIDC_LOGIN_COOKIE)
+	http.SetCookie(c.Writer, auth.ClearOidcLoginCookie())
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": strings.TrimSpace("the identity provider refused the login: " + providerErr + " " + c.Query("error_description"))})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include code"})
+		return
+	}
+
+	// The provider gets its own timeout, so only the database calls come out of the query timeout.
+	user, customErr := auth.CompleteOidcLogin(c.Request.Context(), loginCookie, c.Query("state"), code)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	finishSignIn(ctx, c, user, auth.GetDevice(c))
+}
@@ -1,25 +1,31 @@
 package users
 
 import (
-	"database/sql"
+	"context"
 	"github.com/AnthonyNixon/setsisaw/auth"
-	"github.com/AnthonyNixon/setsisaw/customerrors"
 	"github.com/AnthonyNixon/setsisaw/database"
 	"github.com/AnthonyNixon/setsisaw/types"
+	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // JWT Auth https://www.sohamkamani.com/blog/golang/2019-01-01-jwt-authentication/
 
+var store database.UserStore
+
+func Initialize(userStore database.UserStore) {
+	store = userStore
+}
+
 func SignUp(c *gin.Context) {
 	// https://www.sohamkamani.com/blog/2018/02/25/golang-password-authentication-and-storage/
 	var newUser types.User
 	err := c.BindJSON(&newUser)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Bad JSON Input, could not bind."})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad JSON Input, could not bind."})
 		return
 	}
 
@@ -28,52 +34,54 @@ func SignUp(c *gin.Context) {
 		return
 	}
 
-	unique, err := isNewUserUnique(newUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error2": err.Error()})
+	customErr := auth.ValidatePassword(newUser.Password, newUser)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	if !unique {
-		c.JSON(http.StatusBadRequest, gin.H{"error3": "Username or email is already taken"})
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	unique, customErr := store.IsUserUnique(ctx, newUser.Username, newUser.Email)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	// Salt and hash the password using the bcrypt algorithm
-	// The second argument is the cost of hashing, which we arbitrarily set as 8 (this value can be more or less, depending on the computing power you wish to utilize)
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), 8)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error4": err.Error()})
+	if !unique {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username or email is already taken"})
 		return
 	}
 
-	db, err := database.GetConnection()
+	// Salt and hash the password using the bcrypt algorithm
+	newUser.Password, err = auth.HashPassword(newUser.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error5": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer db.Close()
 
-	stmt, err := db.Prepare("insert into users (username, email, password, first_name, last_name) values(?,?,?,?,?);")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+	newUser.EmailVerified = false
+	id, customErr := store.CreateUser(ctx, newUser)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
 	}
 
-	_, err = stmt.Exec(newUser.Username, newUser.Email, hashedPassword, newUser.FirstName, newUser.LastName)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
-		return
+	newUser.Id = strconv.Itoa(id)
+	customErr = auth.SendEmailVerification(ctx, newUser)
+	if customErr != nil {
+		log.Printf("could not send verification email to user %s: %s", newUser.Id, customErr.Description())
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"username": newUser.Username, "email": newUser.Email})
+	c.JSON(http.StatusCreated, gin.H{"username": newUser.Username, "email": newUser.Email, "email_verified": false})
 }
 
 func SignIn(c *gin.Context) {
 	var userAuth types.User
 	err := c.BindJSON(&userAuth)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad JSON Input, could not bind."})
 		return
 	}
 
@@ -82,72 +90,234 @@ func SignIn(c *gin.Context) {
 		return
 	}
 
-	authenticated, err := auth.IsAuthed(userAuth.Username, userAuth.Password)
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	device := auth.GetDevice(c)
+	customErr := auth.BeginLoginAttempt(ctx, userAuth.Username, device.IpAddress)
+	if customErr != nil {
+		throttledError(c, customErr)
+		return
+	}
+
+	authenticated, err := auth.IsAuthed(ctx, userAuth.Username, userAuth.Password)
 	if err != nil {
+		auth.CancelLoginAttempt(ctx, userAuth.Username, device.IpAddress)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "authentication failed"})
 		return
 	}
 
+	if !authenticated {
+		auth.RecordLoginFailure(ctx, userAuth.Username, device.IpAddress)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login incorrect"})
+		return
+	}
 
-	if authenticated {
-		role, id, err := getUserInfo(userAuth.Username)
-		if err != nil {
-			c.JSON(err.StatusCode(), gin.H{"error": err.Description()})
-		}
+	user, customErr := store.GetUserByUsername(ctx, userAuth.Username)
+	if customErr != nil {
+		auth.CancelLoginAttempt(ctx, userAuth.Username, device.IpAddress)
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
 
-		token, err := auth.NewToken(userAuth.Username, role, id)
-		if err != nil {
-			c.JSON(err.StatusCode(), gin.H{"error": err.Description()})
-			return
-		}
+	// Failures are only forgotten once the second factor is passed too.
+	if finishSignIn(ctx, c, user, device) {
+		auth.RecordLoginSuccess(ctx, userAuth.Username, device.IpAddress)
+	} else {
+		auth.CancelLoginAttempt(ctx, userAuth.Username, device.IpAddress)
+	}
+}
+
+// finishSignIn responds with a token pair for a user who has proven who they are, or with a
+// challenge when they also have to pass two-factor authentication. It reports whether a
+// session was started.
+func finishSignIn(ctx context.Context, c *gin.Context, user types.User, device auth.Device) bool {
+	customErr := auth.CheckCanSignIn(user)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return false
+	}
+
+	challenge, customErr := auth.StartTwoFactorChallenge(ctx, user)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return false
+	}
+
+	if challenge != "" {
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge_token": challenge})
+		return false
+	}
+
+	token, refreshToken, customErr := auth.StartSession(ctx, user, device)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return false
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	return true
+}
+
+func SignOut(c *gin.Context) {
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
 
-		c.JSON(http.StatusOK, gin.H{"token": token})
+	customErr := auth.SignOut(ctx, auth.GetClaims(c))
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 		return
-	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "login incorrect"})
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func ForgotPassword(c *gin.Context) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	err := c.BindJSON(&request)
+	if err != nil || request.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include email"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	customErr := auth.BeginPasswordResetRequest(ctx, request.Email, auth.GetDevice(c).IpAddress)
+	if customErr != nil {
+		throttledError(c, customErr)
 		return
 	}
+
+	customErr = auth.RequestPasswordReset(ctx, request.Email)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	// The same response whether or not the email belongs to an account, so it can't be used to
+	// find out who has one.
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an account uses that email, a password reset link has been sent to it."})
 }
 
-func isNewUserUnique(newUser types.User) (bool, error) {
-	db, err := database.GetConnection()
-	if err != nil {
-		return false, err
+func ResetPassword(c *gin.Context) {
+	var request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err := c.BindJSON(&request)
+	if err != nil || request.Token == "" || request.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include token and password"})
+		return
 	}
-	defer db.Close()
 
-	var count int
-	err = db.QueryRow("select COUNT(*) FROM users where username = ? OR email = ?", newUser.Username, newUser.Email).Scan(&count)
-	if err != nil {
-		return false, err
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	customErr := auth.ResetPassword(ctx, request.Token, request.Password)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
 	}
 
-	return count == 0, nil
+	c.Status(http.StatusNoContent)
 }
 
-func getUserInfo(username string) (string, string, types.Error) {
-	db, err := database.GetConnection()
-	if err != nil {
-		return "", "", customerrors.New(http.StatusInternalServerError, "could not connect to database")
+func ChangePassword(c *gin.Context) {
+	claims := auth.GetClaims(c)
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	err := c.BindJSON(&request)
+	if err != nil || request.CurrentPassword == "" || request.NewPassword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include current_password and new_password"})
+		return
 	}
-	defer db.Close()
 
-	var role string
-	var id string
-	result := db.QueryRow("select role, id FROM users where username = ?", username)
-	if err != nil {
-		return "", "", customerrors.New(http.StatusInternalServerError, "could not query database")
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	customErr := auth.ChangePassword(ctx, claims, request.CurrentPassword, request.NewPassword)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
 	}
 
-	err = result.Scan(&role, &id)
-	if err != nil {
-		// If an entry with the username does not exist, send an "Unauthorized"(401) status
-		if err == sql.ErrNoRows {
-			return "", "", customerrors.New(http.StatusInternalServerError, "could not find user role in database")
-		}
+	c.Status(http.StatusNoContent)
+}
+
+func VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include token"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	customErr := auth.VerifyEmail(ctx, token)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Your email has been verified."})
+}
+
+func ResendEmailVerification(c *gin.Context) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	err := c.BindJSON(&request)
+	if err != nil || request.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include email"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	customErr := auth.ResendEmailVerification(ctx, request.Email)
+	if customErr != nil {
+		c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an unverified account uses that email, a new verification link has been sent to it."})
+}
+
+func SignInTwoFactor(c *gin.Context) {
+	var request struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+	err := c.BindJSON(&request)
+	if err != nil || request.ChallengeToken == "" || request.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Must include challenge_token and code"})
+		return
+	}
+
+	ctx, cancel := database.NewContext(c.Request.Context())
+	defer cancel()
+
+	token, refreshToken, customErr := auth.CompleteTwoFactorChallenge(ctx, request.ChallengeToken, request.Code, auth.GetDevice(c))
+	if customErr != nil {
+		throttledError(c, customErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+}
 
-		return "", "", customerrors.New(http.StatusInternalServerError, "could not query database")
+// throttledError responds with customErr, telling throttled clients when to try again.
+func throttledError(c *gin.Context, customErr types.Error) {
+	if retryAfter := auth.RetryAfter(customErr); retryAfter > 0 {
+		c.Header("Retry-After", strconv.FormatInt(int64(retryAfter.Seconds()), 10))
 	}
 
-	return role, id, nil
+	c.JSON(customErr.StatusCode(), gin.H{"error": customErr.Description()})
 }
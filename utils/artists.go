@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"context"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/names"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// Matches scoring below MIN_ARTIST_MATCH_SCORE are not worth showing. NEAR_DUPLICATE_SCORE is
+// close enough that a new artist is probably one that already exists.
+const MIN_ARTIST_MATCH_SCORE = 0.5
+const NEAR_DUPLICATE_SCORE = 0.75
+
+// SearchArtists returns up to limit artists whose name or an alias is like query, best match
+// first. Each artist is listed once, with the name that matched best.
+func SearchArtists(ctx context.Context, artists database.ArtistStore, query string, limit int, minScore float64) ([]types.ArtistMatch, types.Error) {
+	normalized := names.Normalize(query)
+	if normalized == "" {
+		return nil, customerrors.New(http.StatusBadRequest, "Must include a name to search for")
+	}
+
+	artistNames, customErr := artists.GetArtistNames(ctx)
+	if customErr != nil {
+		return nil, customErr
+	}
+
+	best := map[int]types.ArtistMatch{}
+	for _, name := range artistNames {
+		score := math.Round(names.Similarity(normalized, name.NormalizedName)*100) / 100
+		if score < minScore {
+			continue
+		}
+
+		if match, ok := best[name.Artist.Id]; ok && match.Score >= score {
+			continue
+		}
+
+		best[name.Artist.Id] = types.ArtistMatch{Artist: name.Artist, MatchedName: name.Name, Score: score}
+	}
+
+	matches := make([]types.ArtistMatch, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Name < matches[j].Name
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches, nil
+}
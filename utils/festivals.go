@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/names"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func NewFestival(ctx context.Context, festivals database.FestivalStore, festival types.Festival) (types.Festival, types.Error) {
+	festival.Name = strings.TrimSpace(festival.Name)
+	if festival.Name == "" {
+		return festival, customerrors.New(http.StatusBadRequest, "Must include name")
+	}
+
+	unique, customErr := festivals.IsFestivalUnique(ctx, festival.Name)
+	if customErr != nil {
+		return festival, customerrors.New(customErr.StatusCode(), "could not determine if festival is unique, "+customErr.Description())
+	}
+
+	if !unique {
+		return festival, customerrors.New(http.StatusConflict, fmt.Sprintf("festival %s already created", festival.Name))
+	}
+
+	festival.Id, customErr = festivals.CreateFestival(ctx, festival)
+	return festival, customErr
+}
+
+func UpdateFestival(ctx context.Context, festivals database.FestivalStore, festival types.Festival) types.Error {
+	if strings.TrimSpace(festival.Name) == "" {
+		return customerrors.New(http.StatusBadRequest, "Must include name")
+	}
+
+	unique, customErr := festivals.IsFestivalUpdateUnique(ctx, festival)
+	if customErr != nil {
+		return customerrors.New(customErr.StatusCode(), "could not determine if update is unique")
+	}
+
+	if !unique {
+		return customerrors.New(http.StatusConflict, "festival already exists")
+	}
+
+	return festivals.UpdateFestival(ctx, festival)
+}
+
+// NewEdition adds a year of festival. The edition is a festival location named after the festival
+// unless it has a name of its own, and its year comes from start_date when it is not given.
+func NewEdition(ctx context.Context, festivals database.FestivalStore, locations database.LocationStore, festival types.Festival, edition types.Location) (types.Location, types.Error) {
+	edition.FestivalId = festival.Id
+	edition.IsFestival = true
+	if strings.TrimSpace(edition.Name) == "" {
+		edition.Name = festival.Name
+	}
+
+	if edition.Year == 0 && edition.StartDate != "" {
+		start, err := time.Parse(DATE_FORMAT, edition.StartDate)
+		if err != nil {
+			return edition, customerrors.New(http.StatusBadRequest, "start_date must be a date formatted as YYYY-MM-DD")
+		}
+		edition.Year = start.Year()
+	}
+
+	if edition.Year == 0 {
+		return edition, customerrors.New(http.StatusBadRequest, "Must include year or start_date")
+	}
+
+	editions, customErr := festivals.GetFestivalEditions(ctx, festival.Id)
+	if customErr != nil {
+		return edition, customErr
+	}
+
+	for _, existing := range editions {
+		if existing.Year == edition.Year {
+			return edition, customerrors.New(http.StatusConflict, fmt.Sprintf("%s already has a %d edition, location %d", festival.Name, edition.Year, existing.Id))
+		}
+	}
+
+	return NewLocation(ctx, locations, festivals, edition)
+}
+
+// SeriesForLocation puts a festival location in a festival series: the one in its festival_id,
+// or else the one named like it without its year, which is created if needed. Other locations
+// are never in a series.
+func SeriesForLocation(ctx context.Context, festivals database.FestivalStore, location types.Location) (types.Location, types.Error) {
+	if !location.IsFestival {
+		location.FestivalId = 0
+		return location, nil
+	}
+
+	if location.FestivalId != 0 {
+		_, customErr := festivals.GetFestival(ctx, strconv.Itoa(location.FestivalId))
+		if customErr != nil && customErr.StatusCode() == http.StatusNotFound {
+			return location, customerrors.New(http.StatusBadRequest, fmt.Sprintf("festival %d not found", location.FestivalId))
+		}
+		return location, customErr
+	}
+
+	name := names.FestivalSeries(location.Name, location.Year)
+	festival, customErr := festivals.GetFestivalByName(ctx, name)
+	if customErr != nil && customErr.StatusCode() == http.StatusNotFound {
+		festival, customErr = NewFestival(ctx, festivals, types.Festival{Name: name})
+	}
+	if customErr != nil {
+		return location, customErr
+	}
+
+	location.FestivalId = festival.Id
+	return location, nil
+}
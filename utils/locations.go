@@ -1,156 +1,140 @@
 package utils
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"github.com/AnthonyNixon/setsisaw/customerrors"
 	"github.com/AnthonyNixon/setsisaw/database"
 	"github.com/AnthonyNixon/setsisaw/types"
-	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-func NewLocation(location types.Location) types.Error {
-	unique, err := isNewLocationUnique(location)
-	if err != nil {
-		return customerrors.New(http.StatusInternalServerError, "could not determine if location is unique, "+err.Error())
-	}
-
-	if !unique {
-		return customerrors.New(http.StatusConflict, fmt.Sprintf("location with ID %d already created", location.Id))
-	}
-
-	db, err := database.GetConnection()
-	if err != nil {
-		return customerrors.New(http.StatusInternalServerError, "could not get database connection, "+err.Error())
-	}
-	defer db.Close()
+const DATE_FORMAT = "2006-01-02"
 
-	stmt, err := db.Prepare(database.INSERT_NEW_LOCATION)
-	if err != nil {
-		return customerrors.New(http.StatusInternalServerError, "could not prepare db statement, "+err.Error())
+// NewLocation creates a location, adding festivals to their series, see SeriesForLocation.
+func NewLocation(ctx context.Context, locations database.LocationStore, festivals database.FestivalStore, location types.Location) (types.Location, types.Error) {
+	customErr := checkLocationDates(location)
+	if customErr != nil {
+		return location, customErr
 	}
 
-	_, err = stmt.Exec(location.Name, location.Description, location.City, location.State, location.Country, location.IsFestival, location.Year)
-	if err != nil {
-		return customerrors.New(http.StatusInternalServerError, "error executing insert statement, "+err.Error())
+	unique, customErr := locations.IsLocationUnique(ctx, location)
+	if customErr != nil {
+		return location, customerrors.New(customErr.StatusCode(), "could not determine if location is unique, "+customErr.Description())
 	}
 
-	return nil
-}
-
-func GetLocation(id string) (types.Location, types.Error) {
-	var location types.Location
-	// if we made it here, we're good to go.
-	db, err := database.GetConnection()
-	if err != nil {
-		return location, customerrors.New(http.StatusInternalServerError, err.Error())
+	if !unique {
+		return location, customerrors.New(http.StatusConflict, fmt.Sprintf("location %s already created", location.Name))
 	}
-	defer db.Close()
 
-	result := db.QueryRow(database.GET_SPECIFIC_LOCATION, id)
-	if err != nil {
-		return location, customerrors.New(http.StatusInternalServerError, "failed getting location, "+err.Error())
+	location, customErr = SeriesForLocation(ctx, festivals, location)
+	if customErr != nil {
+		return location, customErr
 	}
 
-	err = result.Scan(&location.Id, &location.Name, &location.Description, &location.City, &location.State, &location.Country, &location.IsFestival, &location.Year)
-	if err != nil {
-		// If an entry with the username does not exist, send an "Unauthorized"(401) status
-		if err == sql.ErrNoRows {
-			return location, customerrors.New(http.StatusNotFound, "location not found")
-		}
-
-		return location, customerrors.New(http.StatusInternalServerError, err.Error())
+	id, customErr := locations.CreateLocation(ctx, location)
+	if customErr != nil {
+		return location, customErr
 	}
 
+	location.Id = id
 	return location, nil
 }
 
-func GetAllLocations() ([]types.Location, types.Error) {
-	location := types.Location{}
-	locations := make([]types.Location, 0)
+func GetLocation(ctx context.Context, locations database.LocationStore, id string) (types.Location, types.Error) {
+	return locations.GetLocation(ctx, id)
+}
 
-	db, err := database.GetConnection()
-	if err != nil {
-		return nil, customerrors.New(http.StatusInternalServerError, "could not get database connection, "+err.Error())
-	}
-	defer db.Close()
+func GetAllLocations(ctx context.Context, locations database.LocationStore, page types.PageRequest) ([]types.Location, types.PageInfo, types.Error) {
+	return locations.GetAllLocations(ctx, page)
+}
 
-	rows, err := db.Query(database.GET_ALL_LOCATIONS)
-	if err != nil {
-		return nil, customerrors.New(http.StatusInternalServerError, "could not query database, "+err.Error())
+func DeleteLocation(ctx context.Context, locations database.LocationStore, id int, cascade bool) (int, types.Error) {
+	return locations.DeleteLocation(ctx, id, cascade)
+}
+
+// MergeLocations folds duplicate locations, like a venue entered twice or a festival edition
+// added under two names, into the location with id.
+func MergeLocations(ctx context.Context, locations database.LocationStore, id int, duplicateIds []int) (types.LocationMerge, types.Error) {
+	if len(duplicateIds) == 0 {
+		return types.LocationMerge{}, customerrors.New(http.StatusBadRequest, "Must include location_ids to merge")
 	}
 
-	for rows.Next() {
-		err := rows.Scan(&location.Id, &location.Name, &location.Description, &location.City, &location.State, &location.Country, &location.IsFestival, &location.Year)
-		if err != nil {
-			return nil, customerrors.New(http.StatusInternalServerError, "could not scan location row, "+err.Error())
+	seen := map[int]bool{id: true}
+	for _, duplicateId := range duplicateIds {
+		if seen[duplicateId] {
+			return types.LocationMerge{}, customerrors.New(http.StatusBadRequest, fmt.Sprintf("location %d is listed twice or is the location being merged into", duplicateId))
 		}
-		locations = append(locations, location)
+		seen[duplicateId] = true
 	}
-	rows.Close()
 
-	return locations, nil
+	return locations.MergeLocations(ctx, id, duplicateIds)
 }
 
-func UpdateLocation(id string, location types.Location) types.Error {
-	db, err := database.GetConnection()
+// UpdateLocation updates location id, a location in the body must be the same one.
+func UpdateLocation(ctx context.Context, locations database.LocationStore, festivals database.FestivalStore, id string, location types.Location) (types.Location, types.Error) {
+	locationId, err := strconv.Atoi(id)
 	if err != nil {
-		return customerrors.New(http.StatusInternalServerError, "could not get database connection, "+err.Error())
+		return location, customerrors.New(http.StatusBadRequest, "location id must be a number")
 	}
-	defer db.Close()
 
-	unique, err := isLocationUpdateInfoUnique(location)
-	if err != nil {
-		return customerrors.New(http.StatusInternalServerError, "could not determine if update is unique")
+	if location.Id != 0 && location.Id != locationId {
+		return location, customerrors.New(http.StatusBadRequest, fmt.Sprintf("location id %d does not match location %d being updated", location.Id, locationId))
 	}
+	location.Id = locationId
 
-	if !unique {
-		return customerrors.New(http.StatusConflict, "location already exists")
+	customErr := checkLocationDates(location)
+	if customErr != nil {
+		return location, customErr
 	}
 
-	stmt, err := db.Prepare(database.UPDATE_LOCATION)
-	if err != nil {
-		return customerrors.New(http.StatusInternalServerError, "could not prepare statement, "+err.Error())
+	unique, customErr := locations.IsLocationUpdateUnique(ctx, location)
+	if customErr != nil {
+		return location, customerrors.New(customErr.StatusCode(), "could not determine if update is unique")
 	}
 
-	_, err = stmt.Exec(location.Name, location.Description, location.City, location.State, location.Country, location.IsFestival, location.Year, location.Id)
-	if err != nil {
-		return customerrors.New(http.StatusInternalServerError, "could not execute statement, "+err.Error())
+	if !unique {
+		return location, customerrors.New(http.StatusConflict, "location already exists")
 	}
 
-	return nil
+	location, customErr = SeriesForLocation(ctx, festivals, location)
+	if customErr != nil {
+		return location, customErr
+	}
+
+	return location, locations.UpdateLocation(ctx, location)
 }
 
-func isLocationUpdateInfoUnique(location types.Location) (bool, error) {
-	db, err := database.GetConnection()
-	if err != nil {
-		return false, err
-	}
-	defer db.Close()
+// checkLocationDates enforces that a festival edition's start_date and end_date are dates, in
+// order, and in its year.
+func checkLocationDates(location types.Location) types.Error {
+	var start, end time.Time
+	for name, value := range map[string]string{"start_date": location.StartDate, "end_date": location.EndDate} {
+		if value == "" {
+			continue
+		}
 
-	var count int
-	err = db.QueryRow(database.IS_LOCATION_UPDATE_UNIQUE, location.Id, location.Name, location.City, location.State, location.Country, location.Year).Scan(&count)
-	if err != nil {
-		return false, err
-	}
+		date, err := time.Parse(DATE_FORMAT, value)
+		if err != nil {
+			return customerrors.New(http.StatusBadRequest, fmt.Sprintf("%s must be a date formatted as YYYY-MM-DD", name))
+		}
 
-	log.Printf("Unique count: %d", count)
-	return count == 0, nil
-}
+		if location.Year != 0 && date.Year() != location.Year {
+			return customerrors.New(http.StatusBadRequest, fmt.Sprintf("%s must be in %d", name, location.Year))
+		}
 
-func isNewLocationUnique(newLocation types.Location) (bool, error) {
-	db, err := database.GetConnection()
-	if err != nil {
-		return false, err
+		if name == "start_date" {
+			start = date
+		} else {
+			end = date
+		}
 	}
-	defer db.Close()
 
-	var count int
-	err = db.QueryRow(database.IS_LOCATION_UNIQUE_QUERY, newLocation.Name, newLocation.City, newLocation.State, newLocation.Country, newLocation.Year).Scan(&count)
-	if err != nil {
-		return false, err
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return customerrors.New(http.StatusBadRequest, "end_date must not be before start_date")
 	}
 
-	return count == 0, nil
+	return nil
 }
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"github.com/AnthonyNixon/setsisaw/customerrors"
+	"github.com/AnthonyNixon/setsisaw/database"
+	"github.com/AnthonyNixon/setsisaw/types"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func NewStage(ctx context.Context, stages database.StageStore, stage types.Stage) (types.Stage, types.Error) {
+	customErr := checkStageName(ctx, stages, stage)
+	if customErr != nil {
+		return stage, customErr
+	}
+
+	stage.Id, customErr = stages.CreateStage(ctx, stage)
+	return stage, customErr
+}
+
+func UpdateStage(ctx context.Context, stages database.StageStore, stage types.Stage) types.Error {
+	customErr := checkStageName(ctx, stages, stage)
+	if customErr != nil {
+		return customErr
+	}
+
+	return stages.UpdateStage(ctx, stage)
+}
+
+// CheckSetStage enforces that a set's stage, when it has one, is a stage of the set's location.
+func CheckSetStage(ctx context.Context, stages database.StageStore, set types.Set) types.Error {
+	if set.StageId == 0 {
+		return nil
+	}
+
+	stage, customErr := stages.GetStage(ctx, strconv.Itoa(set.StageId))
+	if customErr != nil && customErr.StatusCode() == http.StatusNotFound {
+		return customerrors.New(http.StatusBadRequest, fmt.Sprintf("stage %d not found", set.StageId))
+	}
+	if customErr != nil {
+		return customErr
+	}
+
+	if stage.LocationId != set.LocationId {
+		return customerrors.New(http.StatusBadRequest, fmt.Sprintf("stage %d is not a stage of location %d, set stage_id to one of its stages or 0", set.StageId, set.LocationId))
+	}
+
+	return nil
+}
+
+func checkStageName(ctx context.Context, stages database.StageStore, stage types.Stage) types.Error {
+	if strings.TrimSpace(stage.Name) == "" {
+		return customerrors.New(http.StatusBadRequest, "Must include name")
+	}
+
+	unique, customErr := stages.IsStageUnique(ctx, stage)
+	if customErr != nil {
+		return customerrors.New(customErr.StatusCode(), "could not determine if stage is unique, "+customErr.Description())
+	}
+
+	if !unique {
+		return customerrors.New(http.StatusConflict, fmt.Sprintf("location %d already has a stage named %s", stage.LocationId, stage.Name))
+	}
+
+	return nil
+}